@@ -0,0 +1,156 @@
+// Package approvals keeps the logins which wait until their user approves
+// them in the manager, as a second factor besides the verification code.
+package approvals
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/clusterit/orca/common"
+	"github.com/clusterit/orca/etcd"
+	cetcd "github.com/coreos/go-etcd/etcd"
+)
+
+const approvalsPath = "/approvals"
+
+// The states of an approval.
+const (
+	Pending  = "pending"
+	Approved = "approved"
+	Denied   = "denied"
+)
+
+// An Approval is a login of a user which waits for the decision of the
+// user. Autologin is the maximum allowance in seconds the approval may
+// grant, like the verification code does.
+type Approval struct {
+	Id        string    `json:"id"`
+	Uid       string    `json:"uid"`
+	Zone      string    `json:"zone"`
+	Source    string    `json:"source"`
+	Created   time.Time `json:"created"`
+	Until     time.Time `json:"until"`
+	Autologin int       `json:"autologin"`
+	State     string    `json:"state"`
+}
+
+// Approvals stores the pending logins until they are decided or expire.
+type Approvals interface {
+	Request(a Approval, ttl uint64) (*Approval, error)
+	Get(id string) (*Approval, error)
+	List(uid string) ([]Approval, error)
+	Decide(id, uid string, approve bool) (*Approval, error)
+	Wait(id string, timeout time.Duration) (*Approval, error)
+	Remove(id string) error
+}
+
+type etcdApprovals struct {
+	persister etcd.Persister
+}
+
+// Create a new approval store in etcd.
+func New(cl *etcd.Cluster) (Approvals, error) {
+	p, e := cl.NewJsonPersister(approvalsPath)
+	if e != nil {
+		return nil, e
+	}
+	return &etcdApprovals{persister: p}, nil
+}
+
+// Store a new pending approval which expires after ttl seconds.
+func (e *etcdApprovals) Request(a Approval, ttl uint64) (*Approval, error) {
+	a.Id = common.GenerateUUID()
+	a.State = Pending
+	a.Created = time.Now().UTC()
+	a.Until = a.Created.Add(time.Duration(ttl) * time.Second)
+	if err := e.persister.PutTtl(a.Id, ttl, a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (e *etcdApprovals) Get(id string) (*Approval, error) {
+	var a Approval
+	if err := e.persister.Get(id, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// List the pending approvals of the user.
+func (e *etcdApprovals) List(uid string) ([]Approval, error) {
+	var all []Approval
+	err := e.persister.GetAll(true, false, &all)
+	if common.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res []Approval
+	for _, a := range all {
+		if a.Uid == uid && a.State == Pending {
+			res = append(res, a)
+		}
+	}
+	return res, nil
+}
+
+// Approve or deny a pending approval of the user. The decision is kept
+// until the approval expires.
+func (e *etcdApprovals) Decide(id, uid string, approve bool) (*Approval, error) {
+	a, err := e.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if a.Uid != uid {
+		return nil, common.ErrNotFound
+	}
+	if a.State != Pending {
+		return nil, fmt.Errorf("the login is already %s", a.State)
+	}
+	left := a.Until.Sub(time.Now())
+	if left < time.Second {
+		return nil, fmt.Errorf("the login has expired")
+	}
+	a.State = Denied
+	if approve {
+		a.State = Approved
+	}
+	if err := e.persister.PutTtl(a.Id, uint64(left/time.Second), a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Wait until the approval is decided. If it is still pending after the
+// timeout the pending approval is returned.
+func (e *etcdApprovals) Wait(id string, timeout time.Duration) (*Approval, error) {
+	stop := make(chan bool)
+	rsp := make(chan *cetcd.Response)
+	go e.persister.RawClient().Watch(e.persister.Path(id), 0, false, rsp, stop)
+	defer func() {
+		close(stop)
+		// the watch closes the channel when it stops
+		for range rsp {
+		}
+	}()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	for {
+		// the decision may be stored before the watch has started
+		a, err := e.Get(id)
+		if err != nil || a.State != Pending {
+			return a, err
+		}
+		select {
+		case <-rsp:
+		case <-t.C:
+			return a, nil
+		}
+	}
+}
+
+func (e *etcdApprovals) Remove(id string) error {
+	return e.persister.Remove(id)
+}
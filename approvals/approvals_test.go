@@ -0,0 +1,64 @@
+package approvals
+
+import (
+	"testing"
+	"time"
+
+	"github.com/clusterit/orca/common"
+	"github.com/clusterit/orca/testsupport"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestApprovals(t *testing.T) {
+	ts, e := testsupport.New()
+	if e != nil {
+		t.Fatalf("cannot init etcd container: %s", e)
+	}
+	cluster, e := ts.StartEtcd()
+	if e != nil {
+		t.Fatalf("cannot start etcd container: %s", e)
+	}
+	defer ts.StopEtcd()
+	approvals, e := New(cluster)
+	if e != nil {
+		t.Fatalf("cannot create approval store: %s", e)
+	}
+
+	Convey("Request an approval", t, func() {
+		a, err := approvals.Request(Approval{Uid: "alice@network", Zone: "intranet", Source: "10.0.0.1", Autologin: 60}, 30)
+		So(err, ShouldBeNil)
+		So(a.State, ShouldEqual, Pending)
+		Convey("the user sees it", func() {
+			all, err := approvals.List("alice@network")
+			So(err, ShouldBeNil)
+			So(len(all), ShouldEqual, 1)
+			So(all[0].Id, ShouldEqual, a.Id)
+			none, err := approvals.List("bob@network")
+			So(err, ShouldBeNil)
+			So(len(none), ShouldEqual, 0)
+		})
+		Convey("other users cannot decide it", func() {
+			_, err := approvals.Decide(a.Id, "bob@network", true)
+			So(common.IsNotFound(err), ShouldBeTrue)
+		})
+		Convey("a pending approval times out", func() {
+			w, err := approvals.Wait(a.Id, 100*time.Millisecond)
+			So(err, ShouldBeNil)
+			So(w.State, ShouldEqual, Pending)
+		})
+		Convey("the waiting gateway gets the decision", func() {
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				approvals.Decide(a.Id, "alice@network", true)
+			}()
+			w, err := approvals.Wait(a.Id, 10*time.Second)
+			So(err, ShouldBeNil)
+			So(w.State, ShouldEqual, Approved)
+			_, err = approvals.Decide(a.Id, "alice@network", false)
+			So(err, ShouldNotBeNil)
+		})
+		Reset(func() {
+			approvals.Remove(a.Id)
+		})
+	})
+}
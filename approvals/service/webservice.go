@@ -0,0 +1,78 @@
+package service
+
+import (
+	"github.com/clusterit/orca/approvals"
+	"github.com/clusterit/orca/auth"
+	"github.com/clusterit/orca/rest"
+	"github.com/clusterit/orca/users"
+	"gopkg.in/emicklei/go-restful.v1"
+)
+
+type ApprovalService struct {
+	Auth      auth.Auther
+	Users     users.Users
+	Approvals approvals.Approvals
+}
+
+func (t *ApprovalService) Shutdown() error {
+	return nil
+}
+
+func (t *ApprovalService) Register(root string, c *restful.Container) {
+	ws := new(restful.WebService)
+
+	usr := users.CheckUser(t.Auth, t.Users, users.UserRoles, nil)
+
+	ws.
+		Path(root + "approvals").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	ws.Route(ws.GET("/").To(usr(t.listApprovals)).
+		Doc("List the logins of the current user which wait for an approval").
+		Operation("listApprovals").
+		Writes([]approvals.Approval{}))
+
+	ws.Route(ws.PUT("/{id}/approve").To(usr(t.approve)).
+		Doc("Approve a login of the current user").
+		Param(ws.PathParameter("id", "the id of the approval").DataType("string")).
+		Operation("approve").
+		Writes(approvals.Approval{}))
+
+	ws.Route(ws.PUT("/{id}/deny").To(usr(t.deny)).
+		Doc("Deny a login of the current user").
+		Param(ws.PathParameter("id", "the id of the approval").DataType("string")).
+		Operation("deny").
+		Writes(approvals.Approval{}))
+
+	c.Add(ws)
+}
+
+func (t *ApprovalService) listApprovals(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	rest.HandleEntity(t.Approvals.List(u.Id))(rq, rsp)
+}
+
+// Approve the login and grant the autologin window of the user like a
+// verification code does.
+func (t *ApprovalService) approve(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	a, err := t.Approvals.Decide(rq.PathParameter("id"), u.Id, true)
+	if err != nil {
+		rest.HandleError(err, rsp)
+		return
+	}
+	permit := u.AutologinAfter2FA
+	if a.Autologin < permit {
+		permit = a.Autologin
+	}
+	if permit > 0 {
+		if err := t.Users.Permit(users.Allowance{GrantedBy: u.Id, Uid: u.Id}, uint64(permit)); err != nil {
+			rest.HandleError(err, rsp)
+			return
+		}
+	}
+	rsp.WriteEntity(a)
+}
+
+func (t *ApprovalService) deny(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	rest.HandleEntity(t.Approvals.Decide(rq.PathParameter("id"), u.Id, false))(rq, rsp)
+}
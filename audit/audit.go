@@ -0,0 +1,88 @@
+// Package audit keeps the actions of the users on the gateways, so a
+// manager can check later who forwarded which ports or opened which
+// tunnels.
+package audit
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/clusterit/orca/common"
+	"github.com/clusterit/orca/etcd"
+)
+
+const (
+	auditPath = "/audit"
+
+	// the events are kept for 30 days
+	DefaultRetention = 30 * 24 * 60 * 60
+)
+
+// The types of the audited events.
+const (
+	RemoteForward       = "remote-forward"
+	CancelRemoteForward = "cancel-remote-forward"
+	Tunnel              = "tunnel"
+	Sftp                = "sftp"
+	Scp                 = "scp"
+	Shadow              = "shadow"
+	Approval            = "approval"
+	Reset2FA            = "2fa-reset"
+	KeyOptions          = "key-options"
+)
+
+// An Event is an action of a user on a gateway.
+type Event struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Zone    string    `json:"zone"`
+	Session string    `json:"session"`
+	Type    string    `json:"type"`
+	// the file operation of a transfer, like open, read or write
+	Op      string `json:"op,omitempty"`
+	Target  string `json:"target"`
+	Allowed bool   `json:"allowed"`
+	Message string `json:"message,omitempty"`
+	// the bytes sent to and received from the target
+	BytesOut int64 `json:"bytesout,omitempty"`
+	BytesIn  int64 `json:"bytesin,omitempty"`
+}
+
+// An Auditor stores the events per user.
+type Auditor interface {
+	Record(ev Event) error
+	Events(uid string) ([]Event, error)
+}
+
+type etcdAuditor struct {
+	persister etcd.Persister
+	retention uint64
+}
+
+// Create a new auditor which keeps the events for retention seconds in
+// etcd.
+func New(cl *etcd.Cluster, retention uint64) (Auditor, error) {
+	p, e := cl.NewJsonPersister(auditPath)
+	if e != nil {
+		return nil, e
+	}
+	return &etcdAuditor{persister: p, retention: retention}, nil
+}
+
+func (a *etcdAuditor) Record(ev Event) error {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	k := fmt.Sprintf("%s/%020d-%s", url.QueryEscape(ev.User), ev.Time.UnixNano(), common.GenerateUUID())
+	return a.persister.PutTtl(k, a.retention, ev)
+}
+
+func (a *etcdAuditor) Events(uid string) ([]Event, error) {
+	var res []Event
+	err := a.persister.Chdir(url.QueryEscape(uid)).GetAll(true, false, &res)
+	if common.IsNotFound(err) {
+		return nil, nil
+	}
+	return res, err
+}
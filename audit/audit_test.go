@@ -0,0 +1,42 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/clusterit/orca/testsupport"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAudit(t *testing.T) {
+	ts, e := testsupport.New()
+	if e != nil {
+		t.Fatalf("cannot init etcd container: %s", e)
+	}
+	cluster, e := ts.StartEtcd()
+	if e != nil {
+		t.Fatalf("cannot start etcd container: %s", e)
+	}
+	defer ts.StopEtcd()
+	auditor, e := New(cluster, DefaultRetention)
+	if e != nil {
+		t.Fatalf("cannot create auditor: %s", e)
+	}
+
+	Convey("Record some events", t, func() {
+		now := time.Now()
+		So(auditor.Record(Event{Time: now, User: "id@network", Type: RemoteForward, Target: "localhost:8080", Allowed: true}), ShouldBeNil)
+		So(auditor.Record(Event{Time: now.Add(time.Second), User: "id@network", Type: CancelRemoteForward, Target: "localhost:8080", Allowed: true}), ShouldBeNil)
+		So(auditor.Record(Event{User: "other@network", Type: RemoteForward, Target: "0.0.0.0:22"}), ShouldBeNil)
+		Convey("and read them per user", func() {
+			evs, err := auditor.Events("id@network")
+			So(err, ShouldBeNil)
+			So(len(evs), ShouldEqual, 2)
+			So(evs[0].Type, ShouldEqual, RemoteForward)
+			So(evs[1].Type, ShouldEqual, CancelRemoteForward)
+			evs, err = auditor.Events("unknown@network")
+			So(err, ShouldBeNil)
+			So(len(evs), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"github.com/clusterit/orca/audit"
+	"github.com/clusterit/orca/auth"
+	"github.com/clusterit/orca/rest"
+	"github.com/clusterit/orca/users"
+	"gopkg.in/emicklei/go-restful.v1"
+)
+
+type AuditService struct {
+	Auth    auth.Auther
+	Users   users.Users
+	Auditor audit.Auditor
+}
+
+func (t *AuditService) Shutdown() error {
+	return nil
+}
+
+func (t *AuditService) Register(root string, c *restful.Container) {
+	ws := new(restful.WebService)
+
+	mgr := users.CheckUser(t.Auth, t.Users, users.ManagerRoles, nil)
+
+	ws.
+		Path(root + "audit").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	ws.Route(ws.GET("/{uid}").To(mgr(t.getEvents)).
+		Doc("Get the audited events of a user").
+		Param(ws.PathParameter("uid", "the id of the user").DataType("string")).
+		Operation("getEvents").
+		Writes([]audit.Event{}))
+
+	c.Add(ws)
+}
+
+func (t *AuditService) getEvents(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	uid := rq.PathParameter("uid")
+	rest.HandleEntity(t.Auditor.Events(uid))(rq, rsp)
+}
@@ -22,7 +22,7 @@ type AuthRegistration struct {
 	Type           ProviderType `json:"type"`
 	Network        string       `json:"network"`
 	ClientId       string       `json:"clientid"`
-	ClientSecret   string       `json:"clientsecret"`
+	ClientSecret   string       `json:"clientsecret" secret:"true"`
 	Scopes         string       `json:"scopes"`
 	AuthUrl        string       `json:"auth_url"`
 	AccessTokenUrl string       `json:"accesstoken_url"`
@@ -109,6 +109,26 @@ func (a *oauthApp) GetAll() ([]AuthRegistration, error) {
 	return res, a.persist.GetAll(true, false, &res)
 }
 
+// Write all registrations again, so their plaintext client secrets are
+// sealed with the keyring of the cluster. Returns the number of written
+// registrations.
+func SealSecrets(cc *etcd.Cluster) (int, error) {
+	pers, err := cc.NewJsonPersister(oauthPath)
+	if err != nil {
+		return 0, err
+	}
+	var regs []AuthRegistration
+	if err := pers.GetAll(true, false, &regs); err != nil {
+		return 0, err
+	}
+	for i, r := range regs {
+		if err := pers.Put(r.Network, r); err != nil {
+			return i, err
+		}
+	}
+	return len(regs), nil
+}
+
 func (t *AuthRegService) Shutdown() {
 }
 
@@ -0,0 +1,96 @@
+// Package bans keeps the source addresses and logins which are banned
+// from the gateways, so all gateways of a cluster share them.
+package bans
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/clusterit/orca/common"
+	"github.com/clusterit/orca/etcd"
+)
+
+const bansPath = "/bans"
+
+// A Ban locks out a source address or a login until a given time. Count
+// is the number of bans in a row, every further ban lasts longer.
+type Ban struct {
+	Key    string    `json:"key"`
+	Reason string    `json:"reason"`
+	Zone   string    `json:"zone"`
+	Until  time.Time `json:"until"`
+	Count  int       `json:"count"`
+}
+
+// The key of a banned source address.
+func AddressKey(ip string) string {
+	return "ip:" + ip
+}
+
+// The key of a banned user, the id of the user.
+func LoginKey(uid string) string {
+	return "user:" + uid
+}
+
+// Returns true if the ban is not over.
+func (b *Ban) Active() bool {
+	return time.Now().Before(b.Until)
+}
+
+// Bans stores the bans. A ban is kept longer than it lasts, so the next
+// ban of the same key knows how often it was banned before.
+type Bans interface {
+	Ban(b Ban, keep uint64) error
+	Lift(key string) error
+	Get(key string) (*Ban, error)
+	List() ([]Ban, error)
+}
+
+type etcdBans struct {
+	persister etcd.Persister
+}
+
+// Create a new ban store in etcd.
+func New(cl *etcd.Cluster) (Bans, error) {
+	p, e := cl.NewJsonPersister(bansPath)
+	if e != nil {
+		return nil, e
+	}
+	return &etcdBans{persister: p}, nil
+}
+
+// Store the ban for keep seconds.
+func (e *etcdBans) Ban(b Ban, keep uint64) error {
+	return e.persister.PutTtl(url.QueryEscape(b.Key), keep, b)
+}
+
+func (e *etcdBans) Lift(key string) error {
+	return e.persister.Remove(url.QueryEscape(key))
+}
+
+func (e *etcdBans) Get(key string) (*Ban, error) {
+	var b Ban
+	if err := e.persister.Get(url.QueryEscape(key), &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// List the active bans.
+func (e *etcdBans) List() ([]Ban, error) {
+	var all []Ban
+	err := e.persister.GetAll(true, false, &all)
+	if common.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res []Ban
+	for _, b := range all {
+		if b.Active() {
+			res = append(res, b)
+		}
+	}
+	return res, nil
+}
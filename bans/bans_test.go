@@ -0,0 +1,48 @@
+package bans
+
+import (
+	"testing"
+	"time"
+
+	"github.com/clusterit/orca/common"
+	"github.com/clusterit/orca/testsupport"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBans(t *testing.T) {
+	ts, e := testsupport.New()
+	if e != nil {
+		t.Fatalf("cannot init etcd container: %s", e)
+	}
+	cluster, e := ts.StartEtcd()
+	if e != nil {
+		t.Fatalf("cannot start etcd container: %s", e)
+	}
+	defer ts.StopEtcd()
+	bans, e := New(cluster)
+	if e != nil {
+		t.Fatalf("cannot create ban store: %s", e)
+	}
+
+	Convey("Ban an address and a login", t, func() {
+		So(bans.Ban(Ban{Key: AddressKey("10.0.0.1"), Reason: "failed logins", Until: time.Now().Add(time.Minute), Count: 1}, 600), ShouldBeNil)
+		So(bans.Ban(Ban{Key: LoginKey("alice"), Reason: "failed logins", Until: time.Now().Add(-time.Minute), Count: 2}, 600), ShouldBeNil)
+		Convey("only the active bans are listed", func() {
+			all, err := bans.List()
+			So(err, ShouldBeNil)
+			So(len(all), ShouldEqual, 1)
+			So(all[0].Key, ShouldEqual, "ip:10.0.0.1")
+		})
+		Convey("expired bans are remembered", func() {
+			b, err := bans.Get(LoginKey("alice"))
+			So(err, ShouldBeNil)
+			So(b.Active(), ShouldBeFalse)
+			So(b.Count, ShouldEqual, 2)
+		})
+		Convey("lifted bans are gone", func() {
+			So(bans.Lift(AddressKey("10.0.0.1")), ShouldBeNil)
+			_, err := bans.Get(AddressKey("10.0.0.1"))
+			So(common.IsNotFound(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"github.com/clusterit/orca/auth"
+	"github.com/clusterit/orca/bans"
+	"github.com/clusterit/orca/rest"
+	"github.com/clusterit/orca/users"
+	"gopkg.in/emicklei/go-restful.v1"
+)
+
+type BanService struct {
+	Auth  auth.Auther
+	Users users.Users
+	Bans  bans.Bans
+}
+
+func (t *BanService) Shutdown() error {
+	return nil
+}
+
+func (t *BanService) Register(root string, c *restful.Container) {
+	ws := new(restful.WebService)
+
+	mgr := users.CheckUser(t.Auth, t.Users, users.ManagerRoles, nil)
+
+	ws.
+		Path(root + "bans").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	ws.Route(ws.GET("/").To(mgr(t.listBans)).
+		Doc("List the banned addresses and logins").
+		Operation("listBans").
+		Writes([]bans.Ban{}))
+
+	ws.Route(ws.DELETE("/{key}").To(mgr(t.liftBan)).
+		Doc("Lift a ban").
+		Param(ws.PathParameter("key", "the banned address (ip:...) or login (user:...)").DataType("string")).
+		Operation("liftBan"))
+
+	c.Add(ws)
+}
+
+func (t *BanService) listBans(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	rest.HandleEntity(t.Bans.List())(rq, rsp)
+}
+
+func (t *BanService) liftBan(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	key := rq.PathParameter("key")
+	if err := t.Bans.Lift(key); err != nil {
+		rest.HandleError(err, rsp)
+		return
+	}
+	rsp.WriteEntity(key)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var approvalsCmd = &cobra.Command{
+	Use:   "approvals",
+	Short: "list your logins which wait for an approval",
+	Long:  "list your logins at the gateways which wait until you approve or deny them",
+	Run: func(cmd *cobra.Command, args []string) {
+		c := newCli()
+		res, err := c.listApprovals()
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
+
+var approveCmd = &cobra.Command{
+	Use:   "approve [# id]",
+	Short: "approve a login",
+	Long:  "approve your login with the id the gateway shows",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		c := newCli()
+		res, err := c.decideApproval(args[0], true)
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
+
+var denyCmd = &cobra.Command{
+	Use:   "deny [# id]",
+	Short: "deny a login",
+	Long:  "deny a login with the id the gateway shows, e.g. if it was not you",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		c := newCli()
+		res, err := c.decideApproval(args[0], false)
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
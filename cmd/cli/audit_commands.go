@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit [# uid]",
+	Short: "show the audited events of a user",
+	Long:  "show the audited events of a user, e.g. the remote port forwardings",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		c := newCli()
+		res, err := c.auditEvents(args[0])
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var bansCmd = &cobra.Command{
+	Use:   "bans",
+	Short: "show and lift the bans",
+	Long:  "show the addresses and logins which are banned from the gateways and lift the bans",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var bansList = &cobra.Command{
+	Use:   "list",
+	Short: "list the active bans",
+	Long:  "list the banned addresses and logins of all gateways",
+	Run: func(cmd *cobra.Command, args []string) {
+		c := newCli()
+		res, err := c.listBans()
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
+
+var bansLift = &cobra.Command{
+	Use:   "lift [# key]",
+	Short: "lift a ban",
+	Long:  "lift the ban of an address (ip:10.0.0.1) or a login (user:alice)",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		c := newCli()
+		exitWhenError(c.liftBan(args[0]))
+	},
+}
+
+func init() {
+	bansCmd.AddCommand(bansList, bansLift)
+}
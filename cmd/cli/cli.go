@@ -3,9 +3,14 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"net/url"
 
+	"github.com/clusterit/orca/approvals"
+	"github.com/clusterit/orca/audit"
 	"github.com/clusterit/orca/auth/oauth"
+	"github.com/clusterit/orca/bans"
 	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/sessions"
 	"github.com/clusterit/orca/users"
 
 	"github.com/jmcvetta/napping"
@@ -82,6 +87,27 @@ func (c *cli) deleteKey(uid, keyname string) error {
 	return c.unmarshal(r, nil)
 }
 
+func (c *cli) setKeyOptions(uid, keyname string, opts []string) (*users.Key, error) {
+	var res users.Key
+	if opts == nil {
+		opts = []string{}
+	}
+	r := c.rq("PUT", fmt.Sprintf("/api/users/%s/keys/%s/options", uid, keyname), opts)
+	return &res, c.unmarshal(r, &res)
+}
+
+func (c *cli) scratchCodes() ([]string, error) {
+	var res []string
+	r := c.rq("POST", "/api/users/2fa/scratchcodes", nil)
+	return res, c.unmarshal(r, &res)
+}
+
+func (c *cli) reset2FA(uid string) (*users.User, error) {
+	var res users.User
+	r := c.rq("DELETE", fmt.Sprintf("/api/users/%s/2fa", uid), nil)
+	return &res, c.unmarshal(r, &res)
+}
+
 func (c *cli) zones() ([]string, error) {
 	var res []string
 	r := c.rq("GET", "/api/configuration/zones", nil)
@@ -99,6 +125,126 @@ func (c *cli) putGateway(stage string, gw config.Gateway) error {
 	return c.unmarshal(r, nil)
 }
 
+func (c *cli) getCAPublicKey(zone string) (string, error) {
+	var res string
+	r := c.rq("GET", fmt.Sprintf("/api/configuration/%s/ca", zone), nil)
+	return res, c.unmarshal(r, &res)
+}
+
+func (c *cli) listPolicies(zone string) ([]config.Policy, error) {
+	var res []config.Policy
+	r := c.rq("GET", fmt.Sprintf("/api/configuration/%s/policies", zone), nil)
+	return res, c.unmarshal(r, &res)
+}
+
+func (c *cli) putPolicy(zone string, p config.Policy) (*config.Policy, error) {
+	var res config.Policy
+	r := c.rq("PUT", fmt.Sprintf("/api/configuration/%s/policies", zone), p)
+	return &res, c.unmarshal(r, &res)
+}
+
+func (c *cli) deletePolicy(zone, id string) error {
+	r := c.rq("DELETE", fmt.Sprintf("/api/configuration/%s/policies/%s", zone, id), nil)
+	return c.unmarshal(r, nil)
+}
+
+func (c *cli) listHosts(zone string) ([]config.Host, error) {
+	var res []config.Host
+	r := c.rq("GET", fmt.Sprintf("/api/configuration/%s/hosts", zone), nil)
+	return res, c.unmarshal(r, &res)
+}
+
+func (c *cli) getHost(zone, name string) (*config.Host, error) {
+	var res config.Host
+	r := c.rq("GET", fmt.Sprintf("/api/configuration/%s/hosts/%s", zone, name), nil)
+	return &res, c.unmarshal(r, &res)
+}
+
+func (c *cli) putHost(zone string, h config.Host) (*config.Host, error) {
+	var res config.Host
+	r := c.rq("PUT", fmt.Sprintf("/api/configuration/%s/hosts", zone), h)
+	return &res, c.unmarshal(r, &res)
+}
+
+func (c *cli) deleteHost(zone, name string) error {
+	r := c.rq("DELETE", fmt.Sprintf("/api/configuration/%s/hosts/%s", zone, name), nil)
+	return c.unmarshal(r, nil)
+}
+
+func (c *cli) auditEvents(uid string) ([]audit.Event, error) {
+	var res []audit.Event
+	r := c.rq("GET", "/api/audit/"+uid, nil)
+	return res, c.unmarshal(r, &res)
+}
+
+func (c *cli) listSessions() ([]sessions.Session, error) {
+	var res []sessions.Session
+	r := c.rq("GET", "/api/sessions/", nil)
+	return res, c.unmarshal(r, &res)
+}
+
+func (c *cli) killSession(id string) error {
+	r := c.rq("DELETE", "/api/sessions/"+id, nil)
+	return c.unmarshal(r, nil)
+}
+
+func (c *cli) listBans() ([]bans.Ban, error) {
+	var res []bans.Ban
+	r := c.rq("GET", "/api/bans/", nil)
+	return res, c.unmarshal(r, &res)
+}
+
+func (c *cli) liftBan(key string) error {
+	r := c.rq("DELETE", "/api/bans/"+url.PathEscape(key), nil)
+	return c.unmarshal(r, nil)
+}
+
+func (c *cli) listApprovals() ([]approvals.Approval, error) {
+	var res []approvals.Approval
+	r := c.rq("GET", "/api/approvals/", nil)
+	return res, c.unmarshal(r, &res)
+}
+
+func (c *cli) decideApproval(id string, approve bool) (*approvals.Approval, error) {
+	decision := "deny"
+	if approve {
+		decision = "approve"
+	}
+	var res approvals.Approval
+	r := c.rq("PUT", fmt.Sprintf("/api/approvals/%s/%s", url.PathEscape(id), decision), nil)
+	return &res, c.unmarshal(r, &res)
+}
+
+func (c *cli) knownHosts(zone string) ([]config.KnownHost, error) {
+	var res []config.KnownHost
+	r := c.rq("GET", fmt.Sprintf("/api/configuration/%s/knownhosts", zone), nil)
+	return res, c.unmarshal(r, &res)
+}
+
+func (c *cli) importKnownHosts(zone, file string) ([]config.KnownHost, error) {
+	kf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var res []config.KnownHost
+	r := c.rq("POST", fmt.Sprintf("/api/configuration/%s/knownhosts", zone), string(kf))
+	return res, c.unmarshal(r, &res)
+}
+
+func (c *cli) changeHostKey(zone, host, file string, revoke bool) (*config.KnownHost, error) {
+	kf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	u := fmt.Sprintf("/api/configuration/%s/knownhosts/%s", zone, url.QueryEscape(host))
+	if revoke {
+		u = u + "/revoke"
+	}
+	var res config.KnownHost
+	r := c.rq("PUT", u, string(kf))
+	return &res, c.unmarshal(r, &res)
+}
+
 func (c *cli) getCluster() (*config.ClusterConfig, error) {
 	var res config.ClusterConfig
 	r := c.rq("GET", fmt.Sprintf("/api/configuration/cluster"), nil)
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
 
+	"github.com/clusterit/orca/config"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
 )
 
 var (
@@ -21,6 +24,37 @@ var (
 	deniedcidrs   string
 	name          string
 	selfregister  string
+	backendauth   string
+	cakeyfile     string
+	certvalidity  int
+	hostkeycheck  string
+	hostcafile    string
+	gwaddress     string
+	remotefwd     string
+	forwardports  string
+	tunnels       string
+	sftpaudit     string
+	sftpreadonly  string
+	sftpprefixes  string
+	idletimeout   int
+	maxsession    int
+	handshake     int
+	roletimeouts  string
+	connrate      int
+	maxfailures   int
+	bantime       int
+	maxbantime    int
+	clientrole    string
+	clientallow   string
+	clientdeny    string
+	clientad      string
+	trustedproxy  string
+	activate      bool
+	hostkeyalgs   string
+	draintime     int
+	securitykeys  string
+	pushapproval  string
+	approvalwait  int
 )
 
 var zones = &cobra.Command{
@@ -89,6 +123,128 @@ var gateway = &cobra.Command{
 			gw.DeniedCidrs = strings.Split(deniedcidrs, ",")
 			update = true
 		}
+		if backendauth != "" {
+			gw.BackendAuth = backendauth
+			update = true
+		}
+		if cakeyfile != "" {
+			kf, err := ioutil.ReadFile(cakeyfile)
+			exitWhenError(err)
+			gw.CAKey = string(kf)
+			update = true
+		}
+		if certvalidity >= 0 {
+			gw.CertValidity = certvalidity
+			update = true
+		}
+		if hostkeycheck != "" {
+			gw.HostKeyCheck = hostkeycheck
+			update = true
+		}
+		if hostcafile != "" {
+			kf, err := ioutil.ReadFile(hostcafile)
+			exitWhenError(err)
+			gw.HostCAKeys = nil
+			for _, l := range strings.Split(string(kf), "\n") {
+				if l = strings.TrimSpace(l); l != "" {
+					gw.HostCAKeys = append(gw.HostCAKeys, l)
+				}
+			}
+			update = true
+		}
+		if gwaddress != "" {
+			gw.Address = gwaddress
+			update = true
+		}
+		if remotefwd != "" {
+			gw.RemoteForward = remotefwd
+			update = true
+		}
+		if forwardports != "" {
+			gw.ForwardPorts = nil
+			for _, p := range strings.Split(forwardports, ",") {
+				port, err := strconv.Atoi(p)
+				exitWhenError(err)
+				gw.ForwardPorts = append(gw.ForwardPorts, port)
+			}
+			update = true
+		}
+		if tunnels != "" {
+			gw.Tunnels = splitList(tunnels)
+			update = true
+		}
+		if sftpaudit != "" {
+			gw.SftpAudit = isTrue(sftpaudit)
+			update = true
+		}
+		if sftpreadonly != "" {
+			gw.SftpReadOnly = isTrue(sftpreadonly)
+			update = true
+		}
+		if sftpprefixes != "" {
+			gw.SftpPrefixes = splitList(sftpprefixes)
+			update = true
+		}
+		if idletimeout >= 0 {
+			gw.IdleTimeout = idletimeout
+			update = true
+		}
+		if maxsession >= 0 {
+			gw.MaxSessionTime = maxsession
+			update = true
+		}
+		if handshake >= 0 {
+			gw.HandshakeTimeout = handshake
+			update = true
+		}
+		if draintime >= 0 {
+			gw.DrainTime = draintime
+			update = true
+		}
+		if roletimeouts != "" {
+			rt, err := parseRoleTimeouts(roletimeouts)
+			exitWhenError(err)
+			gw.RoleTimeouts = rt
+			update = true
+		}
+		if connrate >= 0 {
+			gw.ConnRate = connrate
+			update = true
+		}
+		if maxfailures >= 0 {
+			gw.MaxAuthFailures = maxfailures
+			update = true
+		}
+		if bantime >= 0 {
+			gw.BanTime = bantime
+			update = true
+		}
+		if maxbantime >= 0 {
+			gw.MaxBanTime = maxbantime
+			update = true
+		}
+		if trustedproxy != "" {
+			gw.TrustedProxies = cidrList(trustedproxy)
+			update = true
+		}
+		if pushapproval != "" {
+			gw.PushApproval = isTrue(pushapproval)
+			update = true
+		}
+		if approvalwait >= 0 {
+			gw.ApprovalTimeout = approvalwait
+			update = true
+		}
+		if securitykeys != "" {
+			sk, err := parseRoleSecurityKeys(securitykeys)
+			exitWhenError(err)
+			gw.RoleSecurityKeys = sk
+			update = true
+		}
+		if clientallow != "" || clientdeny != "" || clientad != "" {
+			updateClientRules(gw, clientrole, clientallow, clientdeny, clientad)
+			update = true
+		}
 		if update {
 			if err := c.putGateway(zone, *gw); err != nil {
 				fmt.Printf("%s\n", err)
@@ -100,6 +256,55 @@ var gateway = &cobra.Command{
 	},
 }
 
+var rotateHostKey = &cobra.Command{
+	Use:   "rotate-hostkey [zone]",
+	Short: "rotate the host keys of the gateway of the zone",
+	Long: `generates the next host keys of the gateway which are announced to the clients. OpenSSH
+clients with UpdateHostKeys learn them when they connect. after a while use --activate to
+replace the active host keys with the next keys`,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := newCli()
+		if len(args) < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		zone := args[0]
+		gw, err := c.getGateway(zone)
+		exitWhenError(err)
+		if activate {
+			exitWhenError(gw.ActivateHostKeys())
+		} else {
+			exitWhenError(gw.PrepareHostKeys(splitList(hostkeyalgs)))
+		}
+		exitWhenError(c.putGateway(zone, *gw))
+		keys := gw.NextHostKeys
+		if activate {
+			keys = gw.ActiveHostKeys()
+		}
+		for _, k := range keys {
+			s, err := ssh.ParsePrivateKey([]byte(k))
+			exitWhenError(err)
+			fmt.Print(string(ssh.MarshalAuthorizedKey(s.PublicKey())))
+		}
+	},
+}
+
+var caKey = &cobra.Command{
+	Use:   "ca [zone]",
+	Short: "show the public key of the CA of the zone",
+	Long:  "prints the public key of the CA which signs the user certificates for the backends. put this key into the TrustedUserCAKeys of your backends",
+	Run: func(cmd *cobra.Command, args []string) {
+		c := newCli()
+		if len(args) < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		k, err := c.getCAPublicKey(args[0])
+		exitWhenError(err)
+		fmt.Print(k)
+	},
+}
+
 var cluster = &cobra.Command{
 	Use:   "cluster ",
 	Short: "show or update cluster config",
@@ -135,6 +340,9 @@ var cluster = &cobra.Command{
 }
 
 func init() {
+	rotateHostKey.Flags().BoolVar(&activate, "activate", false, "activate the next host keys")
+	rotateHostKey.Flags().StringVar(&hostkeyalgs, "algorithms", strings.Join(config.DefaultHostKeyAlgorithms, ","), "the algorithms of the next host keys")
+	gateway.AddCommand(rotateHostKey)
 	gateway.Flags().StringVar(&loglevel, "loglevel", "", "the loglevel to set")
 	gateway.Flags().StringVar(&timecheck, "timecheck", "", "update the CheckAllow field [true/false]")
 	gateway.Flags().StringVar(&keyfile, "keyfile", "", "the keyfile for the host key")
@@ -144,6 +352,35 @@ func init() {
 	gateway.Flags().StringVar(&allowdeny, "allowdeny", "", "use 'allow' for allow/deny, 'deny' for deny/allow")
 	gateway.Flags().StringVar(&allowedcidrs, "allowedcidrs", "", "a comma seperated list of allowed cidrs")
 	gateway.Flags().StringVar(&deniedcidrs, "deniedcidrs", "", "a comma seperated list of denied cidrs")
+	gateway.Flags().StringVar(&backendauth, "backendauth", "", "authenticate at the backends with the client 'agent', a 'ca' signed certificate or 'both'")
+	gateway.Flags().StringVar(&cakeyfile, "cakeyfile", "", "the keyfile for the CA which signs the user certificates")
+	gateway.Flags().IntVar(&certvalidity, "certvalidity", -1, "maximum validity in seconds of the user certificates. use -1 to leave it unchanged")
+	gateway.Flags().StringVar(&hostkeycheck, "hostkeycheck", "", "verify the backend host keys with 'tofu', 'strict' or 'ca'")
+	gateway.Flags().StringVar(&hostcafile, "hostcafile", "", "a file with the public keys of the CAs which sign the host keys of the backends")
+	gateway.Flags().StringVar(&remotefwd, "remoteforward", "", "allow remote port forwarding: 'disabled', 'loopback' or 'ports'")
+	gateway.Flags().StringVar(&forwardports, "forwardports", "", "a comma seperated list of the ports which can be forwarded in the mode 'ports'")
+	gateway.Flags().StringVar(&tunnels, "tunnels", "", "a comma seperated list of tunnel destinations (host:port) which are allowed for everyone")
+	gateway.Flags().StringVar(&sftpaudit, "sftpaudit", "", "audit the files transferred with sftp and scp [true/false]")
+	gateway.Flags().StringVar(&sftpreadonly, "sftpreadonly", "", "allow only downloads with sftp and scp [true/false]")
+	gateway.Flags().StringVar(&sftpprefixes, "sftpprefixes", "", "a comma seperated list of absolute paths, sftp and scp can only access files below them")
+	gateway.Flags().IntVar(&idletimeout, "idletimeout", -1, "seconds without traffic before a connection is closed, 0 for the default. use -1 to leave it unchanged")
+	gateway.Flags().IntVar(&maxsession, "maxsessiontime", -1, "maximum duration of a connection in seconds, 0 for no limit. use -1 to leave it unchanged")
+	gateway.Flags().IntVar(&handshake, "handshaketimeout", -1, "seconds a new connection has for the ssh handshake, 0 for the default. use -1 to leave it unchanged")
+	gateway.Flags().IntVar(&draintime, "draintime", -1, "seconds the connections have to end when the gateway stops, 0 for the default. use -1 to leave it unchanged")
+	gateway.Flags().StringVar(&roletimeouts, "roletimeouts", "", "timeouts of roles: a comma seperated list of role=idle:maxsessiontime in seconds, e.g. dba=1800:28800")
+	gateway.Flags().IntVar(&connrate, "connrate", -1, "connections per minute from one address, 0 for the default. use -1 to leave it unchanged")
+	gateway.Flags().IntVar(&maxfailures, "maxauthfailures", -1, "failed logins in a row before an address or login is banned, 0 for the default. use -1 to leave it unchanged")
+	gateway.Flags().IntVar(&bantime, "bantime", -1, "seconds of the first ban, 0 for the default. use -1 to leave it unchanged")
+	gateway.Flags().StringVar(&trustedproxy, "trustedproxies", "", "a comma seperated list of the cidrs of load balancers which send the PROXY protocol, 'none' for an empty list")
+	gateway.Flags().StringVar(&clientrole, "clientrole", "", "the client rules are for users with this role instead of the zone")
+	gateway.Flags().StringVar(&clientad, "clientallowdeny", "", "client addresses: use 'allow' for allow/deny, 'deny' for deny/allow")
+	gateway.Flags().StringVar(&clientallow, "clientallowedcidrs", "", "a comma seperated list of allowed client cidrs, 'none' for an empty list")
+	gateway.Flags().StringVar(&clientdeny, "clientdeniedcidrs", "", "a comma seperated list of denied client cidrs, 'none' for an empty list")
+	gateway.Flags().StringVar(&pushapproval, "pushapproval", "", "2FA users approve their logins in orcaman or with 'cli approve' instead of entering a code [true/false]")
+	gateway.Flags().IntVar(&approvalwait, "approvaltimeout", -1, "seconds a login waits for the approval, 0 for the default. use -1 to leave it unchanged")
+	gateway.Flags().StringVar(&securitykeys, "securitykeys", "", "roles which must login with a security key: a comma seperated list of role=presence or role=verification, 'none' for no role")
+	gateway.Flags().IntVar(&maxbantime, "maxbantime", -1, "maximum seconds of a repeated ban, 0 for the default. use -1 to leave it unchanged")
+	gateway.Flags().StringVar(&gwaddress, "address", "", "the address (host:port) where gateways of other zones reach this gateway")
 
 	cluster.Flags().StringVar(&keyfile, "keyfile", "", "the keyfile for the host key")
 	cluster.Flags().StringVar(&name, "name", "", "the name of the cluster")
@@ -157,3 +394,73 @@ func isNone(s string) bool {
 func isTrue(s string) bool {
 	return strings.ToLower(s) == "true"
 }
+
+// Change the client rules of the zone or of a role. The rules of a role
+// are removed when both lists are empty.
+func updateClientRules(gw *config.Gateway, role, allowed, denied, ad string) {
+	rules := gw.ClientRules
+	if role != "" {
+		rules = gw.RoleClientRules[role]
+	}
+	if ad != "" {
+		rules.AllowDeny = ad == "allow"
+	}
+	if allowed != "" {
+		rules.AllowedCidrs = cidrList(allowed)
+	}
+	if denied != "" {
+		rules.DeniedCidrs = cidrList(denied)
+	}
+	if role == "" {
+		gw.ClientRules = rules
+		return
+	}
+	if gw.RoleClientRules == nil {
+		gw.RoleClientRules = make(map[string]config.ClientRules)
+	}
+	if len(rules.AllowedCidrs) == 0 && len(rules.DeniedCidrs) == 0 {
+		delete(gw.RoleClientRules, role)
+	} else {
+		gw.RoleClientRules[role] = rules
+	}
+}
+
+func cidrList(s string) []string {
+	if s == "none" {
+		return nil
+	}
+	return splitList(s)
+}
+
+// parse a list of role=presence or role=verification
+func parseRoleSecurityKeys(s string) (map[string]string, error) {
+	res := make(map[string]string)
+	if s == "none" {
+		return res, nil
+	}
+	for _, rs := range splitList(s) {
+		parts := strings.SplitN(rs, "=", 2)
+		if len(parts) != 2 || (parts[1] != config.KeyPresence && parts[1] != config.KeyVerification) {
+			return nil, fmt.Errorf("illegal security key requirement %q, use role=%s or role=%s", rs, config.KeyPresence, config.KeyVerification)
+		}
+		res[parts[0]] = parts[1]
+	}
+	return res, nil
+}
+
+// parse a list of role=idle:maxsessiontime
+func parseRoleTimeouts(s string) (map[string]config.Timeouts, error) {
+	res := make(map[string]config.Timeouts)
+	for _, rt := range splitList(s) {
+		parts := strings.SplitN(rt, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("illegal role timeout %q, use role=idle:maxsessiontime", rt)
+		}
+		var t config.Timeouts
+		if _, err := fmt.Sscanf(parts[1], "%d:%d", &t.Idle, &t.MaxSession); err != nil {
+			return nil, fmt.Errorf("illegal role timeout %q: %s", rt, err)
+		}
+		res[parts[0]] = t
+	}
+	return res, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+
+	"github.com/clusterit/orca/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	hostAddress string
+	hostPort    int
+	hostTags    string
+	hostTeam    string
+	hostUser    string
+)
+
+var hostCmd = &cobra.Command{
+	Use:   "host",
+	Short: "show, create and delete hosts of the inventory",
+	Long:  "show, create and delete the backends of the host inventory of a zone",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var hostList = &cobra.Command{
+	Use:   "list [# zone]",
+	Short: "list all hosts of the zone",
+	Long:  "list all hosts of the inventory of the given zone",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		c := newCli()
+		res, err := c.listHosts(args[0])
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
+
+var hostGet = &cobra.Command{
+	Use:   "get [# zone] [# name]",
+	Short: "show a host",
+	Long:  "show the host with the given name",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		c := newCli()
+		res, err := c.getHost(args[0], args[1])
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
+
+var hostPut = &cobra.Command{
+	Use:   "put [# zone] [# name]",
+	Short: "create or update a host",
+	Long:  "create or update the host with the given name. the name can be used as the target of a login: user@name",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		h := config.Host{
+			Name:    args[1],
+			Address: hostAddress,
+			Port:    hostPort,
+			Tags:    splitList(hostTags),
+			Team:    hostTeam,
+			User:    hostUser,
+		}
+		c := newCli()
+		res, err := c.putHost(args[0], h)
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
+
+var hostDelete = &cobra.Command{
+	Use:   "delete [# zone] [# name]",
+	Short: "delete a host",
+	Long:  "delete the host with the given name from the inventory",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		c := newCli()
+		exitWhenError(c.deleteHost(args[0], args[1]))
+	},
+}
+
+func init() {
+	hostPut.Flags().StringVar(&hostAddress, "address", "", "the DNS name or IP of the host; defaults to the name")
+	hostPut.Flags().IntVar(&hostPort, "port", config.DefaultSSHPort, "the SSH port of the host")
+	hostPut.Flags().StringVar(&hostTags, "tags", "", "comma separated tags of the host")
+	hostPut.Flags().StringVar(&hostTeam, "team", "", "the team which owns the host")
+	hostPut.Flags().StringVar(&hostUser, "user", "", "the remote user if a login does not contain one")
+	hostCmd.AddCommand(hostList, hostGet, hostPut, hostDelete)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var knownHostsCmd = &cobra.Command{
+	Use:   "knownhosts",
+	Short: "show, pin, revoke and import host keys of the backends",
+	Long:  "show, pin, revoke and import the host keys which the gateway accepts from the backends",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var knownHostsList = &cobra.Command{
+	Use:   "list [# zone]",
+	Short: "list the known host keys",
+	Long:  "list the known and revoked host keys of all backends in the zone",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		c := newCli()
+		res, err := c.knownHosts(args[0])
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
+
+var knownHostsPin = &cobra.Command{
+	Use:   "pin [# zone] [# host] [# keyfile]",
+	Short: "pin a host key",
+	Long:  "pin the public key in the keyfile for the host. use [host]:port for backends which do not listen on port 22",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 3 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		c := newCli()
+		res, err := c.changeHostKey(args[0], args[1], args[2], false)
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
+
+var knownHostsRevoke = &cobra.Command{
+	Use:   "revoke [# zone] [# host] [# keyfile]",
+	Short: "revoke a host key",
+	Long:  "revoke the public key in the keyfile for the host. the gateway will never accept this key again",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 3 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		c := newCli()
+		res, err := c.changeHostKey(args[0], args[1], args[2], true)
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
+
+var knownHostsImport = &cobra.Command{
+	Use:   "import [# zone] [# known_hosts]",
+	Short: "import a known_hosts file",
+	Long:  "import all keys of an OpenSSH known_hosts file. hashed hostnames cannot be imported",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		c := newCli()
+		res, err := c.importKnownHosts(args[0], args[1])
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
+
+func init() {
+	knownHostsCmd.AddCommand(knownHostsList, knownHostsPin, knownHostsRevoke, knownHostsImport)
+}
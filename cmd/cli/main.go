@@ -102,7 +102,7 @@ func main() {
 	cli.PersistentFlags().BoolVarP(&debug, "debug", "d", false, "debug output of the HTTP flow")
 	cli.PersistentFlags().BoolVarP(&unsecure, "unsecure", "u", false, "do not verify the SSL cert of the remote service (use only for selfsigned certs)")
 
-	cli.AddCommand(whoami, permit, usercmd, keycmd, zones, gateway, cluster, oauthCmd, versionCmd)
+	cli.AddCommand(whoami, permit, usercmd, keycmd, zones, gateway, caKey, cluster, oauthCmd, policyCmd, hostCmd, knownHostsCmd, auditCmd, sessionsCmd, bansCmd, approvalsCmd, approveCmd, denyCmd, versionCmd)
 
 	viper.SetEnvPrefix(common.OrcaPrefix)
 	viper.AutomaticEnv()
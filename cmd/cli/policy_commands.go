@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/clusterit/orca/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	policyId          string
+	policyUsers       string
+	policyRoles       string
+	policyHosts       string
+	policyRemoteUsers string
+	policyChannels    string
+	policyTunnels     string
+)
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "show, create and delete access policies",
+	Long:  "show, create and delete the policies which allow users to reach backends",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var policyList = &cobra.Command{
+	Use:   "list [# zone]",
+	Short: "list all policies of the zone",
+	Long:  "list all access policies of the given zone",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		c := newCli()
+		res, err := c.listPolicies(args[0])
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
+
+var policyPut = &cobra.Command{
+	Use:   "put [# zone]",
+	Short: "create or update a policy",
+	Long:  "create a new policy or update the policy with the given id. all lists are comma separated",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		p := config.Policy{
+			Id:          policyId,
+			Users:       splitList(policyUsers),
+			Roles:       splitList(policyRoles),
+			Hosts:       splitList(policyHosts),
+			RemoteUsers: splitList(policyRemoteUsers),
+			Channels:    splitList(policyChannels),
+			Tunnels:     splitList(policyTunnels),
+		}
+		c := newCli()
+		res, err := c.putPolicy(args[0], p)
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
+
+var policyDelete = &cobra.Command{
+	Use:   "delete [# zone] [# id]",
+	Short: "delete a policy",
+	Long:  "delete the policy with the given id",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 2 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		c := newCli()
+		exitWhenError(c.deletePolicy(args[0], args[1]))
+	},
+}
+
+func init() {
+	policyPut.Flags().StringVar(&policyId, "id", "", "the id of the policy; if empty a new policy is created")
+	policyPut.Flags().StringVar(&policyUsers, "users", "", "the user ids of the policy")
+	policyPut.Flags().StringVar(&policyRoles, "roles", "", "the roles of the policy")
+	policyPut.Flags().StringVar(&policyHosts, "hosts", "", "host patterns or CIDRs")
+	policyPut.Flags().StringVar(&policyRemoteUsers, "remoteusers", "", "patterns of allowed remote users")
+	policyPut.Flags().StringVar(&policyChannels, "channels", "", "allowed channels: shell, exec, subsystem, direct-tcpip, forwarded-tcpip, x11")
+	policyPut.Flags().StringVar(&policyTunnels, "tunnels", "", "allowed tunnel destinations: host:port, the host can be a pattern or CIDR, the port a number, range or *")
+	policyCmd.AddCommand(policyList, policyPut, policyDelete)
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
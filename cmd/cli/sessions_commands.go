@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "show and kill the active sessions",
+	Long:  "show the active sessions of all gateways and kill them",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var sessionsList = &cobra.Command{
+	Use:   "list",
+	Short: "list the active sessions",
+	Long:  "list the active sessions of all gateways",
+	Run: func(cmd *cobra.Command, args []string) {
+		c := newCli()
+		res, err := c.listSessions()
+		exitWhenError(err)
+		dumpValue(res)
+	},
+}
+
+var sessionsKill = &cobra.Command{
+	Use:   "kill [# id]",
+	Short: "kill a session",
+	Long:  "close the connection of the session on the gateway which serves it",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		c := newCli()
+		exitWhenError(c.killSession(args[0]))
+	},
+}
+
+func init() {
+	sessionsCmd.AddCommand(sessionsList, sessionsKill)
+}
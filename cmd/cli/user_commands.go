@@ -62,6 +62,36 @@ var listUsers = &cobra.Command{
 		dumpValue(usrs)
 	},
 }
+var scratchCodes = &cobra.Command{
+	Use:   "scratchcodes",
+	Short: "create new scratch codes",
+	Long:  "Create new scratch codes for the current user. Every code can be used once instead of the authenticator, the old codes are invalid afterwards.",
+	Run: func(cmd *cobra.Command, args []string) {
+		c := newCli()
+		codes, err := c.scratchCodes()
+		exitWhenError(err)
+		for _, sc := range codes {
+			fmt.Println(sc)
+		}
+	},
+}
+
+var reset2FA = &cobra.Command{
+	Use:   "reset2fa [# uid]",
+	Short: "reset the 2FA of a user",
+	Long:  "Disable the 2FA of a user who lost the authenticator and the scratch codes. The user has to create a new token afterwards.",
+	Run: func(cmd *cobra.Command, args []string) {
+		c := newCli()
+		if len(args) < 1 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		usr, err := c.reset2FA(args[0])
+		exitWhenError(err)
+		dumpValue(usr)
+	},
+}
+
 var keyname string
 var addKey = &cobra.Command{
 	Use:   "add [uid] [key-file]",
@@ -76,6 +106,21 @@ var addKey = &cobra.Command{
 		exitWhenError(c.addKey(args[0], keyname, args[1]))
 	},
 }
+var keyOptions = &cobra.Command{
+	Use:   "options [uid] [key-name] [option...]",
+	Short: "set the options of a security key",
+	Long:  "Replace the options of a security key of the user, verify-required or no-touch-required. Without options the key has none.",
+	Run: func(cmd *cobra.Command, args []string) {
+		c := newCli()
+		if len(args) < 2 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		k, err := c.setKeyOptions(args[0], args[1], args[2:])
+		exitWhenError(err)
+		dumpValue(k)
+	},
+}
 var delKey = &cobra.Command{
 	Use:   "del [uid] [key-name]",
 	Short: "delete a key",
@@ -91,8 +136,8 @@ var delKey = &cobra.Command{
 }
 
 func init() {
-	usercmd.AddCommand(addUser, listUsers, userAlias)
-	keycmd.AddCommand(addKey, delKey)
+	usercmd.AddCommand(addUser, listUsers, userAlias, scratchCodes, reset2FA)
+	keycmd.AddCommand(addKey, delKey, keyOptions)
 	addKey.Flags().StringVarP(&keyname, "keyname", "k", "", "the keyname to use. if empty try to parse the given keyfile")
 	userAlias.Flags().BoolVar(&removeAlias, "remove", false, "remove the alias")
 }
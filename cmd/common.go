@@ -1,12 +1,14 @@
 package cmd
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/clusterit/orca/logging"
 
 	"github.com/clusterit/orca/common"
 	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/etcd"
 )
 
 const (
@@ -29,6 +31,22 @@ func PublishAddress(pub, listen, path string) string {
 	return pub + path
 }
 
+// Load the master keys from the file or the comma separated list and let
+// the cluster seal its secrets with them.
+func UseKeyring(cc *etcd.Cluster, file, keys string) error {
+	kr, err := etcd.LoadKeyring(file, keys)
+	if err != nil {
+		return fmt.Errorf("cannot load the master key: %s", err)
+	}
+	if kr == nil {
+		logger.Warnf("no master key configured, the secrets in etcd are not encrypted")
+	} else {
+		logger.Infof("secrets in etcd are sealed with master key %s", kr.Current())
+	}
+	cc.UseKeyring(kr)
+	return nil
+}
+
 func ForceZone(cfger config.Configer, zone string, createGateway bool) (*config.Gateway, *config.ClusterConfig, error) {
 	cfg, err := cfger.Cluster()
 	if common.IsNotFound(err) {
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/clusterit/orca/approvals"
+	"github.com/clusterit/orca/audit"
+	"github.com/clusterit/orca/logging"
+	"github.com/clusterit/orca/users"
+	"golang.org/x/crypto/ssh"
+)
+
+var approvalStore approvals.Approvals
+
+// Ask the user to approve the login in the manager and wait for the
+// decision. Returns false if the login was not decided in time, so the
+// user can enter a code instead, and an error if the user denied it.
+func waitForApproval(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge, usr *users.User, ttl int, wait time.Duration) (bool, error) {
+	a, err := approvalStore.Request(approvals.Approval{Uid: usr.Id, Zone: zone, Source: addressOf(conn.RemoteAddr()), Autologin: ttl}, uint64(wait/time.Second)+1)
+	if err != nil {
+		Log(logging.Warn, "cannot request an approval for '%s': %s", usr.Id, err)
+		return false, nil
+	}
+	defer approvalStore.Remove(a.Id)
+	msg := fmt.Sprintf("Approve the login in orcaman or with 'cli approve %s' within %s.", a.Id, wait)
+	if _, err := client(usr.Name, msg, nil, nil); err != nil {
+		return false, err
+	}
+	d, err := approvalStore.Wait(a.Id, wait)
+	if err != nil {
+		Log(logging.Warn, "cannot wait for the approval of '%s': %s", usr.Id, err)
+		return false, nil
+	}
+	switch d.State {
+	case approvals.Approved:
+		auditApproval(d, true, "approved")
+		return true, nil
+	case approvals.Denied:
+		auditApproval(d, false, "denied")
+		return false, fmt.Errorf("the login was denied")
+	}
+	auditApproval(d, false, "timeout")
+	return false, nil
+}
+
+// log the decision of an approval and keep it for audits.
+func auditApproval(a *approvals.Approval, allowed bool, msg string) {
+	ev := audit.Event{Type: audit.Approval, User: a.Uid, Zone: zone, Target: a.Source, Allowed: allowed, Message: msg}
+	Log(logging.Info, "audit %s of '%s' from %s: %s", ev.Type, ev.User, ev.Target, msg)
+	if auditor == nil {
+		return
+	}
+	if err := auditor.Record(ev); err != nil {
+		Log(logging.Warn, "cannot audit %s: %s", ev.Type, err)
+	}
+}
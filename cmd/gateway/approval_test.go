@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clusterit/orca/approvals"
+	"github.com/clusterit/orca/common"
+	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/users"
+)
+
+// an approval store where every login gets the same decision
+type testApprovals struct {
+	decision  string
+	requested []approvals.Approval
+}
+
+func (s *testApprovals) Request(a approvals.Approval, ttl uint64) (*approvals.Approval, error) {
+	a.Id = "a1"
+	a.State = approvals.Pending
+	s.requested = append(s.requested, a)
+	return &a, nil
+}
+
+func (s *testApprovals) Get(id string) (*approvals.Approval, error) {
+	return nil, common.ErrNotFound
+}
+
+func (s *testApprovals) List(uid string) ([]approvals.Approval, error) {
+	return nil, nil
+}
+
+func (s *testApprovals) Decide(id, uid string, approve bool) (*approvals.Approval, error) {
+	return nil, common.ErrNotFound
+}
+
+func (s *testApprovals) Wait(id string, timeout time.Duration) (*approvals.Approval, error) {
+	a := s.requested[len(s.requested)-1]
+	a.State = s.decision
+	return &a, nil
+}
+
+func (s *testApprovals) Remove(id string) error {
+	return nil
+}
+
+func TestPushApproval(t *testing.T) {
+	oldFetcher, oldConfig, oldStore := fetcher, configuration, approvalStore
+	defer func() { fetcher, configuration, approvalStore = oldFetcher, oldConfig, oldStore }()
+	fetcher = &testFetcher{usr: &users.User{Id: "alice@network", Name: "Alice", Use2FA: true, AutologinAfter2FA: 600}, code: "424242"}
+	configuration = &config.Gateway{MaxAutologin2FA: 60, PushApproval: true}
+	store := &testApprovals{decision: approvals.Approved}
+	approvalStore = store
+
+	perms, instructions, err := login2FA(t)
+	if err != nil {
+		t.Fatalf("the approved login should succeed: %s", err)
+	}
+	if perms == nil || perms.Extensions["allowance_until"] == "" {
+		t.Errorf("the approval should grant the autologin window: %+v", perms)
+	}
+	if permitUntil(perms).IsZero() {
+		t.Errorf("the session should end with the autologin window: %+v", perms)
+	}
+	if len(instructions) != 1 || !strings.Contains(instructions[0], "cli approve a1") {
+		t.Errorf("the user should be asked for the approval: %q", instructions)
+	}
+	if a := store.requested[0]; a.Uid != "alice@network" || a.Autologin != 60 || a.Source != "127.0.0.1" {
+		t.Errorf("the approval should carry the login: %+v", a)
+	}
+
+	store.decision = approvals.Denied
+	if _, _, err := login2FA(t); err == nil {
+		t.Errorf("the denied login should fail")
+	}
+
+	store.decision = approvals.Pending
+	if _, instructions, err = login2FA(t, "424242"); err != nil {
+		t.Errorf("the user should login with a code after the timeout: %s", err)
+	}
+	if len(instructions) != 2 || !strings.Contains(instructions[1], "not approved") {
+		t.Errorf("the user should be asked for the code after the timeout: %q", instructions)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/clusterit/orca/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// Create a signer with a fresh key and a certificate for the remote user
+// which is signed by the CA of the backend's zone. The certificate is
+// valid for CertValidity seconds but never longer than the allowance of
+// the user.
+func (cs *clientSession) certSigner(gw *config.Gateway) (ssh.Signer, error) {
+	if gw.CAKey == "" {
+		return nil, fmt.Errorf("no CA key configured for zone %s", cs.hostZone())
+	}
+	ca, err := ssh.ParsePrivateKey([]byte(gw.CAKey))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse CA key: %s", err)
+	}
+	validity := gw.CertValidity
+	if validity <= 0 {
+		validity = config.DefaultCertValidity
+	}
+	now := time.Now()
+	until := now.Add(time.Duration(validity) * time.Second)
+	if allowed := cs.allowedUntil(); !allowed.IsZero() && allowed.Before(until) {
+		until = allowed
+	}
+	if !until.After(now) {
+		return nil, fmt.Errorf("the allowance of %s has expired", cs.userId)
+	}
+	cert := &ssh.Certificate{
+		CertType:        ssh.UserCert,
+		KeyId:           fmt.Sprintf("%s:%s", cs.userId, cs.sessionId),
+		ValidPrincipals: []string{cs.remoteUser},
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(until.Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-X11-forwarding":   "",
+				"permit-agent-forwarding": "",
+				"permit-port-forwarding":  "",
+				"permit-pty":              "",
+				"permit-user-rc":          "",
+			},
+		},
+	}
+	signer, err := signCert(ca, cert)
+	if err != nil {
+		return nil, err
+	}
+	cs.debugf("signed certificate for %s, valid until %s", cs.remoteUser, until)
+	return signer, nil
+}
+
+// Create a fresh key, sign the given certificate for it with the CA and
+// return a signer which authenticates with the certificate.
+func signCert(ca ssh.Signer, cert *ssh.Certificate) (ssh.Signer, error) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	serial := make([]byte, 8)
+	if _, err := rand.Read(serial); err != nil {
+		return nil, err
+	}
+	cert.Key = signer.PublicKey()
+	cert.Serial = binary.BigEndian.Uint64(serial)
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		return nil, err
+	}
+	return ssh.NewCertSigner(cert, signer)
+}
+
+// the end of the allowance of the user or a zero time if there is none
+func (cs *clientSession) allowedUntil() time.Time {
+	if cs.serverConn.Permissions == nil {
+		return time.Time{}
+	}
+	u := cs.serverConn.Permissions.Extensions["allowance_until"]
+	if u == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, u)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
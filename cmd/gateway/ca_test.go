@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/logging"
+	"golang.org/x/crypto/ssh"
+)
+
+type fakeConnMeta struct {
+	user string
+}
+
+func (f *fakeConnMeta) User() string          { return f.user }
+func (f *fakeConnMeta) SessionID() []byte     { return []byte("session") }
+func (f *fakeConnMeta) ClientVersion() []byte { return []byte("SSH-2.0-test") }
+func (f *fakeConnMeta) ServerVersion() []byte { return []byte("SSH-2.0-test") }
+func (f *fakeConnMeta) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+}
+func (f *fakeConnMeta) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2022}
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// a session of alice as the remote user, allowance is the value of
+// allowance_until or empty
+func certSession(remoteUser, allowance string) *clientSession {
+	perms := &ssh.Permissions{Extensions: map[string]string{"user_id": "alice@github"}}
+	if allowance != "" {
+		perms.Extensions["allowance_until"] = allowance
+	}
+	return &clientSession{
+		serverConn: &ssh.ServerConn{Permissions: perms},
+		userId:     "alice@github",
+		sessionId:  "42",
+		remoteUser: remoteUser,
+		logger:     logging.New("42", "192.168.1.10:40000"),
+	}
+}
+
+func sessionCert(t *testing.T, cs *clientSession) *ssh.Certificate {
+	signer, err := cs.certSigner(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, ok := signer.PublicKey().(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("the signer should authenticate with a certificate: %T", signer.PublicKey())
+	}
+	return cert
+}
+
+func TestCertSigner(t *testing.T) {
+	oldConfiguration := configuration
+	defer func() { configuration = oldConfiguration }()
+	gw, err := config.GenerateGateway()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw.CertValidity = 600
+	configuration = gw
+	ca, err := ssh.ParsePrivateKey([]byte(gw.CAKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	cert := sessionCert(t, certSession("root", ""))
+	if len(cert.ValidPrincipals) != 1 || cert.ValidPrincipals[0] != "root" {
+		t.Errorf("the only principal should be the remote user: %v", cert.ValidPrincipals)
+	}
+	if cert.CertType != ssh.UserCert || cert.KeyId != "alice@github:42" {
+		t.Errorf("the certificate should be a user certificate of alice: %d %s", cert.CertType, cert.KeyId)
+	}
+	if until := time.Unix(int64(cert.ValidBefore), 0); until.Before(now.Add(599*time.Second)) || until.After(now.Add(601*time.Second)) {
+		t.Errorf("the certificate should be valid for the validity of the zone: %s", until)
+	}
+	if other := sessionCert(t, certSession("root", "")); bytes.Equal(other.Key.Marshal(), cert.Key.Marshal()) || other.Serial == cert.Serial {
+		t.Errorf("every certificate should have a fresh key and serial")
+	}
+
+	checker := &ssh.CertChecker{IsUserAuthority: func(auth ssh.PublicKey) bool {
+		return bytes.Equal(auth.Marshal(), ca.PublicKey().Marshal())
+	}}
+	if _, err := checker.Authenticate(&fakeConnMeta{user: "root"}, cert); err != nil {
+		t.Errorf("a backend which trusts the CA should accept the certificate: %s", err)
+	}
+	if _, err := checker.Authenticate(&fakeConnMeta{user: "postgres"}, cert); err == nil {
+		t.Errorf("the certificate should not be accepted for another user")
+	}
+	other := &ssh.CertChecker{IsUserAuthority: func(auth ssh.PublicKey) bool {
+		return bytes.Equal(auth.Marshal(), newTestSigner(t).PublicKey().Marshal())
+	}}
+	if _, err := other.Authenticate(&fakeConnMeta{user: "root"}, cert); err == nil || !strings.Contains(err.Error(), "authority") {
+		t.Errorf("a backend which does not trust the CA should not accept the certificate: %v", err)
+	}
+
+	allowed := now.Add(2 * time.Minute).Truncate(time.Second)
+	cert = sessionCert(t, certSession("root", allowed.Format(time.RFC3339)))
+	if cert.ValidBefore != uint64(allowed.Unix()) {
+		t.Errorf("the certificate should end with the allowance %s, not %s", allowed, time.Unix(int64(cert.ValidBefore), 0))
+	}
+	later := now.Add(time.Hour).Format(time.RFC3339)
+	if cert := sessionCert(t, certSession("root", later)); time.Unix(int64(cert.ValidBefore), 0).After(now.Add(601 * time.Second)) {
+		t.Errorf("a longer allowance should not extend the validity of the zone")
+	}
+
+	if _, err := certSession("root", now.Add(-time.Minute).Format(time.RFC3339)).certSigner(configuration); err == nil {
+		t.Errorf("there should be no certificate after the allowance has expired")
+	}
+
+	configuration = &config.Gateway{}
+	if _, err := certSession("root", "").certSigner(configuration); err == nil {
+		t.Errorf("there should be no certificate without a CA key")
+	}
+}
+
+func TestSignCert(t *testing.T) {
+	ca := newTestSigner(t)
+	sign := func() *ssh.Certificate {
+		signer, err := signCert(ca, &ssh.Certificate{CertType: ssh.UserCert, ValidPrincipals: []string{"root"}, ValidBefore: ssh.CertTimeInfinity})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer.PublicKey().(*ssh.Certificate)
+	}
+	c1, c2 := sign(), sign()
+	if bytes.Equal(c1.Key.Marshal(), c2.Key.Marshal()) || c1.Serial == c2.Serial {
+		t.Errorf("every certificate should have a fresh key and serial")
+	}
+	if !bytes.Equal(c1.SignatureKey.Marshal(), ca.PublicKey().Marshal()) {
+		t.Errorf("the certificate should be signed by the CA")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/clusterit/orca/recording"
+	"golang.org/x/crypto/ssh"
+)
+
+// A channelSession is a session channel of the client. Every channel gets
+// its own session at the backend, the requests of the client are kept
+// until the shell, command or subsystem is started.
+type channelSession struct {
+	*clientSession
+	channel ssh.Channel
+
+	// guards the terminal, the recording and the backend session
+	mux      sync.Mutex
+	term     string
+	width    int
+	height   int
+	recorder *recording.Recorder
+	session  *backendSession
+	buffered []*ssh.Request
+}
+
+func newChannelSession(cs *clientSession, channel ssh.Channel) *channelSession {
+	return &channelSession{clientSession: cs, channel: channel}
+}
+
+// forward a request of the client to the backend session or keep it until
+// the session is started.
+func (sc *channelSession) forward(rq *ssh.Request) error {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	if sc.session == nil {
+		sc.buffered = append(sc.buffered, rq)
+		return nil
+	}
+	return sc.forwardRequest(rq)
+}
+
+func (sc *channelSession) forwardRequest(rq *ssh.Request) error {
+	ok, err := sc.session.SendRequest(rq.Type, rq.WantReply, rq.Payload)
+	if err != nil {
+		return err
+	}
+	sc.tracef("forwarded %s to backend [%v]", rq.Type, ok)
+	if rq.WantReply {
+		rq.Reply(ok, nil)
+	}
+	return nil
+}
+
+// close the backend session, the client has closed its channel.
+func (sc *channelSession) close() {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	if sc.session != nil {
+		sc.session.Close()
+	}
+}
+
+// Open a session at the backend, send the requests of the client and
+// start the shell, command or subsystem.
+func (sc *channelSession) start(tp string, payload []byte) (*backendSession, error) {
+	if err := sc.ensureBackend(); err != nil {
+		return nil, err
+	}
+	sess, err := sc.backend.newSession()
+	if err != nil {
+		return nil, fmt.Errorf("session error: %s", err)
+	}
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	sc.session = sess
+	for _, rq := range sc.buffered {
+		if err := sc.forwardRequest(rq); err != nil {
+			sc.errorf("send %s to backend: %s", rq.Type, err)
+		}
+	}
+	sc.buffered = nil
+	ok, err := sess.SendRequest(tp, true, payload)
+	if err == nil && !ok {
+		err = fmt.Errorf("the backend refused the %s request", tp)
+	}
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+	return sess, nil
+}
+
+// Start a shell, command or subsystem at the backend and connect it with
+// the channel of the client. A nil request starts a shell without a reply.
+// The channel is closed at the end, the connection stays open for the
+// other channels of the client.
+func (sc *channelSession) connectRemote(req *ssh.Request) {
+	tp, payload := "shell", []byte(nil)
+	if req != nil {
+		tp, payload = req.Type, req.Payload
+	}
+	var cmd *string
+	var subsystem string
+	var err error
+	switch tp {
+	case "exec", "subsystem":
+		var args []string
+		if args, err = parseStrings(payload, 1); err != nil {
+			break
+		}
+		if tp == "exec" {
+			cmd = &args[0]
+			sc.debugf("start remote command %s", *cmd)
+		} else {
+			subsystem = args[0]
+			sc.debugf("start remote subsystem %s", subsystem)
+		}
+	default:
+		sc.debugf("opening remote shell")
+	}
+	var sess *backendSession
+	if err == nil {
+		sess, err = sc.start(tp, payload)
+	}
+	if req != nil && req.WantReply {
+		req.Reply(err == nil, nil)
+	}
+	if err != nil {
+		sc.errorf("opening %s: %s", tp, err)
+		fmt.Fprintf(sc.channel.Stderr(), "%s\r\n", err)
+		sc.channel.Close()
+		return
+	}
+
+	pipe := newSessionPipe(sc.channel, sess)
+	wc := sc.wrap(sc.channel)
+	wce := sc.wrap(sc.channel.Stderr())
+	var stdout, stderr io.Writer = wc, wce
+	var stdin io.Reader = wc
+	if subsystem == "" {
+		sc.startRecording(cmd)
+		defer sc.stopRecording()
+		stdout, stderr, stdin = sc.recorded(wc, wce, wc)
+		stdout, stderr = sc.shadowed(stdout), sc.shadowed(stderr)
+	}
+	if scp := parseScp(cmd); scp != nil && configuration.SftpAudit {
+		var done func()
+		stdout, stdin, done = sc.countScp(scp, stdout, stdin)
+		defer done()
+	}
+	pipe.copyOutput(stderr, sess.Stderr())
+	if subsystem == "sftp" && configuration.SftpProxy() {
+		defer sc.proxySftp(pipe, stdout, stdin)()
+	} else {
+		pipe.copyOutput(stdout, sess)
+		pipe.copyInput(stdin)
+	}
+	for _, rq := range pipe.wait() {
+		sc.infof("%s ended with %s", tp, exitMessage(rq))
+	}
+}
+
+// A session channel at the backend with the requests the backend sends,
+// like exit-status or exit-signal.
+type backendSession struct {
+	ssh.Channel
+	requests <-chan *ssh.Request
+}
+
+// the payload of an exit-signal request (RFC 4254, 6.10)
+type exitSignal struct {
+	Signal     string
+	CoreDumped bool
+	Message    string
+	Lang       string
+}
+
+func isExitRequest(tp string) bool {
+	return tp == "exit-status" || tp == "exit-signal"
+}
+
+// a readable form of an exit-status or exit-signal request for the logs.
+func exitMessage(rq *ssh.Request) string {
+	switch rq.Type {
+	case "exit-status":
+		if len(rq.Payload) >= 4 {
+			return fmt.Sprintf("exit status %d", binary.BigEndian.Uint32(rq.Payload))
+		}
+	case "exit-signal":
+		var sig exitSignal
+		if err := ssh.Unmarshal(rq.Payload, &sig); err == nil {
+			msg := "signal " + sig.Signal
+			if sig.CoreDumped {
+				msg += " (core dumped)"
+			}
+			if sig.Message != "" {
+				msg += ": " + sig.Message
+			}
+			return msg
+		}
+	}
+	return "illegal " + rq.Type
+}
+
+// A sessionPipe connects a session channel of the client with a session
+// channel at the backend. The data is copied in both directions, the
+// exit status or signal of the backend is passed to the client.
+type sessionPipe struct {
+	client  ssh.Channel
+	backend *backendSession
+	output  sync.WaitGroup
+}
+
+func newSessionPipe(client ssh.Channel, backend *backendSession) *sessionPipe {
+	return &sessionPipe{client: client, backend: backend}
+}
+
+// Copy the output of the backend in the background. If the client cannot
+// take the output anymore the backend channel is closed.
+func (p *sessionPipe) copyOutput(w io.Writer, r io.Reader) {
+	p.output.Add(1)
+	go func() {
+		defer p.output.Done()
+		if _, err := io.Copy(w, r); err != nil {
+			p.backend.Close()
+		}
+	}()
+}
+
+// Copy the input of the client in the background and send EOF to the
+// backend when the client sends EOF.
+func (p *sessionPipe) copyInput(r io.Reader) {
+	go func() {
+		io.Copy(p.backend, r)
+		p.backend.CloseWrite()
+	}()
+}
+
+// Wait until the backend closes its channel. The client gets the rest of
+// the output, EOF, the exit status or signal and the close in this order.
+// If the backend sent neither, the client gets the status 255 like the
+// OpenSSH client reports a lost connection. Returns the exit requests of
+// the backend.
+func (p *sessionPipe) wait() []*ssh.Request {
+	var exits []*ssh.Request
+	for rq := range p.backend.requests {
+		if isExitRequest(rq.Type) {
+			exits = append(exits, rq)
+		}
+		if rq.WantReply {
+			rq.Reply(false, nil)
+		}
+	}
+	p.output.Wait()
+	p.client.CloseWrite()
+	for _, rq := range exits {
+		p.client.SendRequest(rq.Type, false, rq.Payload)
+	}
+	if len(exits) == 0 {
+		sendExitStatus(p.client, 255)
+	}
+	p.client.Close()
+	return exits
+}
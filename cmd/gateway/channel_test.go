@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/logging"
+	"golang.org/x/crypto/ssh"
+)
+
+// Start an in-process ssh server which passes the new channels to handle
+// and return a client connected to it.
+func testConnection(t *testing.T, handle func(net.Conn, <-chan ssh.NewChannel)) *ssh.Client {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sconf := &ssh.ServerConfig{NoClientAuth: true}
+	sconf.AddHostKey(newTestSigner(t))
+	go func() {
+		defer l.Close()
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(c, sconf)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		handle(c, chans)
+	}()
+	cl, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cl
+}
+
+// a backend which understands a few commands:
+//
+//	echo: copies the input to the output until EOF
+//	exit <n>: writes bye to stderr and exits with status n
+//	kill: is killed with a core dump
+//	close: closes the channel without an exit status
+//	wait: reports window changes and breaks and exits with the signal it gets
+func testBackend(_ net.Conn, chans <-chan ssh.NewChannel) {
+	for nc := range chans {
+		go testBackendSession(nc)
+	}
+}
+
+func testBackendSession(nc ssh.NewChannel) {
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	exit := func(tp string, payload interface{}) {
+		ch.CloseWrite()
+		ch.SendRequest(tp, false, ssh.Marshal(payload))
+		ch.Close()
+	}
+	for rq := range reqs {
+		switch rq.Type {
+		case "exec":
+			rq.Reply(true, nil)
+			args, _ := parseStrings(rq.Payload, 1)
+			cmd := strings.Fields(args[0])
+			switch cmd[0] {
+			case "echo":
+				go func() {
+					io.Copy(ch, ch)
+					exit("exit-status", struct{ Status uint32 }{0})
+				}()
+			case "exit":
+				n, _ := strconv.Atoi(cmd[1])
+				fmt.Fprintf(ch.Stderr(), "bye")
+				exit("exit-status", struct{ Status uint32 }{uint32(n)})
+			case "kill":
+				exit("exit-signal", exitSignal{Signal: "KILL", CoreDumped: true, Message: "killed"})
+			case "close":
+				ch.Close()
+			}
+		case "window-change":
+			var wc struct{ Columns, Rows, Width, Height uint32 }
+			ssh.Unmarshal(rq.Payload, &wc)
+			fmt.Fprintf(ch, "%dx%d\n", wc.Columns, wc.Rows)
+		case "break":
+			rq.Reply(true, nil)
+			fmt.Fprintf(ch, "break\n")
+		case "signal":
+			var sig struct{ Signal string }
+			ssh.Unmarshal(rq.Payload, &sig)
+			exit("exit-signal", exitSignal{Signal: sig.Signal})
+		default:
+			if rq.WantReply {
+				rq.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// a gateway which connects every session channel to the backend.
+func testGateway(backend *ssh.Client) func(net.Conn, <-chan ssh.NewChannel) {
+	return func(conn net.Conn, chans <-chan ssh.NewChannel) {
+		cs := &clientSession{
+			tcpConnection: conn,
+			backend:       &backendClient{client: backend},
+			logger:        logging.New("test", conn.RemoteAddr().String()),
+		}
+		for nc := range chans {
+			ch, reqs, err := nc.Accept()
+			if err != nil {
+				continue
+			}
+			go func() {
+				sc := newChannelSession(cs, ch)
+				for rq := range reqs {
+					if rq.Type == "exec" {
+						go sc.connectRemote(rq)
+					} else {
+						sc.forward(rq)
+					}
+				}
+				sc.close()
+			}()
+		}
+	}
+}
+
+func newTestClient(t *testing.T) *ssh.Client {
+	if configuration == nil {
+		configuration = &config.Gateway{}
+	}
+	backend := testConnection(t, testBackend)
+	return testConnection(t, testGateway(backend))
+}
+
+func newTestSession(t *testing.T) *ssh.Session {
+	s, err := newTestClient(t).NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPipeExitStatus(t *testing.T) {
+	s := newTestSession(t)
+	var stderr strings.Builder
+	s.Stderr = &stderr
+	err := s.Run("exit 3")
+	ee, ok := err.(*ssh.ExitError)
+	if !ok || ee.ExitStatus() != 3 {
+		t.Errorf("the exit status should be 3, not %v", err)
+	}
+	if stderr.String() != "bye" {
+		t.Errorf("the output of stderr is missing: %q", stderr.String())
+	}
+}
+
+func TestPipeExitSignal(t *testing.T) {
+	s := newTestSession(t)
+	err := s.Run("kill")
+	ee, ok := err.(*ssh.ExitError)
+	if !ok || ee.Signal() != "KILL" || ee.Msg() != "killed" {
+		t.Errorf("the command should be killed, not %v", err)
+	}
+}
+
+func TestPipeWithoutExitStatus(t *testing.T) {
+	s := newTestSession(t)
+	err := s.Run("close")
+	ee, ok := err.(*ssh.ExitError)
+	if !ok || ee.ExitStatus() != 255 {
+		t.Errorf("the exit status should be 255 without a status of the backend, not %v", err)
+	}
+}
+
+func TestPipeEOF(t *testing.T) {
+	s := newTestSession(t)
+	s.Stdin = strings.NewReader("hello gateway")
+	out, err := s.Output("echo")
+	if err != nil {
+		t.Errorf("echo should succeed: %s", err)
+	}
+	if string(out) != "hello gateway" {
+		t.Errorf("the input should be echoed, not %q", out)
+	}
+}
+
+func TestPipeRequests(t *testing.T) {
+	s := newTestSession(t)
+	out, err := s.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bufio.NewReader(out)
+	if err := s.Start("wait"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WindowChange(40, 120); err != nil {
+		t.Fatal(err)
+	}
+	if l, _ := lines.ReadString('\n'); l != "120x40\n" {
+		t.Errorf("the window change should be forwarded, got %q", l)
+	}
+	if ok, err := s.SendRequest("break", true, ssh.Marshal(struct{ Length uint32 }{500})); !ok || err != nil {
+		t.Errorf("the break should be forwarded: %v, %v", ok, err)
+	}
+	if l, _ := lines.ReadString('\n'); l != "break\n" {
+		t.Errorf("the backend should get the break, got %q", l)
+	}
+	if err := s.Signal(ssh.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(lines)
+	err = s.Wait()
+	ee, ok := err.(*ssh.ExitError)
+	if !ok || ee.Signal() != "TERM" {
+		t.Errorf("the command should end with the signal, not %v", err)
+	}
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	client := newTestClient(t)
+	first, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := first.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := first.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Start("echo"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		s, err := client.NewSession()
+		if err != nil {
+			t.Fatalf("session %d: %s", i, err)
+		}
+		err = s.Run(fmt.Sprintf("exit %d", i+1))
+		if ee, ok := err.(*ssh.ExitError); !ok || ee.ExitStatus() != i+1 {
+			t.Errorf("session %d should exit with %d, not %v", i, i+1, err)
+		}
+	}
+	// the first session is still connected
+	fmt.Fprintf(in, "still here")
+	in.Close()
+	res, _ := ioutil.ReadAll(out)
+	if err := first.Wait(); err != nil {
+		t.Errorf("the first session should succeed: %s", err)
+	}
+	if string(res) != "still here" {
+		t.Errorf("the first session should echo its input, not %q", res)
+	}
+}
+
+func TestIllegalSubsystem(t *testing.T) {
+	if configuration == nil {
+		configuration = &config.Gateway{}
+	}
+	backend := testConnection(t, testBackend)
+	client := testConnection(t, func(conn net.Conn, chans <-chan ssh.NewChannel) {
+		cs := &clientSession{
+			tcpConnection: conn,
+			remoteHost:    "backend",
+			backend:       &backendClient{client: backend},
+			grants:        &grants{},
+			logger:        logging.New("test", conn.RemoteAddr().String()),
+		}
+		cs.handleChannels(nil, chans)
+	})
+	s, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.SendRequest("subsystem", true, nil); ok || err != nil {
+		t.Errorf("a subsystem without a name should be refused: %v, %v", ok, err)
+	}
+	// the connection is still usable
+	s, err = client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Run("exit 2")
+	if ee, ok := err.(*ssh.ExitError); !ok || ee.ExitStatus() != 2 {
+		t.Errorf("the next session should work, not %v", err)
+	}
+}
+
+func TestParseStrings(t *testing.T) {
+	pl := ssh.Marshal(struct{ A, B string }{"sftp", "x"})
+	if res, err := parseStrings(pl, 2); err != nil || res[0] != "sftp" || res[1] != "x" {
+		t.Errorf("both strings should be parsed: %v, %v", res, err)
+	}
+	for _, b := range [][]byte{nil, {0, 0}, {0, 0, 0, 9, 'a'}, pl[:len(pl)-1]} {
+		if _, err := parseStrings(b, 2); err == nil {
+			t.Errorf("%v should be refused", b)
+		}
+	}
+}
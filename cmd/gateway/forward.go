@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/clusterit/orca/audit"
+	"github.com/clusterit/orca/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// the payload of a tcpip-forward or cancel-tcpip-forward request
+// (RFC 4254, 7.1)
+type tcpipForward struct {
+	Addr string
+	Port uint32
+}
+
+// log the event and keep it for audits.
+func (cs *clientSession) audit(ev audit.Event) {
+	ev.User = cs.userId
+	ev.Zone = zone
+	ev.Session = cs.sessionId
+	if ev.Allowed {
+		cs.infof("audit %s %s: %s", ev.Type, ev.Target, ev.Message)
+	} else {
+		cs.infof("audit %s %s denied: %s", ev.Type, ev.Target, ev.Message)
+	}
+	if auditor == nil {
+		return
+	}
+	if err := auditor.Record(ev); err != nil {
+		cs.warnf("cannot audit %s: %s", ev.Type, err)
+	}
+}
+
+// check a remote forwarding against the policies of the user and the
+// mode of the zone.
+func (cs *clientSession) checkRemoteForward(f tcpipForward) error {
+	if _, g := cs.selection(); !g.allows(config.ChannelForwardedTcpip) {
+		return fmt.Errorf("remote forwarding is not allowed by policy")
+	}
+	return configuration.CheckRemoteForward(f.Addr, f.Port)
+}
+
+// Forward a tcpip-forward or cancel-tcpip-forward request to the backend
+// if it is allowed and remember the active forwards. The backend may only
+// open forwarded-tcpip channels for active forwards.
+func (cs *clientSession) remoteForward(rq *ssh.Request) error {
+	var f tcpipForward
+	if err := ssh.Unmarshal(rq.Payload, &f); err != nil {
+		if rq.WantReply {
+			rq.Reply(false, nil)
+		}
+		return fmt.Errorf("illegal %s request: %s", rq.Type, err)
+	}
+	target := net.JoinHostPort(f.Addr, strconv.Itoa(int(f.Port)))
+	if rq.Type == "tcpip-forward" {
+		if err := cs.checkRemoteForward(f); err != nil {
+			cs.audit(audit.Event{Type: audit.RemoteForward, Target: target, Message: err.Error()})
+			if rq.WantReply {
+				rq.Reply(false, nil)
+			}
+			return nil
+		}
+	} else if _, ok := cs.remoteForwardPort(target); !ok {
+		if rq.WantReply {
+			rq.Reply(false, nil)
+		}
+		return nil
+	}
+	ok, data, err := cs.backend.client.SendRequest(rq.Type, true, rq.Payload)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if rq.Type == "tcpip-forward" {
+			port := f.Port
+			if port == 0 && len(data) >= 4 {
+				port = binary.BigEndian.Uint32(data)
+			}
+			cs.addRemoteForward(target, port)
+			cs.audit(audit.Event{Type: audit.RemoteForward, Target: target, Allowed: true, Message: fmt.Sprintf("listening on port %d", port)})
+		} else {
+			cs.dropRemoteForward(target)
+			cs.audit(audit.Event{Type: audit.CancelRemoteForward, Target: target, Allowed: true})
+		}
+	}
+	if rq.WantReply {
+		rq.Reply(ok, data)
+	}
+	return nil
+}
+
+func (cs *clientSession) addRemoteForward(target string, port uint32) {
+	cs.forwardMux.Lock()
+	defer cs.forwardMux.Unlock()
+	if cs.remoteForwards == nil {
+		cs.remoteForwards = make(map[string]uint32)
+	}
+	cs.remoteForwards[target] = port
+}
+
+func (cs *clientSession) dropRemoteForward(target string) {
+	cs.forwardMux.Lock()
+	defer cs.forwardMux.Unlock()
+	delete(cs.remoteForwards, target)
+}
+
+func (cs *clientSession) remoteForwardPort(target string) (uint32, bool) {
+	cs.forwardMux.Lock()
+	defer cs.forwardMux.Unlock()
+	p, ok := cs.remoteForwards[target]
+	return p, ok
+}
+
+// check if there is an active forward for the forwarded-tcpip channel.
+func (cs *clientSession) isRemoteForward(data []byte) (string, bool) {
+	var ch directTcpip
+	if err := ssh.Unmarshal(data, &ch); err != nil {
+		return "", false
+	}
+	cs.forwardMux.Lock()
+	defer cs.forwardMux.Unlock()
+	for _, p := range cs.remoteForwards {
+		if p == ch.Port {
+			return fmt.Sprintf("port %d from %s", ch.Port, net.JoinHostPort(ch.OrigHost, strconv.Itoa(int(ch.OrigPort)))), true
+		}
+	}
+	return "", false
+}
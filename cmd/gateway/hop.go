@@ -0,0 +1,371 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/logging"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// the separator of the zones in a target: user@host%dmz
+	hopSeparator = "%"
+	// the user a gateway uses to login at the gateway of another zone
+	hopUser = "orca-hop"
+
+	// the certificate extensions which carry the identity of the user
+	// to the gateways of the other zones.
+	extRoles     = "roles@orca"
+	extHops      = "hops@orca"
+	extAllowance = "allowance-until@orca"
+	extPermit    = "permit-until@orca"
+
+	permHop = "hop"
+)
+
+var (
+	clusterKey ssh.Signer
+)
+
+// the payload of a direct-tcpip channel open request (RFC 4254, 7.2)
+type directTcpip struct {
+	Host     string
+	Port     uint32
+	OrigHost string
+	OrigPort uint32
+}
+
+// Split the zones from the target of a login: the target db1%dmz%lab is
+// reached over the gateway of the zone dmz and then over the gateway of
+// the zone lab. The host is a host of the last zone.
+func splitHops(target string) (string, []string, error) {
+	parts := strings.Split(target, hopSeparator)
+	for _, z := range parts[1:] {
+		if z == "" {
+			return "", nil, fmt.Errorf("unknown target: %s", target)
+		}
+	}
+	return parts[0], parts[1:], nil
+}
+
+func setClusterKey(cc *config.ClusterConfig) {
+	signer, err := ssh.ParsePrivateKey([]byte(cc.Key))
+	if err != nil {
+		Log(logging.Warn, "cannot parse the cluster key, hops to other zones are not possible: %s", err)
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	clusterKey = signer
+}
+
+func currentClusterKey() ssh.Signer {
+	lock.Lock()
+	defer lock.Unlock()
+	return clusterKey
+}
+
+func isClusterKey(auth ssh.PublicKey) bool {
+	ck := currentClusterKey()
+	if ck == nil {
+		return false
+	}
+	return string(ck.PublicKey().Marshal()) == string(auth.Marshal())
+}
+
+// Returns true if the key is a certificate with a valid signature of the
+// cluster key. The certificate itself may be expired or illegal.
+func isClusterCert(key ssh.PublicKey) bool {
+	cert, ok := key.(*ssh.Certificate)
+	if !ok || cert.Signature == nil || !isClusterKey(cert.SignatureKey) {
+		return false
+	}
+	// the signed data is the certificate without the signature
+	unsigned := *cert
+	unsigned.Signature = nil
+	b := unsigned.Marshal()
+	return cert.SignatureKey.Verify(b[:len(b)-4], cert.Signature) == nil
+}
+
+func isHop(perms *ssh.Permissions) bool {
+	return perms != nil && perms.Extensions[permHop] != ""
+}
+
+// Authenticate the gateway of another zone. The gateway presents a
+// certificate which is signed with the cluster key and contains the
+// identity of the user.
+func hopAuth(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	checker := &ssh.CertChecker{IsUserAuthority: isClusterKey}
+	if _, err := checker.Authenticate(conn, key); err != nil {
+		return nil, err
+	}
+	cert := key.(*ssh.Certificate)
+	if cert.Extensions[extHops] == "" {
+		return nil, fmt.Errorf("the certificate of the gateway contains no hops")
+	}
+	Log(logging.Info, "remote: %s: hop for user %s over %s", conn.RemoteAddr().String(), cert.KeyId, cert.Extensions[extHops])
+	perms := &ssh.Permissions{Extensions: map[string]string{
+		"user_id": cert.KeyId,
+		"roles":   cert.Extensions[extRoles],
+		permHop:   cert.Extensions[extHops]}}
+	if a := cert.Extensions[extAllowance]; a != "" {
+		perms.Extensions["allowance_until"] = a
+	}
+	// the session ends with the permit of the first gateway
+	if p := cert.Extensions[extPermit]; p != "" {
+		perms.Extensions[permPermitUntil] = p
+	}
+	return perms, nil
+}
+
+// The gateway configuration of the zone of the target host. The backends
+// of a hop zone trust the CA and the known hosts of their own zone.
+func (cs *clientSession) hostGateway() (*config.Gateway, error) {
+	if len(cs.hops) == 0 {
+		return configuration, nil
+	}
+	gw, err := configer.GetGateway(cs.hostZone())
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the gateway of zone %s: %s", cs.hostZone(), err)
+	}
+	return gw, nil
+}
+
+// the chain of the gateways of this session: the address of the client
+// and the zones of the gateways.
+func (cs *clientSession) chain() string {
+	c := ""
+	if cs.serverConn.Permissions != nil {
+		c = cs.serverConn.Permissions.Extensions[permHop]
+	}
+	if c == "" {
+		c = cs.serverConn.RemoteAddr().String()
+	}
+	return c + ">" + zone
+}
+
+// the zone whose inventory contains the target host.
+func (cs *clientSession) hostZone() string {
+	if len(cs.hops) > 0 {
+		return cs.hops[len(cs.hops)-1]
+	}
+	return zone
+}
+
+// The policies of the zone whose inventory contains the target host, the
+// admins of a hop zone restrict the access to their own hosts.
+func (cs *clientSession) hostPolicies() ([]config.Policy, error) {
+	policies, err := configer.Policies(cs.hostZone())
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the policies of zone %s: %s", cs.hostZone(), err)
+	}
+	return policies, nil
+}
+
+// Check the address of the client against the rules for the roles of the
+// user in the hop zones. The gateways of the hop zones only see the
+// address of the previous gateway.
+func (cs *clientSession) checkHopClientRules(roles []string) error {
+	ip := net.ParseIP(addressOf(cs.serverConn.RemoteAddr()))
+	for _, z := range cs.hops {
+		gw, err := configer.GetGateway(z)
+		if err != nil {
+			return fmt.Errorf("cannot read the gateway of zone %s: %s", z, err)
+		}
+		if err := checkRoleClientAccess(ip, roles, *gw); err != nil {
+			return fmt.Errorf("zone %s: %s", z, err)
+		}
+	}
+	return nil
+}
+
+// a connection which runs over the gateways of other zones.
+type hopConn struct {
+	net.Conn
+	clients []*ssh.Client
+}
+
+func (h *hopConn) dial(addr string) (net.Conn, error) {
+	if len(h.clients) == 0 {
+		return net.Dial("tcp", addr)
+	}
+	return h.clients[len(h.clients)-1].Dial("tcp", addr)
+}
+
+func (h *hopConn) Close() error {
+	var err error
+	if h.Conn != nil {
+		err = h.Conn.Close()
+	}
+	for i := len(h.clients) - 1; i >= 0; i-- {
+		h.clients[i].Close()
+	}
+	return err
+}
+
+// Accept every active and next host key of the gateway, the gateway
+// offers all of them and the client picks the algorithm.
+func gatewayHostKeys(gw *config.Gateway) (ssh.HostKeyCallback, error) {
+	signers, err := parseHostKeys(append(gw.ActiveHostKeys(), gw.NextHostKeys...))
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the host keys: %s", err)
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("the gateway has no host key")
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		for _, s := range signers {
+			if string(s.PublicKey().Marshal()) == string(key.Marshal()) {
+				return nil
+			}
+		}
+		return fmt.Errorf("the host key %s of the gateway is unknown", ssh.FingerprintSHA256(key))
+	}, nil
+}
+
+// Dial the address over the gateways of the hop zones. Every gateway is
+// authenticated with its host keys from the configuration of its zone.
+func (cs *clientSession) dialHops(addr string) (net.Conn, error) {
+	ck := currentClusterKey()
+	if ck == nil {
+		return nil, fmt.Errorf("no cluster key, cannot hop to other zones")
+	}
+	_, roles := userOf(cs.serverConn.Permissions)
+	chain := cs.chain()
+	hc := &hopConn{}
+	for _, z := range cs.hops {
+		gw, err := configer.GetGateway(z)
+		if err != nil {
+			hc.Close()
+			return nil, fmt.Errorf("cannot read the gateway of zone %s: %s", z, err)
+		}
+		if gw.Address == "" {
+			hc.Close()
+			return nil, fmt.Errorf("the gateway of zone %s has no address", z)
+		}
+		hkcb, err := gatewayHostKeys(gw)
+		if err != nil {
+			hc.Close()
+			return nil, fmt.Errorf("zone %s: %s", z, err)
+		}
+		now := time.Now()
+		cert := &ssh.Certificate{
+			CertType:        ssh.UserCert,
+			KeyId:           cs.userId,
+			ValidPrincipals: []string{hopUser},
+			ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+			ValidBefore:     uint64(now.Add(time.Minute).Unix()),
+			Permissions: ssh.Permissions{
+				Extensions: map[string]string{
+					extRoles: strings.Join(roles, ","),
+					extHops:  chain,
+				},
+			},
+		}
+		if until := cs.allowedUntil(); !until.IsZero() {
+			cert.Extensions[extAllowance] = until.Format(time.RFC3339)
+		}
+		if until := permitUntil(cs.serverConn.Permissions); !until.IsZero() {
+			cert.Extensions[extPermit] = until.Format(time.RFC3339)
+		}
+		signer, err := signCert(ck, cert)
+		if err != nil {
+			hc.Close()
+			return nil, err
+		}
+		cfg := &ssh.ClientConfig{
+			User:            hopUser,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hkcb,
+		}
+		conn, err := hc.dial(gw.Address)
+		if err != nil {
+			hc.Close()
+			return nil, fmt.Errorf("cannot reach the gateway of zone %s: %s", z, err)
+		}
+		c, chans, reqs, err := ssh.NewClientConn(conn, gw.Address, cfg)
+		if err != nil {
+			conn.Close()
+			hc.Close()
+			return nil, fmt.Errorf("cannot login at the gateway of zone %s: %s", z, err)
+		}
+		hc.clients = append(hc.clients, ssh.NewClient(c, chans, reqs))
+		chain += ">" + z
+		cs.debugf("connected to the gateway %s of zone %s", gw.Address, z)
+	}
+	conn, err := hc.dial(addr)
+	if err != nil {
+		hc.Close()
+		return nil, err
+	}
+	hc.Conn = conn
+	return hc, nil
+}
+
+// Serve a gateway of another zone which uses this gateway as a jump host.
+// Only direct-tcpip channels are allowed and the targets must pass the
+// CIDR rules of this zone.
+func newHopSession(tcpconn net.Conn, sshConn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) (*clientSession, error) {
+	cs := &clientSession{tcpConnection: tcpconn, serverConn: sshConn, closed: make(chan struct{})}
+	cs.userId, _ = userOf(sshConn.Permissions)
+	cs.sessionId = fmt.Sprintf("%x", sshConn.SessionID())
+	cs.logger = logging.New(cs.sessionId, sshConn.RemoteAddr().String())
+	cs.logger.SetChain(cs.chain())
+	cs.infof("new hop for %s", cs.userId)
+	go ssh.DiscardRequests(reqs)
+	go cs.handleHopChannels(chans)
+	cs.watch()
+	cs.track()
+	go func() {
+		sshConn.Wait()
+		close(cs.closed)
+	}()
+	return cs, nil
+}
+
+func (cs *clientSession) handleHopChannels(chans <-chan ssh.NewChannel) {
+	for nc := range chans {
+		cs.touch()
+		if nc.ChannelType() != "direct-tcpip" {
+			nc.Reject(ssh.Prohibited, fmt.Sprintf("%s is not allowed for hops", nc.ChannelType()))
+			continue
+		}
+		var dt directTcpip
+		if err := ssh.Unmarshal(nc.ExtraData(), &dt); err != nil {
+			nc.Reject(ssh.ConnectionFailed, "illegal direct-tcpip request")
+			continue
+		}
+		addr := net.JoinHostPort(dt.Host, strconv.Itoa(int(dt.Port)))
+		if err := checkBackendAccess(dt.Host, *configuration); err != nil {
+			cs.infof("hop to %s denied: %s", addr, err)
+			nc.Reject(ssh.Prohibited, fmt.Sprintf("%s is not allowed", addr))
+			continue
+		}
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, rqs, err := nc.Accept()
+		if err != nil {
+			cs.errorf("accepting hop channel: %s", err)
+			conn.Close()
+			continue
+		}
+		go ssh.DiscardRequests(rqs)
+		cs.infof("hop to %s", addr)
+		go func() {
+			wch := cs.wrap(ch)
+			go io.Copy(conn, wch)
+			io.Copy(wch, conn)
+			ch.Close()
+			conn.Close()
+		}()
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/logging"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSplitHops(t *testing.T) {
+	host, hops, err := splitHops("db1%dmz%lab")
+	if err != nil || host != "db1" || len(hops) != 2 || hops[0] != "dmz" || hops[1] != "lab" {
+		t.Errorf("db1%%dmz%%lab split to %s %v %v", host, hops, err)
+	}
+	host, hops, err = splitHops("db1")
+	if err != nil || host != "db1" || len(hops) != 0 {
+		t.Errorf("db1 split to %s %v %v", host, hops, err)
+	}
+	if _, _, err := splitHops("db1%"); err == nil {
+		t.Errorf("an empty zone should not be allowed")
+	}
+}
+
+// a hop certificate with the given chain and further extensions as pairs
+// of name and value.
+func hopCert(t *testing.T, ca ssh.Signer, hops string, exts ...string) ssh.PublicKey {
+	now := time.Now()
+	cert := &ssh.Certificate{
+		CertType:        ssh.UserCert,
+		KeyId:           "alice",
+		ValidPrincipals: []string{hopUser},
+		ValidAfter:      uint64(now.Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(now.Add(time.Minute).Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{extRoles: "user,manager", extHops: hops},
+		},
+	}
+	for i := 0; i+1 < len(exts); i += 2 {
+		cert.Extensions[exts[i]] = exts[i+1]
+	}
+	s, err := signCert(ca, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.PublicKey()
+}
+
+func TestHopAuth(t *testing.T) {
+	ck := newTestSigner(t)
+	clusterKey = ck
+	defer func() { clusterKey = nil }()
+
+	perms, err := hopAuth(&fakeConnMeta{user: hopUser}, hopCert(t, ck, "10.0.0.1:1234>intranet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isHop(perms) || perms.Extensions[permHop] != "10.0.0.1:1234>intranet" {
+		t.Errorf("the hop chain is missing: %v", perms.Extensions)
+	}
+	uid, roles := userOf(perms)
+	if uid != "alice" || len(roles) != 2 {
+		t.Errorf("the identity of the user is wrong: %s %v", uid, roles)
+	}
+	if !permitUntil(perms).IsZero() {
+		t.Errorf("a hop without permit should not end the session: %v", perms.Extensions)
+	}
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	perms, err = hopAuth(&fakeConnMeta{user: hopUser}, hopCert(t, ck, "10.0.0.1:1234>intranet", extPermit, until.Format(time.RFC3339)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !permitUntil(perms).Equal(until) {
+		t.Errorf("the hop should keep the permit of the first gateway: %v", perms.Extensions)
+	}
+
+	if _, err := hopAuth(&fakeConnMeta{user: "root"}, hopCert(t, ck, "10.0.0.1:1234>intranet")); err == nil {
+		t.Errorf("a certificate for another principal should not be accepted")
+	}
+	if _, err := hopAuth(&fakeConnMeta{user: hopUser}, hopCert(t, newTestSigner(t), "10.0.0.1:1234>intranet")); err == nil {
+		t.Errorf("a certificate of another CA should not be accepted")
+	}
+	if _, err := hopAuth(&fakeConnMeta{user: hopUser}, hopCert(t, ck, "")); err == nil {
+		t.Errorf("a certificate without hops should not be accepted")
+	}
+}
+
+func TestHostGateway(t *testing.T) {
+	oldConfiger, oldConfiguration := configer, configuration
+	defer func() { configer, configuration = oldConfiger, oldConfiguration }()
+	configuration = &config.Gateway{CAKey: "local"}
+	configer = &zoneConfig{gateways: map[string]config.Gateway{"dmz": {CAKey: "dmz"}}}
+
+	if gw, err := hopSession("alice", "USER", "root").hostGateway(); err != nil || gw.CAKey != "local" {
+		t.Errorf("a local backend should use the gateway of this zone: %v %v", gw, err)
+	}
+	if gw, err := hopSession("alice", "USER", "root", "dmz").hostGateway(); err != nil || gw.CAKey != "dmz" {
+		t.Errorf("a backend of a hop zone should use the gateway of its zone: %v %v", gw, err)
+	}
+}
+
+func TestIsClusterCert(t *testing.T) {
+	ck := newTestSigner(t)
+	clusterKey = ck
+	defer func() { clusterKey = nil }()
+
+	if !isClusterCert(hopCert(t, ck, "10.0.0.1:1234>intranet")) {
+		t.Errorf("a certificate of the cluster key is a hop")
+	}
+	if isClusterCert(hopCert(t, newTestSigner(t), "10.0.0.1:1234>intranet")) {
+		t.Errorf("a certificate of another CA is no hop")
+	}
+	if isClusterCert(ck.PublicKey()) {
+		t.Errorf("a plain key is no hop")
+	}
+	forged := *hopCert(t, newTestSigner(t), "10.0.0.1:1234>intranet").(*ssh.Certificate)
+	forged.SignatureKey = ck.PublicKey()
+	if isClusterCert(&forged) {
+		t.Errorf("a certificate which names the cluster key but is not signed with it is no hop")
+	}
+}
+
+// the configuration of several zones
+type zoneConfig struct {
+	config.Configer
+	policies map[string][]config.Policy
+	gateways map[string]config.Gateway
+	hosts    map[string][]config.Host
+}
+
+func (z *zoneConfig) Policies(zone string) ([]config.Policy, error) {
+	return z.policies[zone], nil
+}
+
+func (z *zoneConfig) GetGateway(zone string) (*config.Gateway, error) {
+	gw := z.gateways[zone]
+	return &gw, nil
+}
+
+func (z *zoneConfig) Hosts(zone string) ([]config.Host, error) {
+	return z.hosts[zone], nil
+}
+
+// an ssh connection from the given client address
+type testSSHConn struct {
+	ssh.Conn
+	addr net.Addr
+}
+
+func (c *testSSHConn) RemoteAddr() net.Addr { return c.addr }
+
+func hopSession(uid, roles, loginUser string, hops ...string) *clientSession {
+	perms := &ssh.Permissions{Extensions: map[string]string{"user_id": uid, "roles": roles}}
+	addr := &net.TCPAddr{IP: net.IPv4(192, 168, 1, 10), Port: 40000}
+	return &clientSession{
+		serverConn: &ssh.ServerConn{Conn: &testSSHConn{addr: addr}, Permissions: perms},
+		userId:     uid,
+		loginUser:  loginUser,
+		hops:       hops,
+		logger:     logging.New("test", addr.String()),
+	}
+}
+
+func TestHopZonePolicies(t *testing.T) {
+	oldConfiger, oldConfiguration, oldZone := configer, configuration, zone
+	defer func() { configer, configuration, zone = oldConfiger, oldConfiguration, oldZone }()
+	zone = "intranet"
+	configuration = &config.Gateway{}
+	configer = &zoneConfig{
+		policies: map[string][]config.Policy{
+			"dmz": {{Id: "dba", Roles: []string{"DBA"}, Hosts: []string{"10.1.0.0/16"}, RemoteUsers: []string{"postgres"}}},
+		},
+		gateways: map[string]config.Gateway{
+			"lab": {RoleClientRules: map[string]config.ClientRules{"DBA": {DeniedCidrs: []string{"192.168.0.0/16"}}}},
+		},
+		hosts: map[string][]config.Host{
+			"dmz": {{Name: "db1", Address: "10.1.0.1", User: "postgres"}, {Name: "web", Address: "10.2.0.1", User: "postgres"}},
+		},
+	}
+	db := config.Host{Name: "db1", Address: "10.1.0.1"}
+
+	if err := hopSession("alice", "USER", "root").useBackend(db); err != nil {
+		t.Errorf("the local zone has no policies and should allow the host: %s", err)
+	}
+	if err := hopSession("alice", "USER", "root", "dmz").useBackend(db); err == nil {
+		t.Errorf("the policies of the hop zone should deny the host")
+	}
+	if err := hopSession("alice", "DBA", "root", "dmz").useBackend(db); err == nil {
+		t.Errorf("the policies of the hop zone should deny the remote user")
+	}
+	cs := hopSession("alice", "DBA", "postgres", "dmz")
+	if err := cs.useBackend(db); err != nil {
+		t.Errorf("the policy of the hop zone should allow the dba: %s", err)
+	}
+	if err := hopSession("alice", "DBA", "postgres", "lab").useBackend(db); err == nil {
+		t.Errorf("the role client rules of the hop zone should deny the client")
+	}
+
+	if err := cs.useBackend(db); err != nil {
+		t.Errorf("selecting the same backend again should work: %s", err)
+	}
+	if err := cs.useBackend(config.Host{Name: "db2", Address: "10.1.0.2"}); err == nil {
+		t.Errorf("a connection should keep its first backend")
+	}
+	if host, _ := cs.selection(); host != db.Address {
+		t.Errorf("the selected backend should stay %s, not %s", db.Address, host)
+	}
+
+	hosts, err := hopSession("alice", "DBA", "", "dmz").reachableHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || hosts[0].Name != "db1" {
+		t.Errorf("only db1 should be reachable in the hop zone: %v", hosts)
+	}
+}
+
+// Start a gateway of another zone with the host keys of gw which connects
+// direct-tcpip channels to their target.
+func startHopGateway(t *testing.T, gw *config.Gateway) string {
+	signers, err := parseHostKeys(gw.ActiveHostKeys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sconf := &ssh.ServerConfig{NoClientAuth: true}
+	for _, s := range signers {
+		sconf.AddHostKey(s)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer l.Close()
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(c, sconf)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for nc := range chans {
+			var dt directTcpip
+			ssh.Unmarshal(nc.ExtraData(), &dt)
+			conn, err := net.Dial("tcp", net.JoinHostPort(dt.Host, fmt.Sprint(dt.Port)))
+			if err != nil {
+				nc.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			ch, rqs, _ := nc.Accept()
+			go ssh.DiscardRequests(rqs)
+			go io.Copy(conn, ch)
+			go io.Copy(ch, conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestHopWithRotatedHostKeys(t *testing.T) {
+	oldConfiger := configer
+	defer func() { configer = oldConfiger; clusterKey = nil }()
+	clusterKey = newTestSigner(t)
+
+	gw, err := config.GenerateGateway()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the next keys have no RSA key, so there is no HostKey afterwards
+	if err := gw.PrepareHostKeys([]string{config.HostKeyEd25519, config.HostKeyECDSA}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.ActivateHostKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if gw.HostKey != "" {
+		t.Fatalf("the rotated gateway should have no RSA host key")
+	}
+	gw.Address = startHopGateway(t, gw)
+	configer = &zoneConfig{gateways: map[string]config.Gateway{"dmz": *gw}}
+
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		c, err := target.Accept()
+		if err == nil {
+			fmt.Fprintf(c, "hello over dmz\n")
+			c.Close()
+		}
+	}()
+
+	conn, err := hopSession("alice", "USER", "root", "dmz").dialHops(target.Addr().String())
+	if err != nil {
+		t.Fatalf("the hop should accept the rotated host keys: %s", err)
+	}
+	defer conn.Close()
+	if l, _ := bufio.NewReader(conn).ReadString('\n'); l != "hello over dmz\n" {
+		t.Errorf("the target should be reached over the hop, got %q", l)
+	}
+}
+
+func TestGatewayHostKeys(t *testing.T) {
+	gw, err := config.GenerateGateway()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.PrepareHostKeys(config.DefaultHostKeyAlgorithms); err != nil {
+		t.Fatal(err)
+	}
+	cb, err := gatewayHostKeys(gw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := parseHostKeys(append(gw.ActiveHostKeys(), gw.NextHostKeys...))
+	for _, k := range keys {
+		if err := cb("dmz", nil, k.PublicKey()); err != nil {
+			t.Errorf("the %s key should be accepted: %s", k.PublicKey().Type(), err)
+		}
+	}
+	if err := cb("dmz", nil, newTestSigner(t).PublicKey()); err == nil {
+		t.Errorf("an unknown key must be refused")
+	}
+	if _, err := gatewayHostKeys(&config.Gateway{}); err == nil {
+		t.Errorf("a gateway without host keys cannot be pinned")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The OpenSSH extensions to announce all host keys of a server and to
+// prove the possession of the keys the client does not know yet. With
+// them clients learn the next keys before a rotation.
+const (
+	hostKeysRequest = "hostkeys-00@openssh.com"
+	hostKeysProve   = "hostkeys-prove-00@openssh.com"
+)
+
+var (
+	// the active and the next host keys of the zone
+	hostSigners []ssh.Signer
+)
+
+func parseHostKeys(keys []string) ([]ssh.Signer, error) {
+	var res []ssh.Signer
+	for _, k := range keys {
+		s, err := ssh.ParsePrivateKey([]byte(k))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+func currentHostSigners() []ssh.Signer {
+	lock.Lock()
+	defer lock.Unlock()
+	return hostSigners
+}
+
+// the payload of a hostkeys-00 request: the blobs of the keys
+func hostKeysPayload(signers []ssh.Signer) []byte {
+	var res []byte
+	for _, s := range signers {
+		res = append(res, ssh.Marshal(struct{ Key []byte }{s.PublicKey().Marshal()})...)
+	}
+	return res
+}
+
+// Sign the session id with every host key the client asks for. RSA keys
+// sign with SHA-512.
+func proveHostKeys(sessionID, payload []byte, signers []ssh.Signer) ([]byte, error) {
+	var res []byte
+	for len(payload) > 0 {
+		var k struct {
+			Key  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(payload, &k); err != nil {
+			return nil, err
+		}
+		payload = k.Rest
+		var signer ssh.Signer
+		for _, s := range signers {
+			if string(s.PublicKey().Marshal()) == string(k.Key) {
+				signer = s
+			}
+		}
+		if signer == nil {
+			return nil, fmt.Errorf("unknown host key")
+		}
+		data := ssh.Marshal(struct {
+			Request string
+			Session []byte
+			Key     []byte
+		}{hostKeysProve, sessionID, k.Key})
+		var sig *ssh.Signature
+		var err error
+		if as, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+			sig, err = as.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+		} else {
+			sig, err = signer.Sign(rand.Reader, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, ssh.Marshal(struct{ Sig []byte }{ssh.Marshal(sig)})...)
+	}
+	return res, nil
+}
+
+// tell the client all host keys of the zone
+func (cs *clientSession) announceHostKeys() {
+	if _, _, err := cs.serverConn.SendRequest(hostKeysRequest, false, hostKeysPayload(currentHostSigners())); err != nil {
+		cs.debugf("cannot announce host keys: %s", err)
+	}
+}
+
+func (cs *clientSession) proveHostKeys(rq *ssh.Request) {
+	res, err := proveHostKeys(cs.serverConn.SessionID(), rq.Payload, currentHostSigners())
+	if err != nil {
+		cs.warnf("cannot prove host keys: %s", err)
+	}
+	if rq.WantReply {
+		rq.Reply(err == nil, res)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/clusterit/orca/config"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestProveHostKeys(t *testing.T) {
+	var keys []string
+	for _, alg := range config.DefaultHostKeyAlgorithms {
+		k, err := config.GenerateHostKey(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	signers, err := parseHostKeys(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sid := []byte("session id")
+	// the client asks for the keys it does not know
+	res, err := proveHostKeys(sid, hostKeysPayload(signers[1:]), signers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range signers[1:] {
+		var p struct {
+			Sig  []byte
+			Rest []byte `ssh:"rest"`
+		}
+		if err := ssh.Unmarshal(res, &p); err != nil {
+			t.Fatalf("missing signature of %s: %s", s.PublicKey().Type(), err)
+		}
+		res = p.Rest
+		var sig ssh.Signature
+		if err := ssh.Unmarshal(p.Sig, &sig); err != nil {
+			t.Fatal(err)
+		}
+		data := ssh.Marshal(struct {
+			Request string
+			Session []byte
+			Key     []byte
+		}{hostKeysProve, sid, s.PublicKey().Marshal()})
+		if err := s.PublicKey().Verify(data, &sig); err != nil {
+			t.Errorf("the signature of %s is wrong: %s", s.PublicKey().Type(), err)
+		}
+	}
+	if len(res) != 0 {
+		t.Errorf("there should be one signature per key")
+	}
+	other, _ := config.GenerateHostKey(config.HostKeyEd25519)
+	unknown, _ := parseHostKeys([]string{other})
+	if _, err := proveHostKeys(sid, hostKeysPayload(unknown), signers); err == nil {
+		t.Errorf("unknown keys cannot be proven")
+	}
+}
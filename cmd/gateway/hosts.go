@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/clusterit/orca/common"
+	"github.com/clusterit/orca/config"
+)
+
+// Resolve the target of a login with the host inventory of the zone. The
+// target can be the name of a host or a DNS name/IP with an optional port
+// (db1:2222, [fe80::1]:2222) or tag:<tag> for all hosts with the tag.
+// Names which are not in the inventory are used as the address.
+func resolveTarget(cfg config.Configer, zone, target string) ([]config.Host, error) {
+	if target == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(target, config.TagPrefix) {
+		tag := target[len(config.TagPrefix):]
+		hosts, err := cfg.Hosts(zone)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read hosts: %s", err)
+		}
+		var res []config.Host
+		for _, h := range hosts {
+			if h.HasTag(tag) {
+				res = append(res, h)
+			}
+		}
+		if len(res) == 0 {
+			return nil, fmt.Errorf("there is no host with the tag %q", tag)
+		}
+		return res, nil
+	}
+	name, port := target, 0
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		port, err = strconv.Atoi(p)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("illegal port in target: %s", target)
+		}
+		name = h
+	}
+	host := &config.Host{Name: name, Address: name}
+	if !strings.Contains(name, "/") {
+		h, err := cfg.Host(zone, name)
+		if err == nil {
+			host = h
+		} else if !common.IsNotFound(err) {
+			return nil, fmt.Errorf("cannot read host %s: %s", name, err)
+		}
+	}
+	if port != 0 {
+		host.Port = port
+	}
+	return []config.Host{*host}, nil
+}
+
+// the user to login at the given host: the user of the login or the
+// default user of the host.
+func (cs *clientSession) remoteUserFor(h config.Host) (string, error) {
+	if cs.loginUser != "" {
+		return cs.loginUser, nil
+	}
+	if h.User != "" {
+		return h.User, nil
+	}
+	return "", fmt.Errorf("no remote user given for %s", h.Name)
+}
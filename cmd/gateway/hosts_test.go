@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/clusterit/orca/common"
+	"github.com/clusterit/orca/config"
+)
+
+type inventory struct {
+	config.Configer
+	hosts []config.Host
+}
+
+func (i *inventory) Hosts(zone string) ([]config.Host, error) {
+	return i.hosts, nil
+}
+
+func (i *inventory) Host(zone, name string) (*config.Host, error) {
+	for _, h := range i.hosts {
+		if h.Name == name {
+			return &h, nil
+		}
+	}
+	return nil, common.ErrNotFound
+}
+
+func TestResolveTarget(t *testing.T) {
+	inv := &inventory{hosts: []config.Host{
+		{Name: "db1", Address: "10.0.0.1", Port: 2222, Tags: []string{"db"}, User: "postgres"},
+		{Name: "db2", Address: "10.0.0.2", Tags: []string{"db"}},
+		{Name: "web", Address: "web.intranet", Tags: []string{"www"}},
+	}}
+	tests := map[string][]string{
+		"":               nil,
+		"db1":            {"10.0.0.1:2222"},
+		"db1:22":         {"10.0.0.1:22"},
+		"other":          {"other:22"},
+		"other:2200":     {"other:2200"},
+		"[fe80::1]:2200": {"[fe80::1]:2200"},
+		"tag:db":         {"10.0.0.1:2222", "10.0.0.2:22"},
+		"tag:www":        {"web.intranet:22"},
+	}
+	for target, addrs := range tests {
+		hosts, err := resolveTarget(inv, "test", target)
+		if err != nil {
+			t.Errorf("%q: %s", target, err)
+			continue
+		}
+		if len(hosts) != len(addrs) {
+			t.Errorf("%q should resolve to %v, not %v", target, addrs, hosts)
+			continue
+		}
+		for i, h := range hosts {
+			if h.Addr() != addrs[i] {
+				t.Errorf("%q should resolve to %s, not %s", target, addrs[i], h.Addr())
+			}
+		}
+	}
+	for _, target := range []string{"tag:none", "db1:0", "db1:ssh"} {
+		if _, err := resolveTarget(inv, "test", target); err == nil {
+			t.Errorf("%q should not be resolved", target)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/clusterit/orca/common"
+	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/users"
+	"golang.org/x/crypto/ssh"
+)
+
+// The error which is returned if a backend presents an unknown key.
+type hostKeyError struct {
+	host   string
+	key    ssh.PublicKey
+	reason string
+}
+
+func (e *hostKeyError) Error() string {
+	return fmt.Sprintf("host key verification failed for %s (%s %s): %s", e.host, e.key.Type(), users.Fingerprint(e.key), e.reason)
+}
+
+// Create the callback to verify the host keys of the backends according
+// to the HostKeyCheck mode of the gateway of the backend's zone.
+func (cs *clientSession) hostKeyCallback(gw *config.Gateway) ssh.HostKeyCallback {
+	mode := gw.HostKeyCheck
+	if mode == config.HostKeyCheckCA {
+		cas := gw.HostCAKeys
+		checker := &ssh.CertChecker{
+			IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+				return isHostAuthority(cas, auth)
+			},
+			HostKeyFallback: cs.checkKnownHost(false),
+		}
+		return checker.CheckHostKey
+	}
+	return cs.checkKnownHost(mode != config.HostKeyCheckStrict)
+}
+
+// the known hosts are kept in the zone of the backend
+func (cs *clientSession) checkKnownHost(tofu bool) ssh.HostKeyCallback {
+	z := cs.hostZone()
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		host := config.NormalizeHost(hostname)
+		kh, err := configer.KnownHost(z, host)
+		if common.IsNotFound(err) {
+			if !tofu {
+				return &hostKeyError{host, key, "the host is unknown"}
+			}
+			cs.infof("trust on first use: storing %s key %s for %s", key.Type(), users.Fingerprint(key), host)
+			_, err = configer.PinHostKey(z, host, string(ssh.MarshalAuthorizedKey(key)))
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read known hosts: %s", err)
+		}
+		if kh.IsRevoked(key) {
+			return &hostKeyError{host, key, "the key is revoked"}
+		}
+		if kh.Knows(key) {
+			return nil
+		}
+		if len(kh.Keys) == 0 && tofu {
+			cs.infof("trust on first use: storing %s key %s for %s", key.Type(), users.Fingerprint(key), host)
+			_, err = configer.PinHostKey(z, host, string(ssh.MarshalAuthorizedKey(key)))
+			return err
+		}
+		return &hostKeyError{host, key, "the key does not match the known keys, possible man in the middle attack"}
+	}
+}
+
+func isHostAuthority(cas []string, auth ssh.PublicKey) bool {
+	a := auth.Marshal()
+	for _, c := range cas {
+		pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c))
+		if err != nil {
+			logger.Warnf("the host CA key %q cannot be parsed, ignoring", c)
+			continue
+		}
+		if string(pk.Marshal()) == string(a) {
+			return true
+		}
+	}
+	return false
+}
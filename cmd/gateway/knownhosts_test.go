@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clusterit/orca/common"
+	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/logging"
+	"github.com/clusterit/orca/users"
+	"golang.org/x/crypto/ssh"
+)
+
+// the known hosts of the zone, zone is the zone of the last lookup
+type knownHosts struct {
+	config.Configer
+	hosts map[string]*config.KnownHost
+	zone  string
+}
+
+func (k *knownHosts) KnownHost(zone, host string) (*config.KnownHost, error) {
+	k.zone = zone
+	kh := k.hosts[host]
+	if kh == nil {
+		return nil, common.ErrNotFound
+	}
+	return kh, nil
+}
+
+func (k *knownHosts) PinHostKey(zone, host, key string) (*config.KnownHost, error) {
+	k.zone = zone
+	kh := k.hosts[host]
+	if kh == nil {
+		kh = &config.KnownHost{Host: host}
+		k.hosts[host] = kh
+	}
+	kh.Keys = append(kh.Keys, strings.TrimSpace(key))
+	return kh, nil
+}
+
+func authorizedKey(k ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k)))
+}
+
+// a host certificate of the CA for the principal
+func hostCert(t *testing.T, ca ssh.Signer, principal string) ssh.PublicKey {
+	cert := &ssh.Certificate{
+		Key:             newTestSigner(t).PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{principal},
+		ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// a session of alice to a backend
+func knownHostSession() *clientSession {
+	return &clientSession{userId: "alice@github", remoteUser: "root", logger: logging.New("42", "192.168.1.10:40000")}
+}
+
+func withKnownHosts(mode string, cas []string, hosts map[string]*config.KnownHost) (*knownHosts, func()) {
+	oldConfiger, oldConfiguration := configer, configuration
+	kh := &knownHosts{hosts: hosts}
+	configer = kh
+	configuration = &config.Gateway{HostKeyCheck: mode, HostCAKeys: cas}
+	return kh, func() { configer, configuration = oldConfiger, oldConfiguration }
+}
+
+var backendAddr = &net.TCPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 22}
+
+func TestTrustOnFirstUse(t *testing.T) {
+	kh, restore := withKnownHosts(config.HostKeyCheckTofu, nil, map[string]*config.KnownHost{})
+	defer restore()
+	key := newTestSigner(t).PublicKey()
+
+	cb := knownHostSession().hostKeyCallback(configuration)
+	if err := cb("db1:22", backendAddr, key); err != nil {
+		t.Fatalf("the first key of a host should be trusted: %s", err)
+	}
+	if h := kh.hosts["db1"]; h == nil || len(h.Keys) != 1 || h.Keys[0] != authorizedKey(key) {
+		t.Fatalf("the first key should be pinned: %+v", h)
+	}
+	if err := cb("db1:22", backendAddr, key); err != nil {
+		t.Errorf("the pinned key should match: %s", err)
+	}
+
+	other := newTestSigner(t).PublicKey()
+	err := cb("db1:22", backendAddr, other)
+	if _, ok := err.(*hostKeyError); !ok {
+		t.Fatalf("another key should not match: %v", err)
+	}
+	if !strings.Contains(err.Error(), "host key verification failed for db1") ||
+		!strings.Contains(err.Error(), users.Fingerprint(other)) ||
+		!strings.Contains(err.Error(), "possible man in the middle attack") {
+		t.Errorf("the error should name the host, the key and the reason: %s", err)
+	}
+	if len(kh.hosts["db1"].Keys) != 1 {
+		t.Errorf("a mismatching key must not be pinned")
+	}
+
+	if err := cb("db2:2222", backendAddr, other); err != nil {
+		t.Errorf("the first key of another host should be trusted: %s", err)
+	}
+	if kh.hosts["[db2]:2222"] == nil {
+		t.Errorf("the host should be stored with its port")
+	}
+
+	kh.hosts["db3"] = &config.KnownHost{Host: "db3", Revoked: []string{authorizedKey(key)}}
+	if err := cb("db3:22", backendAddr, key); err == nil || !strings.Contains(err.Error(), "the key is revoked") {
+		t.Errorf("a revoked key should not be trusted: %v", err)
+	}
+}
+
+func TestStrictHostKeys(t *testing.T) {
+	key := newTestSigner(t).PublicKey()
+	kh, restore := withKnownHosts(config.HostKeyCheckStrict, nil, map[string]*config.KnownHost{
+		"db1": {Host: "db1", Keys: []string{authorizedKey(key)}},
+	})
+	defer restore()
+	cb := knownHostSession().hostKeyCallback(configuration)
+
+	if err := cb("db1:22", backendAddr, key); err != nil {
+		t.Errorf("the pinned key should match: %s", err)
+	}
+	if err := cb("db1:22", backendAddr, newTestSigner(t).PublicKey()); err == nil {
+		t.Errorf("another key should not match")
+	}
+	err := cb("db2:22", backendAddr, key)
+	if err == nil || !strings.Contains(err.Error(), "the host is unknown") {
+		t.Errorf("an unknown host should be rejected: %v", err)
+	}
+	if kh.hosts["db2"] != nil {
+		t.Errorf("the key of an unknown host must not be pinned")
+	}
+}
+
+func TestHostCertificates(t *testing.T) {
+	ca := newTestSigner(t)
+	pinned := newTestSigner(t).PublicKey()
+	kh, restore := withKnownHosts(config.HostKeyCheckCA, []string{"not a key", authorizedKey(ca.PublicKey())}, map[string]*config.KnownHost{
+		"db2": {Host: "db2", Keys: []string{authorizedKey(pinned)}},
+	})
+	defer restore()
+	cb := knownHostSession().hostKeyCallback(configuration)
+
+	if err := cb("db1:22", backendAddr, hostCert(t, ca, "db1")); err != nil {
+		t.Errorf("a certificate of the CA should be accepted: %s", err)
+	}
+	if err := cb("db1:22", backendAddr, hostCert(t, ca, "db9")); err == nil {
+		t.Errorf("a certificate for another host should not be accepted")
+	}
+	if err := cb("db1:22", backendAddr, hostCert(t, newTestSigner(t), "db1")); err == nil {
+		t.Errorf("a certificate of another CA should not be accepted")
+	}
+	if err := cb("db2:22", backendAddr, pinned); err != nil {
+		t.Errorf("a pinned key should be accepted as a fallback: %s", err)
+	}
+	if err := cb("db3:22", backendAddr, newTestSigner(t).PublicKey()); err == nil {
+		t.Errorf("a plain key of an unknown host should not be accepted")
+	}
+	if len(kh.hosts) != 1 {
+		t.Errorf("the CA mode must not pin keys: %v", kh.hosts)
+	}
+}
+
+func TestHopZoneHostKeys(t *testing.T) {
+	oldZone := zone
+	defer func() { zone = oldZone }()
+	zone = "intranet"
+	kh, restore := withKnownHosts(config.HostKeyCheckStrict, nil, map[string]*config.KnownHost{})
+	defer restore()
+	ca := newTestSigner(t)
+	cs := knownHostSession()
+	cs.hops = []string{"dmz"}
+	cb := cs.hostKeyCallback(&config.Gateway{HostKeyCheck: config.HostKeyCheckCA, HostCAKeys: []string{authorizedKey(ca.PublicKey())}})
+
+	if err := cb("db1:22", backendAddr, hostCert(t, ca, "db1")); err != nil {
+		t.Errorf("a certificate of the CA of the hop zone should be accepted: %s", err)
+	}
+	if err := cb("db2:22", backendAddr, newTestSigner(t).PublicKey()); err == nil {
+		t.Errorf("a plain key of an unknown host should not be accepted")
+	}
+	if kh.zone != "dmz" {
+		t.Errorf("the known hosts of the hop zone should be used, not %q", kh.zone)
+	}
+}
+
+func TestIsHostAuthority(t *testing.T) {
+	ca := newTestSigner(t).PublicKey()
+	if !isHostAuthority([]string{"garbage", authorizedKey(ca) + " ca@example"}, ca) {
+		t.Errorf("the CA should be found after a key which cannot be parsed")
+	}
+	if isHostAuthority([]string{authorizedKey(ca)}, newTestSigner(t).PublicKey()) {
+		t.Errorf("another key is no authority")
+	}
+	if isHostAuthority(nil, ca) {
+		t.Errorf("without CA keys there is no authority")
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/clusterit/orca/bans"
+	"github.com/clusterit/orca/common"
+	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/logging"
+)
+
+// the window of the connection rate
+const rateWindow = time.Minute
+
+var gate = newGuard(nil)
+
+// the failed logins of an address or a user in a row
+type strikes struct {
+	n    int
+	last time.Time
+	next time.Time
+}
+
+// A guard limits the connections per source address. After a failed
+// login the address has to wait before the next try, the time doubles
+// with every failure. Too many failures in a row ban it. The same holds
+// for a user who enters wrong verification codes.
+type guard struct {
+	mux     sync.Mutex
+	conns   map[string][]time.Time
+	strikes map[string]*strikes
+	swept   time.Time
+	// the bans are shared with the other gateways, without a store they
+	// are only kept here
+	store bans.Bans
+	local map[string]bans.Ban
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func newGuard(store bans.Bans) *guard {
+	return &guard{
+		conns:   make(map[string][]time.Time),
+		strikes: make(map[string]*strikes),
+		store:   store,
+		local:   make(map[string]bans.Ban),
+		now:     time.Now,
+		sleep:   time.Sleep,
+	}
+}
+
+// the address of a client without the port
+func addressOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Check a new connection from the address.
+func (g *guard) checkConn(ip string, l config.Limits) error {
+	key := bans.AddressKey(ip)
+	if err := g.check(key); err != nil {
+		return err
+	}
+	g.mux.Lock()
+	now := g.now()
+	g.sweep(now, l)
+	var recent []time.Time
+	for _, t := range g.conns[ip] {
+		if now.Sub(t) < rateWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= l.ConnRate {
+		g.conns[ip] = recent
+		g.mux.Unlock()
+		g.failed(key, "connection rate exceeded", l)
+		return fmt.Errorf("more than %d connections per minute", l.ConnRate)
+	}
+	g.conns[ip] = append(recent, now)
+	g.mux.Unlock()
+	return nil
+}
+
+// forget the connections and failures which do not count anymore
+func (g *guard) sweep(now time.Time, l config.Limits) {
+	if now.Sub(g.swept) < rateWindow {
+		return
+	}
+	g.swept = now
+	for ip, ts := range g.conns {
+		if len(ts) == 0 || now.Sub(ts[len(ts)-1]) >= rateWindow {
+			delete(g.conns, ip)
+		}
+	}
+	for key, s := range g.strikes {
+		if now.Sub(s.last) > time.Duration(l.BanTime)*time.Second {
+			delete(g.strikes, key)
+		}
+	}
+}
+
+// Returns an error if the address or user is banned or has to wait
+// after a failed login.
+func (g *guard) check(key string) error {
+	g.mux.Lock()
+	s := g.strikes[key]
+	now := g.now()
+	if s != nil && now.Before(s.next) {
+		g.mux.Unlock()
+		return fmt.Errorf("too many failed logins, retry in %s", s.next.Sub(now).Round(time.Second))
+	}
+	g.mux.Unlock()
+	b := g.ban(key)
+	if b != nil && now.Before(b.Until) {
+		return fmt.Errorf("banned until %s: %s", b.Until.Format(time.RFC3339), b.Reason)
+	}
+	return nil
+}
+
+// Wait until the key may try again after its last failure.
+func (g *guard) wait(key string) {
+	var d time.Duration
+	g.mux.Lock()
+	if s := g.strikes[key]; s != nil {
+		d = s.next.Sub(g.now())
+	}
+	g.mux.Unlock()
+	if d > 0 {
+		g.sleep(d)
+	}
+}
+
+// the last ban of the key, it may be over
+func (g *guard) ban(key string) *bans.Ban {
+	if g.store == nil {
+		g.mux.Lock()
+		defer g.mux.Unlock()
+		if b, ok := g.local[key]; ok {
+			return &b
+		}
+		return nil
+	}
+	b, err := g.store.Get(key)
+	if err != nil {
+		if !common.IsNotFound(err) {
+			Log(logging.Warn, "cannot read ban of %s: %s", key, err)
+		}
+		return nil
+	}
+	return b
+}
+
+// Count a failed login of the key. The key must wait before the next try
+// and is banned after too many failures in a row.
+func (g *guard) failed(key, reason string, l config.Limits) {
+	g.mux.Lock()
+	now := g.now()
+	s := g.strikes[key]
+	if s == nil || now.Sub(s.last) > time.Duration(l.BanTime)*time.Second {
+		s = &strikes{}
+		g.strikes[key] = s
+	}
+	s.n++
+	s.last = now
+	wait := time.Second << uint(s.n-1)
+	if max := time.Duration(l.BanTime) * time.Second; wait > max {
+		wait = max
+	}
+	s.next = now.Add(wait)
+	banned := s.n >= l.MaxAuthFailures
+	if banned {
+		delete(g.strikes, key)
+	}
+	g.mux.Unlock()
+	if !banned {
+		return
+	}
+
+	b := bans.Ban{Key: key, Reason: reason, Zone: zone, Count: 1}
+	if prev := g.ban(key); prev != nil {
+		b.Count = prev.Count + 1
+	}
+	b.Until = now.Add(l.BanDuration(b.Count))
+	Log(logging.Warn, "banning %s until %s: %s", key, b.Until.Format(time.RFC3339), reason)
+	if g.store == nil {
+		g.mux.Lock()
+		g.local[key] = b
+		g.mux.Unlock()
+		return
+	}
+	// keep the ban a while after it is over to know the next ban is a repeat
+	keep := uint64(b.Until.Sub(now)/time.Second) + uint64(l.MaxBanTime)
+	if err := g.store.Ban(b, keep); err != nil {
+		Log(logging.Error, "cannot store ban of %s: %s", key, err)
+	}
+}
+
+// forget the failures of the key after a successful login
+func (g *guard) succeeded(key string) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	delete(g.strikes, key)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/clusterit/orca/bans"
+	"github.com/clusterit/orca/config"
+)
+
+func newTestGuard() (*guard, *time.Time) {
+	now := time.Date(2016, 1, 1, 12, 0, 0, 0, time.UTC)
+	g := newGuard(nil)
+	g.now = func() time.Time { return now }
+	return g, &now
+}
+
+func TestConnRate(t *testing.T) {
+	g, now := newTestGuard()
+	l := config.Limits{ConnRate: 3, MaxAuthFailures: 100, BanTime: 60, MaxBanTime: 60}
+	for i := 0; i < 3; i++ {
+		if err := g.checkConn("10.0.0.1", l); err != nil {
+			t.Fatalf("connection %d should be allowed: %s", i, err)
+		}
+	}
+	if err := g.checkConn("10.0.0.1", l); err == nil {
+		t.Errorf("the fourth connection in a minute should be rejected")
+	}
+	if err := g.checkConn("10.0.0.2", l); err != nil {
+		t.Errorf("other addresses should not be limited: %s", err)
+	}
+	*now = now.Add(2 * time.Minute)
+	if err := g.checkConn("10.0.0.1", l); err != nil {
+		t.Errorf("the address should connect again after a while: %s", err)
+	}
+}
+
+func TestBackoffAndBan(t *testing.T) {
+	g, now := newTestGuard()
+	l := config.Limits{ConnRate: 100, MaxAuthFailures: 3, BanTime: 60, MaxBanTime: 600}
+	key := bans.LoginKey("alice")
+	g.failed(key, "failed logins", l)
+	if g.check(key) == nil {
+		t.Errorf("alice should wait after a failure")
+	}
+	*now = now.Add(time.Second)
+	if err := g.check(key); err != nil {
+		t.Errorf("alice should try again after a second: %s", err)
+	}
+	g.failed(key, "failed logins", l)
+	*now = now.Add(time.Second)
+	if g.check(key) == nil {
+		t.Errorf("the wait should double after the second failure")
+	}
+	*now = now.Add(time.Second)
+	g.failed(key, "failed logins", l)
+	*now = now.Add(59 * time.Second)
+	if g.check(key) == nil {
+		t.Errorf("alice should be banned after three failures")
+	}
+	*now = now.Add(2 * time.Second)
+	if err := g.check(key); err != nil {
+		t.Errorf("the ban should be over: %s", err)
+	}
+	for i := 0; i < 3; i++ {
+		g.failed(key, "failed logins", l)
+	}
+	if b := g.ban(key); b == nil || b.Count != 2 || b.Until.Sub(*now) != 2*time.Minute {
+		t.Errorf("the second ban should last twice as long: %+v", b)
+	}
+	g.succeeded(bans.LoginKey("bob"))
+	if err := g.check(bans.LoginKey("bob")); err != nil {
+		t.Errorf("bob has no failures: %s", err)
+	}
+}
@@ -11,7 +11,7 @@ func Log(level logging.LogLevel, format string, data ...interface{}) {
 }
 
 func (cs *clientSession) log(level logging.LogLevel, format string, data ...interface{}) {
-	cs.logger.Log(level, format, data)
+	cs.logger.Log(level, format, data...)
 }
 
 func (cs *clientSession) tracef(format string, data ...interface{}) {
@@ -9,15 +9,19 @@ import (
 	"sync"
 	"time"
 
-	"github.com/clusterit/orca/timebuffer"
-
 	"github.com/hashicorp/logutils"
 
+	"github.com/clusterit/orca/approvals"
+	"github.com/clusterit/orca/audit"
+	"github.com/clusterit/orca/bans"
 	"github.com/clusterit/orca/cmd"
 	"github.com/clusterit/orca/common"
 	"github.com/clusterit/orca/config"
 	"github.com/clusterit/orca/etcd"
 	"github.com/clusterit/orca/logging"
+	"github.com/clusterit/orca/proxyproto"
+	"github.com/clusterit/orca/recording"
+	"github.com/clusterit/orca/sessions"
 	"github.com/clusterit/orca/users"
 
 	"github.com/spf13/viper"
@@ -29,7 +33,8 @@ import (
 )
 
 const (
-	timeoutFor2FAinSeconds = 30
+	// the end of the allowance if the user needed it to login
+	permPermitUntil = "permit_until"
 )
 
 var (
@@ -37,6 +42,8 @@ var (
 	configuration *config.Gateway
 	sshConfig     ssh.ServerConfig
 	configer      config.Configer
+	recordings    recording.Store
+	auditor       audit.Auditor
 	zone          string
 	lock          sync.Mutex
 	revision      = "latest"
@@ -49,6 +56,7 @@ func initGateway() {
 	viper.SetDefault("etcd_machines", "http://localhost:4001")
 
 	viper.SetDefault("zone", "intranet")
+	viper.SetDefault("recordings", "/var/lib/orca/recordings")
 
 	zone = viper.GetString("zone")
 	etcds := strings.Split(viper.GetString("etcd_machines"), ",")
@@ -60,6 +68,9 @@ func initGateway() {
 	if err != nil {
 		panic(err)
 	}
+	if err := cmd.UseKeyring(cc, viper.GetString("master_key_file"), viper.GetString("master_key")); err != nil {
+		panic(err)
+	}
 	fetcher, err = NewHttpFetcher(cc)
 	if err != nil {
 		panic(err)
@@ -70,13 +81,48 @@ func initGateway() {
 	}
 	configer = cfger
 
+	auditor, err = audit.New(cc, audit.DefaultRetention)
+	if err != nil {
+		Log(logging.Warn, "cannot create auditor, events are only logged: %s", err)
+	}
+
+	store, err := bans.New(cc)
+	if err != nil {
+		Log(logging.Warn, "cannot create ban store, bans are not shared with other gateways: %s", err)
+	} else {
+		gate = newGuard(store)
+	}
+
+	approvalStore, err = approvals.New(cc)
+	if err != nil {
+		Log(logging.Warn, "cannot create approval store, logins can only be confirmed with a code: %s", err)
+	}
+
+	reg, err := sessions.New(cc, sessions.DefaultTTL)
+	if err != nil {
+		Log(logging.Warn, "cannot create session registry, sessions are not published: %s", err)
+	} else {
+		initRegistry(reg)
+	}
+
+	recordings, err = recording.NewDirStore(viper.GetString("recordings"))
+	if err != nil {
+		Log(logging.Warn, "cannot use recording directory, sessions will not be recorded: %s", err)
+	}
 }
 
 func initWithConfig(gw *config.Gateway) error {
 	lock.Lock()
 	defer lock.Unlock()
 
-	signer, err := ssh.ParsePrivateKey([]byte(gw.HostKey))
+	signers, err := parseHostKeys(gw.ActiveHostKeys())
+	if err != nil {
+		return err
+	}
+	if len(signers) == 0 {
+		return fmt.Errorf("the gateway has no host key")
+	}
+	next, err := parseHostKeys(gw.NextHostKeys)
 	if err != nil {
 		return err
 	}
@@ -89,10 +135,13 @@ func initWithConfig(gw *config.Gateway) error {
 
 	sshConfig = ssh.ServerConfig{
 		PublicKeyCallback: keyAuth,
-		PasswordCallback:  pwdCallback,
 		ServerVersion:     fmt.Sprintf("SSH-2.0-orca_%s", revision),
 	}
-	sshConfig.AddHostKey(signer)
+	for _, s := range signers {
+		sshConfig.AddHostKey(s)
+	}
+	// copy the active keys, the next ones must not be appended to their array
+	hostSigners = append(append([]ssh.Signer(nil), signers...), next...)
 	configuration = gw
 	return nil
 }
@@ -103,7 +152,21 @@ func initWithSettings(zone string) error {
 		return err
 	}
 	initWithConfig(cfg)
+	if cc, err := configer.Cluster(); err == nil {
+		setClusterKey(cc)
+	}
 
+	go func() {
+		ncc, stp, err := configer.ClusterConfig()
+		if err != nil {
+			Log(logging.Error, "cannot create watcher for cluster config: %s", err)
+			return
+		}
+		for cc := range ncc {
+			setClusterKey(&cc)
+		}
+		close(stp)
+	}()
 	go func() {
 		ngw, stp, err := configer.Gateway(zone)
 		if err != nil {
@@ -119,75 +182,83 @@ func initWithSettings(zone string) error {
 	return nil
 }
 
-func checkAllowed(sessid []byte, u *users.User) error {
+// Check if the user may login with the key. Returns true if the user
+// has to enter a verification code too.
+func checkAllowed(u *users.User) (bool, error) {
 	if u.Use2FA {
 		// if the users has 2FA, check the allowance field if another
 		// check is needed
 		if u.Allowance == nil || u.Allowance.Until.Before(time.Now()) {
-			timebuffer.Put(string(sessid), u, timeoutFor2FAinSeconds)
-			return fmt.Errorf("2FA enabled, next password check")
+			return true, nil
 		}
 
 		if u.Allowance != nil && u.Allowance.Until.After(time.Now().Add(time.Duration(configuration.MaxAutologin2FA)*time.Second)) {
-			timebuffer.Put(string(sessid), u, timeoutFor2FAinSeconds)
-			return fmt.Errorf("allowance too long, next password check")
+			// allowance too long
+			return true, nil
 		}
 		// there is a successfull allowance
-		return nil
+		return false, nil
 	}
 	if configuration.Force2FA {
-		return fmt.Errorf("you must use 2fa!")
+		return false, fmt.Errorf("you must use 2fa!")
 	}
 	if !configuration.CheckAllow {
-		return nil
+		return false, nil
 	}
 	if u.Allowance == nil {
-		return fmt.Errorf("please activate your account")
+		return false, fmt.Errorf("please activate your account")
 	}
 	if u.Allowance.Until.Before(time.Now()) {
-		return fmt.Errorf("your activation timed out")
+		return false, fmt.Errorf("your activation timed out")
 	}
 
-	return nil
+	return false, nil
 }
 
 func keyAuth(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if cert, ok := key.(*ssh.Certificate); ok && isClusterKey(cert.SignatureKey) {
+		return hopAuth(conn, key)
+	}
 	pubk := string(ssh.MarshalAuthorizedKey(key))
 	usr, err := fetcher.UserByKey(strings.TrimSpace(pubk))
 	if err != nil {
 		Log(logging.Debug, "remote: %s: cannot fetch key for user '%s': %s", conn.RemoteAddr().String(), conn.User(), err)
 		return nil, err
 	}
-	Log(logging.Info, "remote user identified: %+v", usr)
-	if err := checkAllowed(conn.SessionID(), usr); err != nil {
-		Log(logging.Debug, "remote: %s: not allowed to login for user '%s': %s", conn.RemoteAddr().String(), conn.User(), err)
+	if err := gate.check(bans.LoginKey(usr.Id)); err != nil {
+		Log(logging.Info, "remote: %s: user '%s' may not login: %s", conn.RemoteAddr().String(), usr.Id, err)
 		return nil, err
 	}
-	Log(logging.Info, "remote: %s: login by %+v", conn.RemoteAddr().String(), usr)
-	return &ssh.Permissions{Extensions: map[string]string{
-		"user_id": usr.Id}}, nil
-}
-
-func pwdCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
-	keyusr := timebuffer.Get(string(conn.SessionID()))
-	if keyusr == nil {
-		Log(logging.Debug, "remote: %s: no key auth happend before OTP check '%s'", conn.RemoteAddr().String(), conn.User())
-		return nil, fmt.Errorf("no key auth happend before OTP check")
+	Log(logging.Info, "remote user identified: %+v", usr)
+	if err := checkClientAccess(net.ParseIP(addressOf(conn.RemoteAddr())), strings.Split(usr.Roles.String(), ","), *configuration); err != nil {
+		Log(logging.Info, "remote: %s: user '%s' may not login from there: %s", conn.RemoteAddr().String(), usr.Id, err)
+		return nil, err
 	}
-	usr := keyusr.(*users.User)
-	ttl := usr.AutologinAfter2FA
-	if ttl > configuration.MaxAutologin2FA {
-		ttl = configuration.MaxAutologin2FA
+	if err := checkSecurityKey(key, usr.Key(strings.TrimSpace(pubk)), strings.Split(usr.Roles.String(), ","), *configuration); err != nil {
+		Log(logging.Info, "remote: %s: user '%s' may not login with key %s: %s", conn.RemoteAddr().String(), usr.Id, users.FingerprintSHA256(key), err)
+		return nil, err
 	}
-	Log(logging.Info, "check password token for '%s', TTL: %d", usr.Id, ttl)
-	err := fetcher.CheckToken(usr.Id, string(password), ttl)
+	needCode, err := checkAllowed(usr)
 	if err != nil {
-		Log(logging.Debug, "remote: %s: wrong token for user '%s': '%s'", conn.RemoteAddr().String(), conn.User(), err)
+		Log(logging.Debug, "remote: %s: not allowed to login for user '%s': %s", conn.RemoteAddr().String(), conn.User(), err)
 		return nil, err
 	}
-
-	return &ssh.Permissions{Extensions: map[string]string{
-		"user_id": string(usr.Id)}}, nil
+	if needCode {
+		// the key is accepted, the client must continue with the code
+		Log(logging.Debug, "remote: %s: user '%s' needs a verification code", conn.RemoteAddr().String(), usr.Id)
+		return nil, &ssh.PartialSuccessError{Next: ssh.ServerAuthCallbacks{
+			KeyboardInteractiveCallback: verificationCode(usr),
+		}}
+	}
+	Log(logging.Info, "remote: %s: login by %+v", conn.RemoteAddr().String(), usr)
+	perms := userPermissions(usr)
+	if usr.Allowance != nil && usr.Allowance.Until.After(time.Now()) {
+		perms.Extensions["allowance_until"] = usr.Allowance.Until.Format(time.RFC3339)
+		if usr.Use2FA || configuration.CheckAllow {
+			perms.Extensions[permPermitUntil] = perms.Extensions["allowance_until"]
+		}
+	}
+	return perms, nil
 }
 
 func main() {
@@ -199,40 +270,96 @@ func main() {
 	initWithSettings(zone)
 
 	bind := viper.GetString("bind")
-	socket, err := net.Listen("tcp", bind)
+	l, err := listen(bind)
 	if err != nil {
 		panic(err)
 	}
+	socket := &proxyproto.Listener{Listener: l, Trusted: trustedProxy}
 	Log(logging.Info, "gateway listens on %#v ...", socket.Addr().String())
+	drained := make(chan struct{})
+	go handleSignals(l, drained)
 	for {
-		defer func() {
-			if r := recover(); r != nil {
-				Log(logging.Error, "panic while serving: %#v", r)
-			}
-		}()
-
 		tcpConn, err := socket.Accept()
 		if err != nil {
+			if isStopping() {
+				<-drained
+				Log(logging.Info, "gateway stopped")
+				return
+			}
 			Log(logging.Error, "failed to accept incoming connection (%s)", err)
 			continue
 		}
-		// set deadline to 60secs
-		tcpConn.SetReadDeadline(time.Now().Add(60 * time.Second))
-		Log(logging.Info, "new connection from %s, Config: %#v", tcpConn.RemoteAddr().String(), sshConfig)
-		sshConn, chans, reqs, err := ssh.NewServerConn(tcpConn, &sshConfig)
-		if err != nil {
-			Log(logging.Error, "failed to ssh connect (%s)", err)
+		go handshake(tcpConn)
+	}
+}
+
+// Do the SSH handshake with a new client and start the session.
+func handshake(tcpConn net.Conn) {
+	defer func() {
+		if r := recover(); r != nil {
+			Log(logging.Error, "panic while serving %s: %#v", tcpConn.RemoteAddr().String(), r)
 			tcpConn.Close()
-			continue
 		}
-		// hardcode: if more than 10min of inactivity, kill connection!
-		_, err = NewSession(tcpConn, 600, sshConn, chans, reqs)
+	}()
+	lock.Lock()
+	cfg := sshConfig
+	timeout := configuration.Handshake()
+	if configuration.PushApproval {
+		// the user needs time to approve the login
+		timeout += configuration.ApprovalWait()
+	}
+	limits := configuration.Limits()
+	gw := *configuration
+	lock.Unlock()
 
-		if err != nil {
-			Log(logging.Error, "failed to handshake (%s)", err)
+	tcpConn.SetReadDeadline(time.Now().Add(timeout))
+	if pc, ok := tcpConn.(*proxyproto.Conn); ok {
+		if err := pc.ReadHeader(); err != nil {
+			Log(logging.Error, "proxy %s: %s", pc.ProxyAddr().String(), err)
 			tcpConn.Close()
-			continue
+			return
 		}
-
+		Log(logging.Debug, "connection from %s over proxy %s", pc.RemoteAddr().String(), pc.ProxyAddr().String())
+	}
+	ip := addressOf(tcpConn.RemoteAddr())
+	if err := gate.checkConn(ip, limits); err != nil {
+		Log(logging.Info, "rejected connection from %s: %s", tcpConn.RemoteAddr().String(), err)
+		tcpConn.Close()
+		return
+	}
+	if err := checkClientAccess(net.ParseIP(ip), nil, gw); err != nil {
+		Log(logging.Info, "rejected connection from %s: %s", tcpConn.RemoteAddr().String(), err)
+		tcpConn.Close()
+		return
+	}
+	// count the failure if the client tried to login, the hops of the
+	// other gateways do not count
+	var attempted, hop bool
+	cfg.AuthLogCallback = func(conn ssh.ConnMetadata, method string, err error) {
+		attempted = true
+	}
+	keyCallback := cfg.PublicKeyCallback
+	cfg.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if isClusterCert(key) {
+			hop = true
+		}
+		return keyCallback(conn, key)
+	}
+	Log(logging.Info, "new connection from %s", tcpConn.RemoteAddr().String())
+	sshConn, chans, reqs, err := ssh.NewServerConn(tcpConn, &cfg)
+	if err != nil {
+		Log(logging.Error, "failed to ssh connect (%s)", err)
+		tcpConn.Close()
+		if attempted && !hop {
+			gate.failed(bans.AddressKey(ip), "failed logins", limits)
+		}
+		return
+	}
+	gate.succeeded(bans.AddressKey(ip))
+	// the watchdog of the session takes care of idle connections
+	tcpConn.SetReadDeadline(time.Time{})
+	if _, err = NewSession(tcpConn, sshConn, chans, reqs); err != nil {
+		Log(logging.Error, "failed to handshake (%s)", err)
+		tcpConn.Close()
 	}
 }
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/clusterit/orca/config"
+)
+
+var errMenuCanceled = errors.New("the selection was canceled")
+
+const (
+	keyCtrlC     = 0x03
+	keyCtrlD     = 0x04
+	keyBackspace = 0x08
+	keyCtrlN     = 0x0e
+	keyCtrlP     = 0x10
+	keyCtrlU     = 0x15
+	keyEscape    = 0x1b
+	keyDelete    = 0x7f
+
+	defaultMenuHeight = 24
+)
+
+// A keyboard driven menu to select one of the given hosts. The user can
+// move with the arrow keys (or ctrl-p/ctrl-n), type to search and select
+// the host with enter.
+type menu struct {
+	hosts    []config.Host
+	matches  []config.Host
+	search   string
+	selected int
+	offset   int
+	height   func() int
+}
+
+func newMenu(hosts []config.Host, height func() int) *menu {
+	m := &menu{hosts: hosts, height: height}
+	m.filter()
+	return m
+}
+
+// filter the hosts with the current search term. the term must be part
+// of the name, the address or a tag of a host.
+func (m *menu) filter() {
+	s := strings.ToLower(m.search)
+	m.matches = nil
+	for _, h := range m.hosts {
+		if strings.Contains(strings.ToLower(h.Name), s) || strings.Contains(strings.ToLower(h.Address), s) ||
+			strings.Contains(strings.ToLower(strings.Join(h.Tags, ",")), s) {
+			m.matches = append(m.matches, h)
+		}
+	}
+	m.selected = 0
+	m.offset = 0
+}
+
+func (m *menu) move(delta int) {
+	m.selected += delta
+	if m.selected >= len(m.matches) {
+		m.selected = len(m.matches) - 1
+	}
+	if m.selected < 0 {
+		m.selected = 0
+	}
+}
+
+func (m *menu) rows() int {
+	h := defaultMenuHeight
+	if m.height != nil && m.height() > 0 {
+		h = m.height()
+	}
+	if h -= 4; h < 1 {
+		h = 1
+	}
+	return h
+}
+
+func (m *menu) render(w io.Writer) error {
+	var b bytes.Buffer
+	rows := m.rows()
+	if m.selected < m.offset {
+		m.offset = m.selected
+	}
+	if m.selected >= m.offset+rows {
+		m.offset = m.selected - rows + 1
+	}
+	b.WriteString("\x1b[H\x1b[2J")
+	b.WriteString("select a backend (arrows to move, type to search, enter to connect, ctrl-c to quit)\r\n")
+	fmt.Fprintf(&b, "search: %s\r\n\r\n", m.search)
+	if len(m.matches) == 0 {
+		b.WriteString("  no matching backends\r\n")
+	}
+	for i := m.offset; i < len(m.matches) && i < m.offset+rows; i++ {
+		h := m.matches[i]
+		if i == m.selected {
+			fmt.Fprintf(&b, "\x1b[7m> %-24s %-32s %s\x1b[0m\r\n", h.Name, h.Addr(), strings.Join(h.Tags, ","))
+		} else {
+			fmt.Fprintf(&b, "  %-24s %-32s %s\r\n", h.Name, h.Addr(), strings.Join(h.Tags, ","))
+		}
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// show the menu on the terminal and wait until the user selected a host.
+func (m *menu) run(rw io.ReadWriter) (*config.Host, error) {
+	defer rw.Write([]byte("\x1b[H\x1b[2J\x1b[?25h"))
+	if _, err := rw.Write([]byte("\x1b[?25l")); err != nil {
+		return nil, err
+	}
+	if err := m.render(rw); err != nil {
+		return nil, err
+	}
+	// 0: normal input, 1: after ESC, 2: inside of a CSI/SS3 sequence
+	state := 0
+	buf := make([]byte, 256)
+	for {
+		n, err := rw.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range buf[:n] {
+			switch state {
+			case 1:
+				if c == '[' || c == 'O' {
+					state = 2
+					continue
+				}
+				state = 0
+			case 2:
+				if c >= '0' && c <= '9' || c == ';' {
+					continue
+				}
+				state = 0
+				switch c {
+				case 'A':
+					m.move(-1)
+				case 'B':
+					m.move(1)
+				}
+				continue
+			}
+			switch {
+			case c == keyCtrlC || c == keyCtrlD:
+				return nil, errMenuCanceled
+			case c == '\r' || c == '\n':
+				if len(m.matches) > 0 {
+					h := m.matches[m.selected]
+					return &h, nil
+				}
+			case c == keyEscape:
+				state = 1
+			case c == keyCtrlP:
+				m.move(-1)
+			case c == keyCtrlN:
+				m.move(1)
+			case c == keyDelete || c == keyBackspace:
+				if len(m.search) > 0 {
+					m.search = m.search[:len(m.search)-1]
+					m.filter()
+				}
+			case c == keyCtrlU:
+				m.search = ""
+				m.filter()
+			case c >= 0x20 && c < 0x7f:
+				m.search += string(c)
+				m.filter()
+			}
+		}
+		if err := m.render(rw); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// the hosts of the inventory which the user may reach: the CIDR rules of
+// the gateway and the policies of the zone of the hosts must allow them.
+func (cs *clientSession) reachableHosts() ([]config.Host, error) {
+	hosts := cs.choices
+	if hosts == nil {
+		all, err := configer.Hosts(cs.hostZone())
+		if err != nil {
+			return nil, fmt.Errorf("cannot read hosts: %s", err)
+		}
+		hosts = all
+	}
+	policies, err := cs.hostPolicies()
+	if err != nil {
+		return nil, err
+	}
+	uid, roles := userOf(cs.serverConn.Permissions)
+	if err := cs.checkHopClientRules(roles); err != nil {
+		return nil, err
+	}
+	res := []config.Host{}
+	for _, h := range hosts {
+		if len(cs.hops) == 0 && checkBackendAccess(h.Address, *configuration) != nil {
+			continue
+		}
+		user, err := cs.remoteUserFor(h)
+		if err != nil {
+			continue
+		}
+		if _, err := checkPolicies(policies, uid, roles, h.Address, user); err != nil {
+			continue
+		}
+		res = append(res, h)
+	}
+	sort.Sort(hostsByName(res))
+	return res, nil
+}
+
+type hostsByName []config.Host
+
+func (h hostsByName) Len() int           { return len(h) }
+func (h hostsByName) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h hostsByName) Less(i, j int) bool { return h[i].Name < h[j].Name }
+
+// let the user select a backend in the menu and open a shell on it.
+func (sc *channelSession) menuShell() {
+	hosts, err := sc.reachableHosts()
+	if err != nil {
+		sc.errorf("%s", err)
+		fmt.Fprintf(sc.channel.Stderr(), "%s\r\n", err)
+		sc.channel.Close()
+		return
+	}
+	if len(hosts) == 0 {
+		fmt.Fprintf(sc.channel.Stderr(), "there is no backend you may connect to\r\n")
+		sc.channel.Close()
+		return
+	}
+	h, err := newMenu(hosts, func() int {
+		_, _, height := sc.terminal()
+		return height
+	}).run(sc.wrap(sc.channel))
+	if err != nil {
+		if err != errMenuCanceled {
+			sc.errorf("backend selection: %s", err)
+		}
+		sc.channel.Close()
+		return
+	}
+	sc.infof("selected backend %s (%s)", h.Name, h.Address)
+	if err := sc.useBackend(*h); err != nil {
+		sc.infof("access to %s denied: %s", h.Address, err)
+		fmt.Fprintf(sc.channel.Stderr(), "%s\r\n", err)
+		sc.channel.Close()
+		return
+	}
+	if _, g := sc.selection(); !g.allows(config.ChannelShell) {
+		sc.infof("shell denied by policy")
+		fmt.Fprintf(sc.channel.Stderr(), "shell is not allowed\r\n")
+		sc.channel.Close()
+		return
+	}
+	fmt.Fprintf(sc.channel, "connecting to %s ...\r\n", h.Name)
+	sc.connectRemote(nil)
+}
+
+// write the reachable backends as JSON for clients without a terminal.
+func (sc *channelSession) listHosts() {
+	defer sc.channel.Close()
+	hosts, err := sc.reachableHosts()
+	if err != nil {
+		sc.errorf("%s", err)
+		fmt.Fprintf(sc.channel.Stderr(), "%s\n", err)
+		sendExitStatus(sc.channel, 1)
+		return
+	}
+	json.NewEncoder(sc.channel).Encode(hosts)
+	sc.channel.CloseWrite()
+	sendExitStatus(sc.channel, 0)
+}
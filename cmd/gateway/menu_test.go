@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/clusterit/orca/config"
+)
+
+type fakeTerminal struct {
+	in  *strings.Reader
+	out bytes.Buffer
+}
+
+func (t *fakeTerminal) Read(b []byte) (int, error)  { return t.in.Read(b) }
+func (t *fakeTerminal) Write(b []byte) (int, error) { return t.out.Write(b) }
+
+var menuHosts = []config.Host{
+	{Name: "db1", Address: "10.0.0.1"},
+	{Name: "db2", Address: "10.0.0.2"},
+	{Name: "web", Address: "web.intranet"},
+}
+
+func selectHost(input string) (*config.Host, error) {
+	return newMenu(menuHosts, nil).run(&fakeTerminal{in: strings.NewReader(input)})
+}
+
+func TestMenuSelect(t *testing.T) {
+	tests := map[string]string{
+		"\r":                   "db1",
+		"\x1b[B\r":             "db2",
+		"\x1b[B\x1b[B\x1b[B\r": "web",
+		"\x1b[B\x1b[A\r":       "db1",
+		"\x0e\x0e\x10\r":       "db2",
+		"we\r":                 "web",
+		"0.2\r":                "db2",
+		"x\x7f\x7fdb2\r":       "db2",
+		"web\x15db\x1bOB\r":    "db2",
+	}
+	for in, name := range tests {
+		h, err := selectHost(in)
+		if err != nil {
+			t.Errorf("%q: %s", in, err)
+			continue
+		}
+		if h.Name != name {
+			t.Errorf("%q should select %s, not %s", in, name, h.Name)
+		}
+	}
+}
+
+func TestMenuCancel(t *testing.T) {
+	if _, err := selectHost("db\x03"); err != errMenuCanceled {
+		t.Errorf("ctrl-c should cancel the menu: %v", err)
+	}
+	if _, err := selectHost("nothing\r"); err == nil {
+		t.Errorf("enter without a matching host should not select anything")
+	}
+}
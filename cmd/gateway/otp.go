@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/clusterit/orca/bans"
+	"github.com/clusterit/orca/logging"
+	"github.com/clusterit/orca/users"
+	"golang.org/x/crypto/ssh"
+)
+
+// the codes a user may enter in one connection
+const maxCodeTries = 3
+
+func userPermissions(usr *users.User) *ssh.Permissions {
+	return &ssh.Permissions{Extensions: map[string]string{
+		"user_id": usr.Id,
+		"roles":   usr.Roles.String()}}
+}
+
+// The permissions of a user who has passed the second factor, the
+// autologin window of ttl seconds starts now and the session ends with it
+// like the sessions of the autologins.
+func secondFactorPermissions(conn ssh.ConnMetadata, usr *users.User, ttl int) *ssh.Permissions {
+	Log(logging.Info, "remote: %s: login by %+v", conn.RemoteAddr().String(), usr)
+	perms := userPermissions(usr)
+	if ttl > 0 {
+		perms.Extensions["allowance_until"] = time.Now().Add(time.Duration(ttl) * time.Second).Format(time.RFC3339)
+		perms.Extensions[permPermitUntil] = perms.Extensions["allowance_until"]
+	}
+	return perms
+}
+
+// The keyboard-interactive step after the key of a user with 2FA was
+// accepted. If the zone uses push approvals the login waits until the
+// user approves it. Otherwise, or if the approval does not come in time,
+// the user is asked for the verification code until it is right or there
+// were too many tries.
+func verificationCode(usr *users.User) func(ssh.ConnMetadata, ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	return func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		lock.Lock()
+		maxAutologin := configuration.MaxAutologin2FA
+		push, wait := configuration.PushApproval, configuration.ApprovalWait()
+		limits := configuration.Limits()
+		lock.Unlock()
+		ttl := usr.AutologinAfter2FA
+		if ttl > maxAutologin {
+			ttl = maxAutologin
+		}
+		instruction := ""
+		if push && approvalStore != nil {
+			approved, err := waitForApproval(conn, client, usr, ttl, wait)
+			if err != nil {
+				return nil, err
+			}
+			if approved {
+				return secondFactorPermissions(conn, usr, ttl), nil
+			}
+			instruction = "The login was not approved, please enter the code of your authenticator."
+		}
+		// the wrong codes count for the user, not for the address or the
+		// login name the client sent. The user waits after a wrong code
+		// like after a failed login, and the wrong codes of other
+		// connections may ban the user while this one asks for a code.
+		strikeKey := bans.LoginKey(usr.Id)
+		for i := 0; i < maxCodeTries; i++ {
+			gate.wait(strikeKey)
+			if err := gate.check(strikeKey); err != nil {
+				return nil, err
+			}
+			answers, err := client(usr.Name, instruction, []string{"Verification code: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 1 || answers[0] == "" {
+				instruction = "Please enter the code of your authenticator."
+				continue
+			}
+			Log(logging.Info, "check verification code for '%s', TTL: %d", usr.Id, ttl)
+			if err := fetcher.CheckToken(usr.Id, answers[0], ttl); err != nil {
+				Log(logging.Debug, "remote: %s: wrong code for user '%s': '%s'", conn.RemoteAddr().String(), usr.Id, err)
+				gate.failed(strikeKey, "wrong verification codes", limits)
+				instruction = "Wrong code, please try again."
+				continue
+			}
+			gate.succeeded(strikeKey)
+			return secondFactorPermissions(conn, usr, ttl), nil
+		}
+		return nil, fmt.Errorf("too many wrong verification codes")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/clusterit/orca/bans"
+	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/users"
+	"golang.org/x/crypto/ssh"
+)
+
+type testFetcher struct {
+	usr  *users.User
+	code string
+}
+
+func (f *testFetcher) UserByKey(key string) (*users.User, error) {
+	return f.usr, nil
+}
+
+func (f *testFetcher) CheckToken(uid, token string, maxtime int) error {
+	if token != f.code {
+		return fmt.Errorf("wrong token")
+	}
+	return nil
+}
+
+// login at a gateway with a key and the given codes, returns the
+// permissions of the server and the instructions the client got.
+func login2FA(t *testing.T, codes ...string) (*ssh.Permissions, []string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	sconf := &ssh.ServerConfig{PublicKeyCallback: keyAuth}
+	sconf.AddHostKey(newTestSigner(t))
+	perms := make(chan *ssh.Permissions, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		sc, _, _, err := ssh.NewServerConn(c, sconf)
+		if err != nil {
+			perms <- nil
+			return
+		}
+		perms <- sc.Permissions
+	}()
+	var instructions []string
+	answer := func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		instructions = append(instructions, instruction)
+		if len(questions) == 0 {
+			// an approval only shows the instruction
+			return nil, nil
+		}
+		if len(questions) != 1 || questions[0] != "Verification code: " || echos[0] {
+			return nil, fmt.Errorf("unexpected questions %v", questions)
+		}
+		code := codes[0]
+		codes = codes[1:]
+		return []string{code}, nil
+	}
+	cc := &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(newTestSigner(t)), ssh.KeyboardInteractive(answer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	client, err := ssh.Dial("tcp", l.Addr().String(), cc)
+	if err == nil {
+		client.Close()
+	}
+	return <-perms, instructions, err
+}
+
+// a guard whose clock only moves when the gateway waits
+func waitingGuard() *guard {
+	g := newGuard(nil)
+	now := time.Now()
+	g.now = func() time.Time { return now }
+	g.sleep = func(d time.Duration) { now = now.Add(d) }
+	return g
+}
+
+func TestVerificationCode(t *testing.T) {
+	oldFetcher, oldConfig, oldGate := fetcher, configuration, gate
+	defer func() { fetcher, configuration, gate = oldFetcher, oldConfig, oldGate }()
+	fetcher = &testFetcher{usr: &users.User{Id: "alice@network", Name: "Alice", Use2FA: true}, code: "424242"}
+	configuration = &config.Gateway{MaxAutologin2FA: 60, MaxAuthFailures: 3}
+	gate = waitingGuard()
+
+	perms, instructions, err := login2FA(t, "111111", "424242")
+	if err != nil {
+		t.Fatalf("the login with the right code should succeed: %s", err)
+	}
+	if perms == nil || perms.Extensions["user_id"] != "alice@network" {
+		t.Errorf("the user should be logged in: %+v", perms)
+	}
+	if len(instructions) != 2 || instructions[1] != "Wrong code, please try again." {
+		t.Errorf("the user should be asked again after a wrong code: %q", instructions)
+	}
+
+	if err := gate.check(bans.LoginKey("alice@network")); err != nil {
+		t.Errorf("the right code should reset the wrong ones: %s", err)
+	}
+
+	if _, _, err := login2FA(t, "1", "2", "3", "4", "5", "6"); err == nil {
+		t.Errorf("the login with wrong codes should fail")
+	}
+	if b := gate.ban(bans.LoginKey("alice@network")); b == nil {
+		t.Errorf("the user should be banned after too many wrong codes")
+	}
+	if _, _, err := login2FA(t, "424242"); err == nil {
+		t.Errorf("a banned user should not login")
+	}
+	if err := gate.check(bans.LoginKey("alice")); err != nil {
+		t.Errorf("the login name of the client should not be banned: %s", err)
+	}
+
+	// the ban counts from the next code on, also in the same connection
+	configuration = &config.Gateway{MaxAutologin2FA: 60, MaxAuthFailures: 2}
+	gate = waitingGuard()
+	_, instructions, err = login2FA(t, "1", "2", "424242")
+	if err == nil {
+		t.Errorf("the right code should not be accepted after the ban")
+	}
+	if len(instructions) != 2 {
+		t.Errorf("a banned user should not be asked for another code: %q", instructions)
+	}
+}
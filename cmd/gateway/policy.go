@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/clusterit/orca/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// The access rights of a logged in user on the current backend.
+type grants struct {
+	restricted bool
+	policies   []config.Policy
+}
+
+// Check the policies of the zone for the user with the given id and
+// roles. If there are no policies at all, everything is allowed. Otherwise
+// the matching policies are returned; if there is no matching policy
+// the access is denied.
+func checkPolicies(policies []config.Policy, uid string, roles []string, host, remoteUser string) (*grants, error) {
+	if len(policies) == 0 {
+		return &grants{}, nil
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ips, _ = net.LookupIP(host)
+	}
+	g := &grants{restricted: true}
+	for _, p := range policies {
+		if !appliesTo(p, uid, roles) {
+			continue
+		}
+		if !matchesHost(p.Hosts, host, ips) {
+			continue
+		}
+		if len(p.RemoteUsers) > 0 && !matchesPattern(p.RemoteUsers, remoteUser) {
+			continue
+		}
+		g.policies = append(g.policies, p)
+	}
+	if len(g.policies) == 0 {
+		return nil, fmt.Errorf("no policy allows %s to login as %s@%s", uid, remoteUser, host)
+	}
+	return g, nil
+}
+
+// Check if at least one of the granted policies allows the channel type.
+func (g *grants) allows(channel string) bool {
+	if g == nil {
+		return false
+	}
+	if !g.restricted {
+		return true
+	}
+	for _, p := range g.policies {
+		if policyAllows(p, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// the tunnel destinations of the granted policies which allow direct-tcpip.
+func (g *grants) tunnels() []string {
+	if g == nil {
+		return nil
+	}
+	var res []string
+	for _, p := range g.policies {
+		if policyAllows(p, config.ChannelDirectTcpip) {
+			res = append(res, p.Tunnels...)
+		}
+	}
+	return res
+}
+
+func policyAllows(p config.Policy, channel string) bool {
+	if len(p.Channels) == 0 {
+		return true
+	}
+	for _, c := range p.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+func appliesTo(p config.Policy, uid string, roles []string) bool {
+	if len(p.Users) == 0 && len(p.Roles) == 0 {
+		return true
+	}
+	for _, u := range p.Users {
+		if u == uid || u == "*" {
+			return true
+		}
+	}
+	for _, r := range p.Roles {
+		for _, ur := range roles {
+			if r == ur {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesHost(hosts []string, host string, ips []net.IP) bool {
+	for _, h := range hosts {
+		if strings.Contains(h, "/") {
+			_, netw, err := net.ParseCIDR(h)
+			if err != nil {
+				logger.Warnf("the policy CIDR %s cannot be parsed, ignoring", h)
+				continue
+			}
+			if checkNetContains([]*net.IPNet{netw}, ips) {
+				return true
+			}
+		} else if matchesPattern([]string{h}, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesPattern(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// the user id and the roles which were stored by the auth callbacks
+func userOf(perms *ssh.Permissions) (string, []string) {
+	if perms == nil {
+		return "", nil
+	}
+	var roles []string
+	if r := perms.Extensions["roles"]; r != "" {
+		roles = strings.Split(r, ",")
+	}
+	return perms.Extensions["user_id"], roles
+}
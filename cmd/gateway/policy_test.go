@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/clusterit/orca/config"
+)
+
+func TestPoliciesEmpty(t *testing.T) {
+	g, err := checkPolicies(nil, "uid", nil, "10.0.0.1", "root")
+	if err != nil {
+		t.Fatalf("no policies should allow everything: %s", err)
+	}
+	if !g.allows(config.ChannelShell) || !g.allows(config.ChannelX11) {
+		t.Errorf("no policies should allow every channel")
+	}
+}
+
+func TestPoliciesUsersAndRoles(t *testing.T) {
+	policies := []config.Policy{
+		{
+			Id:          "dba",
+			Roles:       []string{"DBA"},
+			Hosts:       []string{"10.1.0.0/16"},
+			RemoteUsers: []string{"postgres"},
+			Channels:    []string{config.ChannelShell, config.ChannelExec},
+		},
+		{
+			Id:       "admin",
+			Users:    []string{"admin"},
+			Hosts:    []string{"*"},
+			Channels: nil,
+		},
+	}
+	g, err := checkPolicies(policies, "user", []string{"USER", "DBA"}, "10.1.2.3", "postgres")
+	if err != nil {
+		t.Fatalf("dba should reach the database: %s", err)
+	}
+	if !g.allows(config.ChannelShell) {
+		t.Errorf("dba should have a shell")
+	}
+	if g.allows(config.ChannelDirectTcpip) {
+		t.Errorf("dba should not tunnel")
+	}
+	if _, err := checkPolicies(policies, "user", []string{"DBA"}, "10.1.2.3", "root"); err == nil {
+		t.Errorf("dba should not login as root")
+	}
+	if _, err := checkPolicies(policies, "user", []string{"DBA"}, "10.2.0.1", "postgres"); err == nil {
+		t.Errorf("dba should not reach other networks")
+	}
+	if _, err := checkPolicies(policies, "other", []string{"USER"}, "10.1.2.3", "postgres"); err == nil {
+		t.Errorf("users without a policy must be denied")
+	}
+	g, err = checkPolicies(policies, "admin", nil, "10.2.0.1", "root")
+	if err != nil {
+		t.Fatalf("admin should reach everything: %s", err)
+	}
+	if !g.allows(config.ChannelX11) {
+		t.Errorf("admin should use every channel")
+	}
+}
+
+func TestPoliciesHostPatterns(t *testing.T) {
+	policies := []config.Policy{
+		{Hosts: []string{"db*.intranet"}},
+	}
+	if _, err := checkPolicies(policies, "u", nil, "db1.intranet", "root"); err != nil {
+		t.Errorf("db1.intranet should match: %s", err)
+	}
+	if _, err := checkPolicies(policies, "u", nil, "web1.intranet", "root"); err == nil {
+		t.Errorf("web1.intranet should not match")
+	}
+	var nog *grants
+	if nog.allows(config.ChannelShell) {
+		t.Errorf("missing grants should not allow anything")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/clusterit/orca/recording"
+	"golang.org/x/crypto/ssh"
+)
+
+// remember the terminal settings of the client so they can be put into
+// the header of a recording.
+func (sc *channelSession) trackTerminal(req *ssh.Request) {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	switch req.Type {
+	case "pty-req":
+		term, err := parseStrings(req.Payload, 1)
+		if err != nil {
+			return
+		}
+		dims := req.Payload[4+len(term[0]):]
+		if len(dims) < 8 {
+			return
+		}
+		sc.term = term[0]
+		sc.width = int(binary.BigEndian.Uint32(dims))
+		sc.height = int(binary.BigEndian.Uint32(dims[4:]))
+	case "window-change":
+		if len(req.Payload) < 8 {
+			return
+		}
+		sc.width = int(binary.BigEndian.Uint32(req.Payload))
+		sc.height = int(binary.BigEndian.Uint32(req.Payload[4:]))
+		if sc.recorder != nil {
+			sc.recorder.Resize(sc.width, sc.height)
+		}
+	}
+}
+
+// the terminal type and size of the client
+func (sc *channelSession) terminal() (string, int, int) {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	return sc.term, sc.width, sc.height
+}
+
+// start a new recording for this session if the zone wants recordings.
+func (sc *channelSession) startRecording(cmd *string) {
+	if !configuration.Recording || recordings == nil {
+		return
+	}
+	w, err := recordings.Create(sc.sessionId)
+	if err != nil {
+		sc.errorf("cannot create recording: %s", err)
+		return
+	}
+	term, width, height := sc.terminal()
+	h := recording.Header{
+		Width:  width,
+		Height: height,
+		Title:  sc.serverConn.User(),
+		Env: map[string]string{
+			"TERM":   term,
+			"REMOTE": sc.serverConn.RemoteAddr().String(),
+		},
+	}
+	if cmd != nil {
+		h.Command = *cmd
+	}
+	rec, err := recording.New(w, h)
+	if err != nil {
+		sc.errorf("cannot write recording header: %s", err)
+		w.Close()
+		return
+	}
+	sc.mux.Lock()
+	sc.recorder = rec
+	sc.mux.Unlock()
+	sc.infof("recording session")
+}
+
+func (sc *channelSession) currentRecorder() *recording.Recorder {
+	sc.mux.Lock()
+	defer sc.mux.Unlock()
+	return sc.recorder
+}
+
+// wrap the streams of a session so they are recorded.
+func (sc *channelSession) recorded(stdout, stderr io.Writer, stdin io.Reader) (io.Writer, io.Writer, io.Reader) {
+	rec := sc.currentRecorder()
+	if rec == nil {
+		return stdout, stderr, stdin
+	}
+	stdout = io.MultiWriter(stdout, rec.Writer(recording.Output))
+	stderr = io.MultiWriter(stderr, rec.Writer(recording.Output))
+	if configuration.RecordInput {
+		stdin = io.TeeReader(stdin, rec.Writer(recording.Input))
+	}
+	return stdout, stderr, stdin
+}
+
+func (sc *channelSession) stopRecording() {
+	if rec := sc.currentRecorder(); rec != nil {
+		rec.Close()
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/clusterit/orca/logging"
+	"github.com/clusterit/orca/sessions"
+)
+
+// the sessions are published again after this time
+const publishInterval = 20 * time.Second
+
+var (
+	registry    sessions.Registry
+	gatewayName string
+	activeMux   sync.Mutex
+	active      = make(map[string]*clientSession)
+)
+
+func initRegistry(reg sessions.Registry) {
+	registry = reg
+	gatewayName, _ = os.Hostname()
+	go watchKills()
+}
+
+// close the connections of the sessions which are killed by a manager.
+func watchKills() {
+	kills, _, err := registry.Kills()
+	if err != nil {
+		Log(logging.Error, "cannot watch the killed sessions: %s", err)
+		return
+	}
+	for id := range kills {
+		activeMux.Lock()
+		cs := active[id]
+		activeMux.Unlock()
+		if cs == nil {
+			continue
+		}
+		cs.infof("session killed by a manager")
+		cs.notify("the session was killed by a manager")
+		cs.serverConn.Close()
+	}
+}
+
+// the published form of the session
+func (cs *clientSession) info() sessions.Session {
+	s := sessions.Session{
+		Id:       cs.sessionId,
+		User:     cs.userId,
+		Zone:     zone,
+		Gateway:  gatewayName,
+		Source:   cs.serverConn.RemoteAddr().String(),
+		Start:    cs.start,
+		BytesIn:  atomic.LoadInt64(&cs.bytesIn),
+		BytesOut: atomic.LoadInt64(&cs.bytesOut),
+	}
+	cs.backendMux.Lock()
+	if cs.remoteHost != "" {
+		s.Target = cs.remoteUser + "@" + cs.backendAddr()
+		if len(cs.hops) > 0 {
+			s.Target += " over " + strings.Join(cs.hops, ">")
+		}
+	}
+	cs.backendMux.Unlock()
+	cs.channelMux.Lock()
+	defer cs.channelMux.Unlock()
+	for tp, n := range cs.channelCounts {
+		if n > 0 {
+			if s.Channels == nil {
+				s.Channels = make(map[string]int)
+			}
+			s.Channels[tp] = n
+		}
+	}
+	return s
+}
+
+// Publish the session in the registry until the connection is closed.
+// The active sessions of this gateway can be killed and shadowed.
+func (cs *clientSession) publish() {
+	activeMux.Lock()
+	active[cs.sessionId] = cs
+	activeMux.Unlock()
+	go func() {
+		<-cs.closed
+		activeMux.Lock()
+		delete(active, cs.sessionId)
+		activeMux.Unlock()
+	}()
+	if registry == nil {
+		return
+	}
+	go func() {
+		t := time.NewTicker(publishInterval)
+		defer t.Stop()
+		for {
+			if err := registry.Put(cs.info()); err != nil {
+				cs.warnf("cannot publish session: %s", err)
+			}
+			select {
+			case <-cs.closed:
+				if err := registry.Remove(cs.sessionId); err != nil {
+					cs.warnf("cannot remove session from registry: %s", err)
+				}
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// count the open channels of the client per type
+func (cs *clientSession) countChannel(tp string, d int) {
+	cs.channelMux.Lock()
+	defer cs.channelMux.Unlock()
+	if cs.channelCounts == nil {
+		cs.channelCounts = make(map[string]int)
+	}
+	cs.channelCounts[tp] += d
+}
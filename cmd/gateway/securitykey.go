@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/users"
+	"golang.org/x/crypto/ssh"
+)
+
+// Check that a user whose roles require a security key logs in with one.
+// The ssh library verifies the signature of sk-ssh-ed25519 and
+// sk-ecdsa-sha2-nistp256 keys but does not pass the flags of the
+// authenticator on, so they are not checked. The options a manager has set
+// for the key stand in for them: presence refuses keys with
+// no-touch-required, verification needs verify-required. The registered
+// key is nil if the user has no matching key record.
+func checkSecurityKey(key ssh.PublicKey, registered *users.Key, roles []string, cfg config.Gateway) error {
+	flags := cfg.SecurityKeyFor(roles)
+	if flags == "" {
+		return nil
+	}
+	if !users.IsSecurityKey(key) {
+		return fmt.Errorf("a security key with user %s is required, %s is not one", flags, key.Type())
+	}
+	if registered == nil {
+		return fmt.Errorf("the security key is not registered")
+	}
+	if registered.HasOption(users.KeyOptNoTouchRequired) {
+		return fmt.Errorf("a security key with user %s is required, the key is registered with %s", flags, users.KeyOptNoTouchRequired)
+	}
+	if flags == config.KeyVerification && !registered.HasOption(users.KeyOptVerifyRequired) {
+		return fmt.Errorf("a security key with user verification is required, a manager has to mark the key with %s", users.KeyOptVerifyRequired)
+	}
+	return nil
+}
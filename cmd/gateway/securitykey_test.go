@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/users"
+	"golang.org/x/crypto/ssh"
+)
+
+const testSecurityKey = "sk-ssh-ed25519@openssh.com AAAAGnNrLXNzaC1lZDI1NTE5QG9wZW5zc2guY29tAAAAIL5KDpQUma+3ahn5i06mU+U6bsnEfvf3GAkxQ+ImgjStAAAABHNzaDo="
+
+// the key and its record with the options a manager has set
+func registeredKey(t *testing.T, line string, opts ...string) (ssh.PublicKey, *users.Key) {
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := users.ParseKey(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Options = opts
+	return pk, k
+}
+
+func TestCheckSecurityKey(t *testing.T) {
+	sk, registered := registeredKey(t, testSecurityKey)
+	plain := newTestSigner(t).PublicKey()
+	cfg := config.Gateway{RoleSecurityKeys: map[string]string{"ops": config.KeyPresence, "dba": config.KeyVerification}}
+	if err := checkSecurityKey(plain, nil, []string{"USER"}, cfg); err != nil {
+		t.Errorf("users without the role can login with any key: %s", err)
+	}
+	if err := checkSecurityKey(plain, nil, []string{"USER", "ops"}, cfg); err == nil {
+		t.Errorf("the role requires a security key")
+	}
+	if err := checkSecurityKey(sk, registered, []string{"USER", "ops"}, cfg); err != nil {
+		t.Errorf("the security key should be accepted: %s", err)
+	}
+	if err := checkSecurityKey(sk, nil, []string{"ops"}, cfg); err == nil {
+		t.Errorf("a security key without a registration should be refused")
+	}
+}
+
+func TestSecurityKeyOptions(t *testing.T) {
+	cfg := config.Gateway{RoleSecurityKeys: map[string]string{"ops": config.KeyPresence, "dba": config.KeyVerification}}
+	sk, plain := registeredKey(t, testSecurityKey)
+	_, notouch := registeredKey(t, testSecurityKey, users.KeyOptNoTouchRequired)
+	_, verified := registeredKey(t, testSecurityKey, users.KeyOptVerifyRequired)
+	_, both := registeredKey(t, testSecurityKey, users.KeyOptNoTouchRequired, users.KeyOptVerifyRequired)
+	_, claimed := registeredKey(t, "verify-required "+testSecurityKey)
+
+	if err := checkSecurityKey(sk, notouch, []string{"ops"}, cfg); err == nil {
+		t.Errorf("presence should refuse a key without touch")
+	}
+	if err := checkSecurityKey(sk, plain, []string{"dba"}, cfg); err == nil {
+		t.Errorf("verification should refuse a key without verify-required")
+	}
+	if err := checkSecurityKey(sk, claimed, []string{"dba"}, cfg); err == nil {
+		t.Errorf("the options of the registered key line should not count")
+	}
+	if err := checkSecurityKey(sk, both, []string{"dba"}, cfg); err == nil {
+		t.Errorf("verification should refuse a key without touch")
+	}
+	for _, roles := range [][]string{{"ops"}, {"dba"}, {"ops", "dba"}} {
+		if err := checkSecurityKey(sk, verified, roles, cfg); err != nil {
+			t.Errorf("%v should accept a verified key: %s", roles, err)
+		}
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/clusterit/orca/audit"
 	"github.com/clusterit/orca/config"
 	"github.com/clusterit/orca/logging"
 
@@ -16,40 +19,65 @@ import (
 	"golang.org/x/crypto/ssh/agent"
 )
 
+// timeoutConn marks the connection of the client as active when data is
+// transferred, so the watchdog does not close it.
 type timeoutConn struct {
 	io.ReadWriter
-	conn        net.Conn
-	timeoutSecs int
+	cs *clientSession
 }
 
 func (t *timeoutConn) Read(b []byte) (n int, err error) {
-	t.conn.SetDeadline(time.Now().Add(time.Duration(t.timeoutSecs) * time.Second))
-	return t.ReadWriter.Read(b)
+	n, err = t.ReadWriter.Read(b)
+	if n > 0 {
+		t.cs.touch()
+		atomic.AddInt64(&t.cs.bytesIn, int64(n))
+	}
+	return n, err
 }
 
 func (t *timeoutConn) Write(b []byte) (n int, err error) {
-	t.conn.SetDeadline(time.Now().Add(time.Duration(t.timeoutSecs) * time.Second))
-	return t.ReadWriter.Write(b)
+	t.cs.touch()
+	n, err = t.ReadWriter.Write(b)
+	atomic.AddInt64(&t.cs.bytesOut, int64(n))
+	return n, err
 }
 
 type clientSession struct {
+	// the time of the last traffic in nanoseconds and the transferred
+	// bytes, accessed atomically
+	active            int64
+	bytesIn           int64
+	bytesOut          int64
+	start             time.Time
 	tcpConnection     net.Conn
-	timeout           int
 	serverConn        *ssh.ServerConn
 	agent             agent.Agent
+	loginUser         string
 	remoteUser        string
 	remoteHost        string
 	remotePort        int
 	backend           *backendClient
-	bufferedRqs       []*ssh.Request
 	globalBufferedRqs []*ssh.Request
 	logger            *logging.Logger
+	sessionId         string
+	grants            *grants
+	userId            string
+	choices           []config.Host
+	hops              []string
+	remoteForwards    map[string]uint32
+	forwardMux        sync.Mutex
+	backendMux        sync.Mutex
+	channels          map[*channelSession]bool
+	channelCounts     map[string]int
+	channelMux        sync.Mutex
+	viewers           map[*viewer]bool
+	shadowMux         sync.Mutex
+	closed            chan struct{}
 }
 
 type backendClient struct {
 	orcasession *clientSession
 	client      *ssh.Client
-	session     *ssh.Session
 	newchans    <-chan ssh.NewChannel
 	requests    <-chan *ssh.Request
 	mux         sync.Mutex
@@ -110,53 +138,84 @@ func (c *backendClient) forwardAgent(ag agent.Agent) error {
 	return agent.ForwardToAgent(c.client, ag)
 }
 
-func (c *backendClient) newSession() error {
-	session, err := c.client.NewSession()
+func (c *backendClient) newSession() (*backendSession, error) {
+	ch, rqs, err := c.client.OpenChannel("session", nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	c.session = session
-	return nil
+	return &backendSession{Channel: ch, requests: rqs}, nil
 }
 
 func (c *backendClient) close() error {
-	if c.session != nil {
-		c.session.Close()
-	}
 	return c.client.Close()
 }
 
-func NewSession(tcpconn net.Conn, timeout int, sshConn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) (*clientSession, error) {
+func NewSession(tcpconn net.Conn, sshConn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) (*clientSession, error) {
+	if isHop(sshConn.Permissions) {
+		return newHopSession(tcpconn, sshConn, chans, reqs)
+	}
+	if sid, ok := shadowOf(sshConn.User()); ok {
+		return newShadowSession(tcpconn, sshConn, chans, reqs, sid)
+	}
 	var cs clientSession
 	var err error
 	cs.tcpConnection = tcpconn
-	cs.timeout = timeout
 	cs.serverConn = sshConn
-	cs.remotePort = 22
-	cs.remoteUser, cs.remoteHost, err = split(sshConn.User())
+	cs.start = time.Now()
+	cs.closed = make(chan struct{})
+	var target string
+	cs.loginUser, target, err = split(sshConn.User())
 	if err != nil {
 		sshConn.Close()
 		return nil, err
 	}
-	err = checkBackendAccess(cs.remoteHost, *configuration)
+	target, cs.hops, err = splitHops(target)
 	if err != nil {
 		sshConn.Close()
 		return nil, err
 	}
+	hosts, err := resolveTarget(configer, cs.hostZone(), target)
+	if err != nil {
+		sshConn.Close()
+		return nil, err
+	}
+	cs.userId, _ = userOf(sshConn.Permissions)
+	if len(hosts) == 1 {
+		if err = cs.useBackend(hosts[0]); err != nil {
+			sshConn.Close()
+			return nil, err
+		}
+	} else {
+		// without a single target host the user selects one in the menu
+		cs.choices = hosts
+	}
 	remote := sshConn.RemoteAddr().String()
 	sid := fmt.Sprintf("%x", sshConn.SessionID())
+	cs.sessionId = sid
 	cs.logger = logging.New(sid, remote)
+	if len(cs.hops) > 0 {
+		cs.logger.SetChain(cs.chain() + ">" + strings.Join(cs.hops, ">"))
+	}
 	cs.infof("new ssh connection with %s ", sshConn.ClientVersion())
 
 	go func() {
 		for rq := range reqs {
-			cs.forward(rq, true)
+			if rq.Type == hostKeysProve {
+				cs.proveHostKeys(rq)
+				continue
+			}
+			cs.forwardGlobal(rq)
 		}
 	}()
 	//go ssh.DiscardRequests(reqs)
 	go cs.handleChannels(sshConn, chans)
+	cs.watch()
+	cs.track()
+	cs.publish()
+	cs.announceHostKeys()
 	go func() {
 		sshConn.Wait()
+		close(cs.closed)
 		if cs.backend != nil {
 			// backend can be nil if a connection could not be established
 			// because there is no agent on client
@@ -166,20 +225,72 @@ func NewSession(tcpconn net.Conn, timeout int, sshConn *ssh.ServerConn, chans <-
 	return &cs, nil
 }
 
-func (c *clientSession) wrap(wrc io.ReadWriter) *timeoutConn {
-	return &timeoutConn{ReadWriter: wrc, conn: c.tcpConnection, timeoutSecs: c.timeout}
+// check if the user may reach the given backend and remember the grants
+// of the policies.
+func (cs *clientSession) useBackend(h config.Host) error {
+	user, err := cs.remoteUserFor(h)
+	if err != nil {
+		return err
+	}
+	// the gateways of the hop zones check their own CIDR rules
+	if len(cs.hops) == 0 {
+		if err := checkBackendAccess(h.Address, *configuration); err != nil {
+			return err
+		}
+	}
+	uid, roles := userOf(cs.serverConn.Permissions)
+	if err := cs.checkHopClientRules(roles); err != nil {
+		return err
+	}
+	policies, err := cs.hostPolicies()
+	if err != nil {
+		return err
+	}
+	g, err := checkPolicies(policies, uid, roles, h.Address, user)
+	if err != nil {
+		return err
+	}
+	port := h.Port
+	if port == 0 {
+		port = config.DefaultSSHPort
+	}
+	cs.backendMux.Lock()
+	defer cs.backendMux.Unlock()
+	// all channels of a connection share one backend connection, so the
+	// first selection is kept
+	if cs.remoteHost != "" {
+		if cs.remoteHost != h.Address || cs.remotePort != port || cs.remoteUser != user {
+			return fmt.Errorf("this connection already uses %s@%s", cs.remoteUser, cs.backendAddr())
+		}
+		return nil
+	}
+	cs.remoteUser = user
+	cs.remoteHost = h.Address
+	cs.remotePort = port
+	cs.grants = g
+	return nil
 }
 
-func (c *clientSession) incRT() {
-	c.tcpConnection.SetReadDeadline(time.Now().Add(time.Duration(c.timeout) * time.Second))
+// the selected backend host and the grants of its policies, the host is
+// empty as long as no backend is selected.
+func (cs *clientSession) selection() (string, *grants) {
+	cs.backendMux.Lock()
+	defer cs.backendMux.Unlock()
+	return cs.remoteHost, cs.grants
 }
 
-func (c *clientSession) incWT() {
-	c.tcpConnection.SetWriteDeadline(time.Now().Add(time.Duration(c.timeout) * time.Second))
+// the address of the selected backend.
+func (cs *clientSession) backendAddr() string {
+	return net.JoinHostPort(cs.remoteHost, strconv.Itoa(cs.remotePort))
 }
+
+func (c *clientSession) wrap(wrc io.ReadWriter) *timeoutConn {
+	return &timeoutConn{ReadWriter: wrc, cs: c}
+}
+
 func (cs *clientSession) handleChannels(con ssh.Conn, chans <-chan ssh.NewChannel) {
 	for newChannel := range chans {
-		cs.incRT()
+		cs.touch()
 		if newChannel.ChannelType() == "session" {
 			channel, requests, err := newChannel.Accept()
 			if err != nil {
@@ -189,14 +300,34 @@ func (cs *clientSession) handleChannels(con ssh.Conn, chans <-chan ssh.NewChanne
 			}
 			go cs.handleChannel(con, channel, requests)
 		} else if newChannel.ChannelType() == "direct-tcpip" {
-			channel, requests, err := newChannel.Accept()
-			if err != nil {
-				cs.errorf("accepting new direct-tcpip channel: %s", err)
-				con.Close()
+			host, g := cs.selection()
+			if host == "" {
+				newChannel.Reject(ssh.ConnectionFailed, "no backend selected")
+				continue
+			}
+			var dt directTcpip
+			if err := ssh.Unmarshal(newChannel.ExtraData(), &dt); err != nil {
+				cs.errorf("illegal direct-tcpip request: %s", err)
+				newChannel.Reject(ssh.ConnectionFailed, "illegal direct-tcpip request")
+				continue
+			}
+			target := net.JoinHostPort(dt.Host, strconv.Itoa(int(dt.Port)))
+			if !g.allows(config.ChannelDirectTcpip) {
+				cs.audit(audit.Event{Type: audit.Tunnel, Target: target, Message: "direct-tcpip is not allowed by policy"})
+				newChannel.Reject(ssh.Prohibited, "direct-tcpip is not allowed")
 				continue
 			}
-			go ssh.DiscardRequests(requests)
-			cs.tunnelChannel(channel, newChannel.ChannelType(), newChannel.ExtraData())
+			if err := cs.checkTunnel(dt.Host, dt.Port); err != nil {
+				cs.audit(audit.Event{Type: audit.Tunnel, Target: target, Message: err.Error()})
+				newChannel.Reject(ssh.Prohibited, err.Error())
+				continue
+			}
+			if err := cs.ensureBackend(); err != nil {
+				cs.errorf("cannot connect to backend: %s", err)
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			go cs.tunnelChannel(newChannel, target)
 		} else {
 			cs.errorf("unknown channel: %#v", newChannel)
 			newChannel.Reject(ssh.UnknownChannelType, fmt.Sprintf("unknown channel type: %s", newChannel.ChannelType()))
@@ -205,6 +336,8 @@ func (cs *clientSession) handleChannels(con ssh.Conn, chans <-chan ssh.NewChanne
 }
 
 func (cs *clientSession) handleChannel(con ssh.Conn, channel ssh.Channel, reqs <-chan *ssh.Request) {
+	sc := newChannelSession(cs, channel)
+	cs.addChannel(sc)
 	defer func() {
 		e := recover()
 		if e != nil {
@@ -213,7 +346,7 @@ func (cs *clientSession) handleChannel(con ssh.Conn, channel ssh.Channel, reqs <
 		}
 	}()
 	for req := range reqs {
-		cs.incRT()
+		cs.touch()
 		cs.tracef("channel request: %s", req.Type)
 		if strings.HasPrefix(req.Type, "auth-agent-req") {
 			subs := string([]byte(req.Type)[len("auth-agent-req"):])
@@ -223,60 +356,172 @@ func (cs *clientSession) handleChannel(con ssh.Conn, channel ssh.Channel, reqs <
 				panic(err)
 			}
 			cs.agent = agent.NewClient(ac)
-			_, err = cs.connectToBackend(fmt.Sprintf("%s:%d", cs.remoteHost, cs.remotePort), cs.remoteUser, cs.agent)
-			if err != nil {
-				panic(err)
-			}
-
 			if req.WantReply {
 				req.Reply(true, nil)
 			}
 		} else {
+			host, g := cs.selection()
+			if req.Type == "subsystem" && host == "" {
+				fmt.Fprintf(channel.Stderr(), "no backend selected\r\n")
+				if req.WantReply {
+					req.Reply(false, nil)
+				}
+				channel.Close()
+				continue
+			}
+			if (req.Type == "exec" || req.Type == "shell" || req.Type == "subsystem") && host != "" && !g.allows(req.Type) {
+				cs.infof("%s denied by policy", req.Type)
+				fmt.Fprintf(channel.Stderr(), "%s is not allowed\r\n", req.Type)
+				if req.WantReply {
+					req.Reply(false, nil)
+				}
+				channel.Close()
+				continue
+			}
 			switch req.Type {
 			case "exec":
-				exc := parseStrings(req.Payload, 1)
+				if host == "" {
+					if req.WantReply {
+						req.Reply(true, nil)
+					}
+					go sc.listHosts()
+					continue
+				}
+				exc, err := parseStrings(req.Payload, 1)
+				if err != nil {
+					cs.warnf("exec: %s", err)
+					if req.WantReply {
+						req.Reply(false, nil)
+					}
+					channel.Close()
+					continue
+				}
 				cs.debugf("ssh exec: %v", exc)
-				go cs.connectRemote(fmt.Sprintf("%s:%d", cs.remoteHost, cs.remotePort), channel, &exc[0])
+				if err := cs.checkScp(&exc[0]); err != nil {
+					fmt.Fprintf(channel.Stderr(), "%s\r\n", err)
+					if req.WantReply {
+						req.Reply(false, nil)
+					}
+					channel.Close()
+					continue
+				}
+				go sc.connectRemote(req)
 			case "shell":
-				go cs.connectRemote(fmt.Sprintf("%s:%d", cs.remoteHost, cs.remotePort), channel, nil)
+				if host == "" {
+					// the menu is the shell until a backend is selected
+					if req.WantReply {
+						req.Reply(true, nil)
+					}
+					if term, _, _ := sc.terminal(); term == "" {
+						go sc.listHosts()
+					} else {
+						go sc.menuShell()
+					}
+					continue
+				}
+				go sc.connectRemote(req)
+			case "subsystem":
+				if _, err := parseStrings(req.Payload, 1); err != nil {
+					cs.warnf("subsystem: %s", err)
+					if req.WantReply {
+						req.Reply(false, nil)
+					}
+					channel.Close()
+					continue
+				}
+				go sc.connectRemote(req)
 			default:
-				cs.forward(req, false)
+				sc.trackTerminal(req)
+				if err := sc.forward(req); err != nil {
+					cs.errorf("send %s to backend: %s", req.Type, err)
+				}
 				//log.Printf("[DEBUG] req: %+v", req)
 			}
 		}
 	}
+	sc.close()
+	cs.removeChannel(sc)
 }
 
-func (cs *clientSession) forward(rq *ssh.Request, global bool) error {
+// forward a global request to the backend or keep it until the backend
+// is connected.
+func (cs *clientSession) forwardGlobal(rq *ssh.Request) error {
+	cs.backendMux.Lock()
+	defer cs.backendMux.Unlock()
 	if cs.backend == nil {
-		if global {
-			cs.globalBufferedRqs = append(cs.globalBufferedRqs, rq)
+		cs.globalBufferedRqs = append(cs.globalBufferedRqs, rq)
+		return nil
+	}
+	return cs.forwardGlobalRequest(rq)
+}
+
+// connect to the backend if this did not happen before.
+func (cs *clientSession) ensureBackend() error {
+	cs.backendMux.Lock()
+	defer cs.backendMux.Unlock()
+	if cs.backend != nil {
+		return nil
+	}
+	if cs.remoteHost == "" {
+		return fmt.Errorf("no backend selected")
+	}
+	gw, err := cs.hostGateway()
+	if err != nil {
+		return err
+	}
+	if !gw.UseCA() && cs.agent == nil {
+		return fmt.Errorf("you must enable agent forwarding")
+	}
+	_, err = cs.connectToBackend(gw, cs.backendAddr(), cs.remoteUser, cs.agent)
+	return err
+}
+
+// the keys to authenticate at the backend: a signed certificate and/or
+// the keys of the forwarded agent.
+func (cs *clientSession) backendSigners(gw *config.Gateway, ag agent.Agent) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	if gw.UseCA() {
+		s, err := cs.certSigner(gw)
+		if err != nil {
+			if !gw.UseAgent() || ag == nil {
+				return nil, err
+			}
+			cs.warnf("cannot sign certificate, using agent only: %s", err)
 		} else {
-			cs.bufferedRqs = append(cs.bufferedRqs, rq)
+			signers = append(signers, s)
 		}
-		return nil
-	} else {
-		if global {
-			return cs.forwardGlobalRequest(rq)
+	}
+	if gw.UseAgent() && ag != nil {
+		as, err := ag.Signers()
+		if err != nil {
+			return nil, err
 		}
-		return cs.forwardRequest(rq)
+		signers = append(signers, as...)
 	}
+	return signers, nil
 }
 
-func (cs *clientSession) connectToBackend(backend string, user string, ag agent.Agent) (*backendClient, error) {
+// connect to the backend with the authentication and the host key check
+// of the gateway of its zone.
+func (cs *clientSession) connectToBackend(gw *config.Gateway, backend string, user string, ag agent.Agent) (*backendClient, error) {
+	signers, err := cs.backendSigners(gw, ag)
+	if err != nil {
+		return nil, err
+	}
 	sshConfig := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{ssh.PublicKeysCallback(ag.Signers)},
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: cs.hostKeyCallback(gw),
 	}
 
 	cs.debugf("connect to backend %s with user %s", backend, user)
-	client, err := dial("tcp", backend, sshConfig)
+	client, err := cs.dial(backend, sshConfig)
 
 	if err != nil {
 		return nil, fmt.Errorf("Dial error: %s", err)
 	}
-	cs.backend = client
 	client.orcasession = cs
+	cs.backend = client
 
 	for _, rq := range cs.globalBufferedRqs {
 		if err := cs.forwardGlobalRequest(rq); err != nil {
@@ -285,90 +530,42 @@ func (cs *clientSession) connectToBackend(backend string, user string, ag agent.
 	}
 	cs.globalBufferedRqs = nil
 
-	err = client.forwardAgent(ag)
-	if err != nil {
-		return nil, fmt.Errorf("agentforward error: %s", err)
-	}
-	cs.debugf("opening new clientsession for user %s", user)
-	err = client.newSession()
-	if err != nil {
-		client.close()
-		return nil, fmt.Errorf("session error: %s", err)
+	if ag != nil {
+		err = client.forwardAgent(ag)
+		if err != nil {
+			return nil, fmt.Errorf("agentforward error: %s", err)
+		}
 	}
-
 	go cs.handleBackendChannel("x11", client.handle("x11"))
 	go cs.handleBackendChannel("forwarded-tcpip", client.handle("forwarded-tcpip"))
 
 	return client, nil
 }
 
-func (cs *clientSession) connectRemote(backend string, channel ssh.Channel, cmd *string) {
-	defer cs.serverConn.Close()
-	if cs.backend == nil {
-		cs.errorf("you must enable agent forwarding")
-		return
-	}
-	defer cs.backend.close()
-
-	sess := cs.backend.session
-
-	cs.forwardBufferedRequest()
-	stdoutP, e := sess.StdoutPipe()
-	if e != nil {
-		cs.errorf("connect to stdout: %s", e)
-		return
-	}
-	stderrP, e := sess.StderrPipe()
-	if e != nil {
-		cs.errorf("connect to stderr: %s", e)
-		return
-	}
-	stdinP, e := sess.StdinPipe()
-	if e != nil {
-		cs.errorf("connect to stdin: %s", e)
-		return
-	}
-	wc := cs.wrap(channel)
-	wce := cs.wrap(channel.Stderr())
-	go io.Copy(wc, stdoutP)
-	go io.Copy(wce, stderrP)
-	go io.Copy(stdinP, wc)
-	if cmd != nil {
-		cs.debugf("start remote command %s", *cmd)
-		e = sess.Start(*cmd)
-	} else {
-		cs.debugf("opening remote shell")
-		e = sess.Shell()
-	}
-	if e != nil {
-		cs.errorf("opening shell: %s", e)
-		return
-	}
-	e = sess.Wait()
-	exitCode := 0
-	if e != nil {
-		ee, ok := e.(*ssh.ExitError)
-		if ok {
-			wm := ee.Waitmsg
-			cs.errorf("wait for shell, signal:%s, status:%d, msg:%s", wm.Signal(), wm.ExitStatus(), wm.Msg())
-			exitCode = wm.ExitStatus()
-		} else {
-			cs.errorf("wait for shell: %s", e)
-			exitCode = 255 // ???
-		}
-		return
-	}
-	if cmd != nil {
-		// TODO: send signal back!
-		resbuf := make([]byte, 4)
-		binary.BigEndian.PutUint32(resbuf, uint32(exitCode))
-		channel.SendRequest("exit-status", false, resbuf)
-	}
+func sendExitStatus(channel ssh.Channel, code int) error {
+	resbuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(resbuf, uint32(code))
+	_, err := channel.SendRequest("exit-status", false, resbuf)
+	return err
 }
 
 func (cs *clientSession) handleBackendChannel(tp string, nch <-chan ssh.NewChannel) error {
 	for ch := range nch {
-		cs.incRT()
+		cs.touch()
+		if _, g := cs.selection(); !g.allows(tp) {
+			cs.infof("%s denied by policy", tp)
+			ch.Reject(ssh.Prohibited, fmt.Sprintf("%s is not allowed", tp))
+			continue
+		}
+		if tp == "forwarded-tcpip" {
+			fwd, ok := cs.isRemoteForward(ch.ExtraData())
+			if !ok {
+				cs.warnf("backend opened forwarded-tcpip without an active forward")
+				ch.Reject(ssh.Prohibited, "no such remote forward")
+				continue
+			}
+			cs.infof("remote forward: connection on %s", fwd)
+		}
 		c, rqs, err := ch.Accept()
 		cs.tracef("new backendchannel '%s'", tp)
 		if err != nil {
@@ -379,7 +576,9 @@ func (cs *clientSession) handleBackendChannel(tp string, nch <-chan ssh.NewChann
 		if err != nil {
 			return fmt.Errorf("[ERROR] opening channel %s to client : %s", tp, err)
 		}
+		cs.countChannel(tp, 1)
 		go func() {
+			defer cs.countChannel(tp, -1)
 			go ssh.DiscardRequests(crqs)
 			ch := cs.wrap(clientChannel)
 			go io.Copy(c, ch)
@@ -392,6 +591,9 @@ func (cs *clientSession) handleBackendChannel(tp string, nch <-chan ssh.NewChann
 }
 
 func (cs *clientSession) forwardGlobalRequest(rq *ssh.Request) error {
+	if rq.Type == "tcpip-forward" || rq.Type == "cancel-tcpip-forward" {
+		return cs.remoteForward(rq)
+	}
 	ok, data, err := cs.backend.client.SendRequest(rq.Type, rq.WantReply, rq.Payload)
 	if err != nil {
 		return err
@@ -404,80 +606,57 @@ func (cs *clientSession) forwardGlobalRequest(rq *ssh.Request) error {
 	return nil
 }
 
-func (cs *clientSession) forwardRequest(rq *ssh.Request) error {
-	ok, err := cs.backend.session.SendRequest(rq.Type, rq.WantReply, rq.Payload)
-	if err != nil {
-		return err
-	} else {
-		cs.tracef("forwarded %s to backend [%v]", rq.Type, ok)
-		if rq.WantReply {
-			rq.Reply(ok, nil)
-		}
-	}
-	return nil
-}
-
-func (cs *clientSession) forwardBufferedRequest() error {
-	for _, rq := range cs.bufferedRqs {
-		if err := cs.forwardRequest(rq); err != nil {
-			cs.errorf("send %s to backend: %s", rq.Type, err)
-		}
-	}
-	cs.bufferedRqs = make([]*ssh.Request, 0)
-	return nil
-}
-
-func (cs *clientSession) tunnelChannel(ch ssh.Channel, tp string, data []byte) error {
-	ch1, rqs, err := cs.backend.client.OpenChannel(tp, data)
-	if err != nil {
-		return fmt.Errorf("open channel %s to backend: %s", tp, err)
-	}
-	go ssh.DiscardRequests(rqs)
-	go func() {
-		wch := cs.wrap(ch)
-		go io.Copy(ch1, wch)
-		io.Copy(wch, ch1)
-		ch.Close()
-		ch1.Close()
-	}()
-	return nil
-
-}
-
 func split(userAtHost string) (string, string, error) {
 	res := strings.Split(userAtHost, "@")
 	if len(res) != 2 {
 		if configuration.DefaultHost != "" {
 			return userAtHost, configuration.DefaultHost, nil
 		}
+		if len(res) == 1 {
+			// no target given, the user has to select one
+			return userAtHost, "", nil
+		}
 		return "", "", fmt.Errorf("unknown target: %s", userAtHost)
 	}
 	return res[0], res[1], nil
 }
 
-func parseStrings(b []byte, num int) []string {
+// Parse num strings of an ssh payload, every string is prefixed with its
+// length.
+func parseStrings(b []byte, num int) ([]string, error) {
 	var res []string
-	if len(b) < 1 {
-		return res
-	}
 	offset := 0
 	for i := 0; i < num; i++ {
-		len := int(binary.BigEndian.Uint32(b[offset:]))
+		if len(b)-offset < 4 {
+			return nil, fmt.Errorf("illegal payload: missing string %d", i+1)
+		}
+		l := int(binary.BigEndian.Uint32(b[offset:]))
 		start := offset + 4
-		end := start + len
+		if l > len(b)-start {
+			return nil, fmt.Errorf("illegal payload: string %d is too long", i+1)
+		}
+		end := start + l
 		res = append(res, string(b[start:end]))
 		offset = end
 	}
-	return res
+	return res, nil
 }
 
-func dial(network, addr string, config *ssh.ClientConfig) (*backendClient, error) {
-	conn, err := net.Dial(network, addr)
+func (cs *clientSession) dial(addr string, config *ssh.ClientConfig) (*backendClient, error) {
+	var conn net.Conn
+	var err error
+	if len(cs.hops) > 0 {
+		cs.infof("connect to %s over %s", addr, strings.Join(cs.hops, ">"))
+		conn, err = cs.dialHops(addr)
+	} else {
+		conn, err = net.Dial("tcp", addr)
+	}
 	if err != nil {
 		return nil, err
 	}
 	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 	return newClient(c, chans, reqs), err
@@ -488,6 +667,46 @@ func checkBackendAccess(address string, cfg config.Gateway) error {
 	if err != nil {
 		return err
 	}
+	return checkCidrs(address, ips, config.ClientRules{AllowedCidrs: cfg.AllowedCidrs, DeniedCidrs: cfg.DeniedCidrs, AllowDeny: cfg.AllowDeny})
+}
+
+// Check the client address against the rules of the zone and the rules
+// of the roles of the user. The client must pass the rules of every role.
+func checkClientAccess(ip net.IP, roles []string, cfg config.Gateway) error {
+	if err := checkCidrs(ip.String(), []net.IP{ip}, cfg.ClientRules); err != nil {
+		return err
+	}
+	return checkRoleClientAccess(ip, roles, cfg)
+}
+
+// Check the client address against the rules of the roles of the user.
+func checkRoleClientAccess(ip net.IP, roles []string, cfg config.Gateway) error {
+	for _, r := range roles {
+		if rules, ok := cfg.RoleClientRules[r]; ok {
+			if err := checkCidrs(ip.String(), []net.IP{ip}, rules); err != nil {
+				return fmt.Errorf("role %s: %s", r, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns true if the address is a load balancer of the zone which sends
+// the address of the client with the PROXY protocol.
+func trustedProxy(addr net.Addr) bool {
+	lock.Lock()
+	cidrs := configuration.TrustedProxies
+	lock.Unlock()
+	ip := net.ParseIP(addressOf(addr))
+	for _, c := range cidrs {
+		if _, nw, err := net.ParseCIDR(c); err == nil && nw.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkCidrs(address string, ips []net.IP, cfg config.ClientRules) error {
 	var allowed []*net.IPNet
 	var denied []*net.IPNet
 	for _, a := range cfg.AllowedCidrs {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"net"
 	"testing"
 
 	"github.com/clusterit/orca/config"
@@ -97,3 +98,34 @@ func TestCheckBackendAccessDenyAllow(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckClientAccess(t *testing.T) {
+	cfg := config.Gateway{
+		ClientRules: config.ClientRules{DeniedCidrs: []string{"10.66.0.0/16"}},
+		RoleClientRules: map[string]config.ClientRules{
+			"MANAGER": {AllowDeny: true, AllowedCidrs: []string{"10.8.0.0/16", "192.168.1.0/24"}},
+		},
+	}
+	tests := []struct {
+		ip      string
+		roles   []string
+		allowed bool
+	}{
+		{"1.2.3.4", nil, true},
+		{"10.66.1.1", nil, false},
+		{"1.2.3.4", []string{"USER"}, true},
+		{"1.2.3.4", []string{"USER", "MANAGER"}, false},
+		{"10.8.3.4", []string{"USER", "MANAGER"}, true},
+		{"192.168.1.7", []string{"MANAGER"}, true},
+		{"10.66.1.1", []string{"MANAGER"}, false},
+	}
+	for _, tst := range tests {
+		err := checkClientAccess(net.ParseIP(tst.ip), tst.roles, cfg)
+		if tst.allowed && err != nil {
+			t.Errorf("%s with %v should be allowed: %s", tst.ip, tst.roles, err)
+		}
+		if !tst.allowed && err == nil {
+			t.Errorf("%s with %v should be denied", tst.ip, tst.roles)
+		}
+	}
+}
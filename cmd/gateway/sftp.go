@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/clusterit/orca/audit"
+	"github.com/clusterit/orca/sftp"
+)
+
+// the sftp and scp policy of the zone
+func sftpPolicy() sftp.Policy {
+	return sftp.Policy{ReadOnly: configuration.SftpReadOnly, Prefixes: configuration.SftpPrefixes}
+}
+
+// audit a file operation. Denied operations are always audited, the
+// others only if the zone wants it.
+func (cs *clientSession) auditSftp(ev sftp.Event) {
+	if !ev.Denied && !configuration.SftpAudit {
+		return
+	}
+	aev := audit.Event{Type: audit.Sftp, Op: ev.Op, Target: ev.Path, Allowed: !ev.Denied, Message: ev.Error}
+	if ev.Target != "" {
+		aev.Target = ev.Path + " -> " + ev.Target
+	}
+	switch ev.Op {
+	case sftp.OpRead:
+		aev.BytesIn = ev.Size
+	case sftp.OpWrite:
+		aev.BytesOut = ev.Size
+	}
+	cs.audit(aev)
+}
+
+// Decode the sftp packets between the client and the backend. The
+// returned function reports the files which are still open and must be
+// called when the session ends.
+func (cs *clientSession) proxySftp(pipe *sessionPipe, client io.Writer, fromClient io.Reader) func() {
+	p := sftp.NewProxy(client, pipe.backend, sftpPolicy(), cs.auditSftp)
+	pipe.output.Add(1)
+	go func() {
+		defer pipe.output.Done()
+		if err := p.ServeResponses(pipe.backend); err != nil && err != io.EOF {
+			cs.warnf("sftp responses: %s", err)
+			pipe.backend.Close()
+		}
+	}()
+	go func() {
+		if err := p.ServeRequests(fromClient); err != nil && err != io.EOF {
+			cs.warnf("sftp requests: %s", err)
+		}
+		pipe.backend.CloseWrite()
+	}()
+	return p.Close
+}
+
+// an scp command on the backend: with -t the client uploads to the path,
+// with -f it downloads the path.
+type scpCommand struct {
+	upload bool
+	path   string
+}
+
+func parseScp(cmd *string) *scpCommand {
+	if cmd == nil {
+		return nil
+	}
+	args := strings.Fields(*cmd)
+	if len(args) < 2 || path.Base(args[0]) != "scp" {
+		return nil
+	}
+	var sc scpCommand
+	transfer := false
+	i := 1
+	for ; i < len(args) && strings.HasPrefix(args[i], "-"); i++ {
+		if args[i] == "--" {
+			i++
+			break
+		}
+		if strings.Contains(args[i], "t") {
+			sc.upload = true
+			transfer = true
+		}
+		if strings.Contains(args[i], "f") {
+			transfer = true
+		}
+	}
+	if !transfer || i >= len(args) {
+		return nil
+	}
+	sc.path = strings.Trim(strings.Join(args[i:], " "), "'\"")
+	return &sc
+}
+
+func (sc *scpCommand) op() string {
+	if sc.upload {
+		return sftp.OpWrite
+	}
+	return sftp.OpRead
+}
+
+// the characters which let the shell of the backend run more than scp
+const shellMeta = ";&|`$()<>\\\n"
+
+// Check an exec request against the sftp policy of the zone if it starts
+// scp on the backend. If the policy restricts the transfers, no other
+// command may run: cat, tar or an sftp-server would bypass the checks.
+func (cs *clientSession) checkScp(cmd *string) error {
+	if !configuration.SftpProxy() {
+		return nil
+	}
+	p := sftpPolicy()
+	sc := parseScp(cmd)
+	if p.Restricted() && (sc == nil || strings.ContainsAny(*cmd, shellMeta)) {
+		err := fmt.Errorf("only scp is allowed by the file transfer policy of the zone")
+		cs.audit(audit.Event{Type: audit.Scp, Target: *cmd, Message: err.Error()})
+		return err
+	}
+	if sc == nil {
+		return nil
+	}
+	if err := p.Check(sc.path, sc.upload); err != nil {
+		cs.audit(audit.Event{Type: audit.Scp, Op: sc.op(), Target: sc.path, Message: err.Error()})
+		return err
+	}
+	return nil
+}
+
+// counts the bytes which are written to it
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.n, int64(len(p)))
+	return len(p), nil
+}
+
+func (c *byteCounter) count() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// Count the bytes of an scp transfer. The returned function audits the
+// transfer and must be called when the session ends.
+func (cs *clientSession) countScp(sc *scpCommand, stdout io.Writer, stdin io.Reader) (io.Writer, io.Reader, func()) {
+	var out, in byteCounter
+	done := func() {
+		cs.audit(audit.Event{Type: audit.Scp, Op: sc.op(), Target: sc.path, Allowed: true, BytesOut: in.count(), BytesIn: out.count()})
+	}
+	return io.MultiWriter(stdout, &out), io.TeeReader(stdin, &in), done
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/clusterit/orca/config"
+)
+
+func TestParseScp(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		expected *scpCommand
+	}{
+		{"scp -t /tmp/x", &scpCommand{upload: true, path: "/tmp/x"}},
+		{"scp -v -r -f -- '/data/my file'", &scpCommand{path: "/data/my file"}},
+		{"/usr/bin/scp -pt .", &scpCommand{upload: true, path: "."}},
+		{"scp /tmp/x", nil},
+		{"scp -t", nil},
+		{"ls -t /tmp", nil},
+	}
+	for _, tst := range tests {
+		cmd := tst.cmd
+		sc := parseScp(&cmd)
+		if (sc == nil) != (tst.expected == nil) || sc != nil && *sc != *tst.expected {
+			t.Errorf("%q should be %+v, not %+v", tst.cmd, tst.expected, sc)
+		}
+	}
+}
+
+func TestCheckScp(t *testing.T) {
+	oldConfiguration := configuration
+	defer func() { configuration = oldConfiguration }()
+	cs := knownHostSession()
+	tests := []struct {
+		gw      config.Gateway
+		cmd     string
+		allowed bool
+	}{
+		{config.Gateway{}, "cat /etc/shadow", true},
+		{config.Gateway{SftpAudit: true}, "tar cf - /etc", true},
+		{config.Gateway{SftpPrefixes: []string{"/data"}}, "scp -f /data/x", true},
+		{config.Gateway{SftpPrefixes: []string{"/data"}}, "scp -f /etc/shadow", false},
+		{config.Gateway{SftpPrefixes: []string{"/data"}}, "scp -f /data/x; cat /etc/shadow", false},
+		{config.Gateway{SftpPrefixes: []string{"/data"}}, "cat /etc/shadow", false},
+		{config.Gateway{SftpReadOnly: true}, "/usr/lib/openssh/sftp-server", false},
+		{config.Gateway{SftpReadOnly: true}, "scp -t /data/x", false},
+		{config.Gateway{SftpReadOnly: true}, "scp -f /data/x", true},
+	}
+	for _, tst := range tests {
+		configuration = &tst.gw
+		cmd := tst.cmd
+		if err := cs.checkScp(&cmd); (err == nil) != tst.allowed {
+			t.Errorf("%q with %+v should be allowed: %v, got %v", tst.cmd, tst.gw, tst.allowed, err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/clusterit/orca/audit"
+	"github.com/clusterit/orca/logging"
+	"github.com/clusterit/orca/users"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// the login of a manager who watches a session: shadow+<session id>
+	shadowPrefix = "shadow+"
+	// the writes a viewer may lag behind, more output is dropped
+	shadowBacklog = 256
+)
+
+// the session a login wants to shadow
+func shadowOf(login string) (string, bool) {
+	if !strings.HasPrefix(login, shadowPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(login, shadowPrefix), true
+}
+
+// A viewer gets the output of a shadowed session. The output is queued,
+// so a slow viewer does not slow down the session.
+type viewer struct {
+	out chan []byte
+}
+
+func (cs *clientSession) addViewer() *viewer {
+	cs.shadowMux.Lock()
+	defer cs.shadowMux.Unlock()
+	if cs.viewers == nil {
+		cs.viewers = make(map[*viewer]bool)
+	}
+	v := &viewer{out: make(chan []byte, shadowBacklog)}
+	cs.viewers[v] = true
+	return v
+}
+
+func (cs *clientSession) removeViewer(v *viewer) {
+	cs.shadowMux.Lock()
+	defer cs.shadowMux.Unlock()
+	if cs.viewers[v] {
+		delete(cs.viewers, v)
+		close(v.out)
+	}
+}
+
+// shadowWriter passes the output of a session to its viewers.
+type shadowWriter struct {
+	cs *clientSession
+}
+
+func (s shadowWriter) Write(p []byte) (int, error) {
+	s.cs.shadowMux.Lock()
+	defer s.cs.shadowMux.Unlock()
+	for v := range s.cs.viewers {
+		select {
+		case v.out <- append([]byte(nil), p...):
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// the output of the session and a copy for the viewers
+func (cs *clientSession) shadowed(w io.Writer) io.Writer {
+	return io.MultiWriter(w, shadowWriter{cs})
+}
+
+// Serve a manager who watches the output of a session on this gateway.
+// The manager cannot send any input to the session, the user of the
+// session is told that someone is watching.
+func newShadowSession(tcpconn net.Conn, sshConn *ssh.ServerConn, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request, sid string) (*clientSession, error) {
+	cs := &clientSession{tcpConnection: tcpconn, serverConn: sshConn, start: time.Now(), closed: make(chan struct{})}
+	var roles []string
+	cs.userId, roles = userOf(sshConn.Permissions)
+	cs.sessionId = fmt.Sprintf("%x", sshConn.SessionID())
+	cs.logger = logging.New(cs.sessionId, sshConn.RemoteAddr().String())
+
+	activeMux.Lock()
+	shadowed := active[sid]
+	activeMux.Unlock()
+	ev := audit.Event{Type: audit.Shadow, Target: sid}
+	switch {
+	case !hasRole(roles, users.RoleManager):
+		ev.Message = "only managers may shadow sessions"
+	case shadowed == nil:
+		ev.Message = "no such session on this gateway"
+	default:
+		ev.Allowed = true
+		ev.Target = sid + " of " + shadowed.userId
+	}
+	cs.audit(ev)
+	if !ev.Allowed {
+		sshConn.Close()
+		return nil, fmt.Errorf("cannot shadow %s: %s", sid, ev.Message)
+	}
+	go ssh.DiscardRequests(reqs)
+	go cs.handleShadowChannels(chans, shadowed)
+	cs.watch()
+	cs.track()
+	go func() {
+		sshConn.Wait()
+		close(cs.closed)
+	}()
+	go func() {
+		select {
+		case <-shadowed.closed:
+			cs.notify("the shadowed session has ended")
+			sshConn.Close()
+		case <-cs.closed:
+		}
+	}()
+	return cs, nil
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (cs *clientSession) handleShadowChannels(chans <-chan ssh.NewChannel, shadowed *clientSession) {
+	for nc := range chans {
+		cs.touch()
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.Prohibited, fmt.Sprintf("%s is not allowed when shadowing", nc.ChannelType()))
+			continue
+		}
+		ch, rqs, err := nc.Accept()
+		if err != nil {
+			cs.errorf("accepting shadow channel: %s", err)
+			continue
+		}
+		go cs.shadowChannel(ch, rqs, shadowed)
+	}
+}
+
+// Copy the output of the shadowed session to the channel of the viewer
+// until the viewer closes the channel or presses ctrl-c or ctrl-d.
+func (cs *clientSession) shadowChannel(ch ssh.Channel, rqs <-chan *ssh.Request, shadowed *clientSession) {
+	sc := newChannelSession(cs, ch)
+	cs.addChannel(sc)
+	defer cs.removeChannel(sc)
+	v := shadowed.addViewer()
+	shadowed.notify(fmt.Sprintf("%s is watching this session", cs.userId))
+	shadowed.infof("shadowed by %s", cs.userId)
+	fmt.Fprintf(ch.Stderr(), "watching session %s of %s, press ctrl-c to stop\r\n", shadowed.sessionId, shadowed.userId)
+
+	out := cs.wrap(ch)
+	go func() {
+		for b := range v.out {
+			out.Write(b)
+		}
+	}()
+	go func() {
+		// the viewer has no input
+		buf := make([]byte, 256)
+		for {
+			n, err := ch.Read(buf)
+			if err != nil || bytes.IndexAny(buf[:n], "\x03\x04") >= 0 {
+				ch.Close()
+				return
+			}
+		}
+	}()
+	for rq := range rqs {
+		switch rq.Type {
+		case "pty-req", "shell", "exec", "window-change":
+			if rq.WantReply {
+				rq.Reply(true, nil)
+			}
+		default:
+			if rq.WantReply {
+				rq.Reply(false, nil)
+			}
+		}
+	}
+	shadowed.removeViewer(v)
+	shadowed.notify(fmt.Sprintf("%s stopped watching this session", cs.userId))
+	shadowed.infof("%s stopped shadowing", cs.userId)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestShadowOf(t *testing.T) {
+	if sid, ok := shadowOf("shadow+abc123"); !ok || sid != "abc123" {
+		t.Errorf("the session should be abc123, not %q", sid)
+	}
+	if _, ok := shadowOf("alice@db1"); ok {
+		t.Errorf("a normal login is no shadow")
+	}
+}
+
+func TestShadowWriter(t *testing.T) {
+	cs := &clientSession{}
+	var out strings.Builder
+	w := cs.shadowed(&out)
+	fmt.Fprintf(w, "before ")
+	v := cs.addViewer()
+	fmt.Fprintf(w, "hello")
+	if out.String() != "before hello" {
+		t.Errorf("the session should get all output, not %q", out.String())
+	}
+	if b := <-v.out; string(b) != "hello" {
+		t.Errorf("the viewer should get the output since it joined, not %q", b)
+	}
+	// a viewer which does not read must not block the session
+	for i := 0; i < 2*shadowBacklog; i++ {
+		fmt.Fprintf(w, "x")
+	}
+	if len(v.out) != shadowBacklog {
+		t.Errorf("the backlog should be full: %d", len(v.out))
+	}
+	cs.removeViewer(v)
+	fmt.Fprintf(w, "after")
+	if !strings.HasSuffix(out.String(), "after") {
+		t.Errorf("the session should continue without viewers")
+	}
+}
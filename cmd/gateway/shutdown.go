@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/clusterit/orca/logging"
+)
+
+// the environment variable with the descriptor of the listener a new
+// gateway process takes over
+const listenFdEnv = "ORCA_LISTEN_FD"
+
+var (
+	stopping     int32
+	connectedMux sync.Mutex
+	connected    = make(map[*clientSession]bool)
+)
+
+// Listen on the address or use the listener of the previous process.
+func listen(bind string) (net.Listener, error) {
+	fd := os.Getenv(listenFdEnv)
+	if fd == "" {
+		return net.Listen("tcp", bind)
+	}
+	os.Unsetenv(listenFdEnv)
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return nil, fmt.Errorf("illegal %s: %s", listenFdEnv, fd)
+	}
+	f := os.NewFile(uintptr(n), "listener")
+	defer f.Close()
+	Log(logging.Info, "taking over the listener of the previous gateway")
+	return net.FileListener(f)
+}
+
+// Start a new gateway process with the same arguments which takes over
+// the listener.
+func handOver(l net.Listener) error {
+	tl, ok := l.(*net.TCPListener)
+	if !ok {
+		return fmt.Errorf("cannot hand over a %T", l)
+	}
+	f, err := tl.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, listenFdEnv+"=") {
+			cmd.Env = append(cmd.Env, e)
+		}
+	}
+	// the first extra file is the descriptor 3 of the new process
+	cmd.Env = append(cmd.Env, listenFdEnv+"=3")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	Log(logging.Info, "handed the listener over to process %d", cmd.Process.Pid)
+	return nil
+}
+
+func isStopping() bool {
+	return atomic.LoadInt32(&stopping) != 0
+}
+
+// remember the connection until it is closed, so a shutdown can wait for
+// it
+func (cs *clientSession) track() {
+	connectedMux.Lock()
+	connected[cs] = true
+	connectedMux.Unlock()
+	go func() {
+		<-cs.closed
+		connectedMux.Lock()
+		delete(connected, cs)
+		connectedMux.Unlock()
+	}()
+}
+
+func connections() []*clientSession {
+	connectedMux.Lock()
+	defer connectedMux.Unlock()
+	var res []*clientSession
+	for cs := range connected {
+		res = append(res, cs)
+	}
+	return res
+}
+
+// Stop accepting connections and tell the users. The connections are
+// closed when the drain time is over.
+func drain(l net.Listener, reason string, d time.Duration) {
+	atomic.StoreInt32(&stopping, 1)
+	l.Close()
+	conns := connections()
+	Log(logging.Info, "%s, waiting up to %s for %d connections", reason, d, len(conns))
+	for _, cs := range conns {
+		cs.notify(fmt.Sprintf("%s, the connection will be closed in %s", reason, d))
+	}
+	end := time.After(d)
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for len(connections()) > 0 {
+		select {
+		case <-t.C:
+		case <-end:
+			for _, cs := range connections() {
+				cs.infof("closing connection: %s", reason)
+				cs.notify("connection closed: " + reason)
+				cs.serverConn.Close()
+			}
+			// give the sessions a moment to leave the registry
+			time.Sleep(time.Second)
+			return
+		}
+	}
+}
+
+// Drain the gateway on SIGTERM and SIGINT. On SIGHUP a new process takes
+// over the listener first, so the new connections go to the new process.
+// done is closed when the connections are drained.
+func handleSignals(l net.Listener, done chan struct{}) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	for sig := range sigs {
+		reason := "the gateway shuts down"
+		if sig == syscall.SIGHUP {
+			if err := handOver(l); err != nil {
+				Log(logging.Error, "cannot hand over the listener: %s", err)
+				continue
+			}
+			reason = "the gateway restarts"
+		}
+		signal.Stop(sigs)
+		lock.Lock()
+		d := configuration.Drain()
+		lock.Unlock()
+		drain(l, reason, d)
+		close(done)
+		return
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestListenInherited(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	os.Setenv(listenFdEnv, strconv.Itoa(int(f.Fd())))
+	inherited, err := listen("127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("the listener should be taken over: %s", err)
+	}
+	defer inherited.Close()
+	if os.Getenv(listenFdEnv) != "" {
+		t.Errorf("the descriptor should not be passed on")
+	}
+	if inherited.Addr().String() != l.Addr().String() {
+		t.Errorf("the listener should listen on %s, not %s", l.Addr(), inherited.Addr())
+	}
+	go net.Dial("tcp", inherited.Addr().String())
+	c, err := inherited.Accept()
+	if err != nil {
+		t.Fatalf("the inherited listener should accept connections: %s", err)
+	}
+	c.Close()
+}
+
+func TestDrainWithoutConnections(t *testing.T) {
+	defer atomic.StoreInt32(&stopping, 0)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	drain(l, "test", time.Minute)
+	if time.Since(start) > time.Second {
+		t.Errorf("without connections there is nothing to wait for")
+	}
+	if !isStopping() {
+		t.Errorf("the gateway should stop")
+	}
+	if _, err := l.Accept(); err == nil {
+		t.Errorf("the listener should be closed")
+	}
+}
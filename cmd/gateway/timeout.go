@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// the user is warned this long before the connection is closed
+const warnBefore = time.Minute
+
+// A watchdog decides when a connection has to be closed: when the client
+// was idle too long, when the maximum session time is over or when the
+// permit of the user ends.
+type watchdog struct {
+	idle       time.Duration
+	end        time.Time
+	endReason  string
+	warnedIdle bool
+	warnedEnd  bool
+}
+
+func newWatchdog(start time.Time, idle, maxSession time.Duration, permit time.Time) *watchdog {
+	w := &watchdog{idle: idle}
+	if maxSession > 0 {
+		w.end = start.Add(maxSession)
+		w.endReason = "maximum session time"
+	}
+	if !permit.IsZero() && (w.end.IsZero() || permit.Before(w.end)) {
+		w.end = permit
+		w.endReason = "end of your permit"
+	}
+	return w
+}
+
+// the time before a timeout when the user is warned
+func warnTime(d time.Duration) time.Duration {
+	if d/2 < warnBefore {
+		return d / 2
+	}
+	return warnBefore
+}
+
+// Check the connection at now, the last traffic of the client was at
+// active. Returns a warning for the user or the reason why the connection
+// must be closed now.
+func (w *watchdog) check(now, active time.Time) (warning string, closing string) {
+	if !w.end.IsZero() {
+		left := w.end.Sub(now)
+		if left <= 0 {
+			return "", w.endReason
+		}
+		if left <= warnBefore && !w.warnedEnd {
+			w.warnedEnd = true
+			warning = fmt.Sprintf("the connection will be closed in %s (%s)", left.Round(time.Second), w.endReason)
+		}
+	}
+	if w.idle > 0 {
+		left := w.idle - now.Sub(active)
+		if left <= 0 {
+			return "", "idle timeout"
+		}
+		if left > warnTime(w.idle) {
+			w.warnedIdle = false
+		} else if !w.warnedIdle && warning == "" {
+			w.warnedIdle = true
+			warning = fmt.Sprintf("you are idle, the connection will be closed in %s", left.Round(time.Second))
+		}
+	}
+	return warning, ""
+}
+
+// remember the traffic of the client
+func (cs *clientSession) touch() {
+	atomic.StoreInt64(&cs.active, time.Now().UnixNano())
+}
+
+func (cs *clientSession) lastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&cs.active))
+}
+
+// the end of the permit if the user needed one to login
+func permitUntil(perms *ssh.Permissions) time.Time {
+	if perms == nil || perms.Extensions[permPermitUntil] == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, perms.Extensions[permPermitUntil])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Start the watchdog of the connection with the timeouts of the zone and
+// the roles of the user.
+func (cs *clientSession) watch() {
+	_, roles := userOf(cs.serverConn.Permissions)
+	to := configuration.TimeoutsFor(roles)
+	start := time.Now()
+	w := newWatchdog(start, time.Duration(to.Idle)*time.Second, time.Duration(to.MaxSession)*time.Second, permitUntil(cs.serverConn.Permissions))
+	cs.touch()
+	go func() {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-cs.closed:
+				return
+			case now := <-t.C:
+				warning, closing := w.check(now, cs.lastActive())
+				if warning != "" {
+					cs.notify(warning)
+				}
+				if closing != "" {
+					cs.infof("closing connection after %s: %s", now.Sub(start).Round(time.Second), closing)
+					cs.notify("connection closed: " + closing)
+					cs.serverConn.Close()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// write a message to the stderr of all sessions of the client.
+func (cs *clientSession) notify(msg string) {
+	cs.channelMux.Lock()
+	defer cs.channelMux.Unlock()
+	for sc := range cs.channels {
+		fmt.Fprintf(sc.channel.Stderr(), "\r\n%s\r\n", msg)
+	}
+}
+
+func (cs *clientSession) addChannel(sc *channelSession) {
+	cs.channelMux.Lock()
+	defer cs.channelMux.Unlock()
+	if cs.channels == nil {
+		cs.channels = make(map[*channelSession]bool)
+	}
+	cs.channels[sc] = true
+	if cs.channelCounts == nil {
+		cs.channelCounts = make(map[string]int)
+	}
+	cs.channelCounts["session"]++
+}
+
+func (cs *clientSession) removeChannel(sc *channelSession) {
+	cs.channelMux.Lock()
+	defer cs.channelMux.Unlock()
+	delete(cs.channels, sc)
+	cs.channelCounts["session"]--
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchdogIdle(t *testing.T) {
+	start := time.Now()
+	w := newWatchdog(start, 10*time.Minute, 0, time.Time{})
+	if warn, closing := w.check(start.Add(5*time.Minute), start); warn != "" || closing != "" {
+		t.Errorf("nothing should happen after 5 minutes: %q, %q", warn, closing)
+	}
+	warn, _ := w.check(start.Add(9*time.Minute+30*time.Second), start)
+	if !strings.Contains(warn, "30s") {
+		t.Errorf("the user should be warned, not %q", warn)
+	}
+	if warn, _ := w.check(start.Add(9*time.Minute+40*time.Second), start); warn != "" {
+		t.Errorf("the user should be warned only once, not %q", warn)
+	}
+	// the user is active again
+	active := start.Add(9*time.Minute + 50*time.Second)
+	if warn, closing := w.check(active.Add(time.Second), active); warn != "" || closing != "" {
+		t.Errorf("an active user should not be warned: %q, %q", warn, closing)
+	}
+	if warn, _ := w.check(active.Add(9*time.Minute+30*time.Second), active); warn == "" {
+		t.Errorf("the user should be warned again")
+	}
+	if _, closing := w.check(active.Add(10*time.Minute), active); closing != "idle timeout" {
+		t.Errorf("the connection should be closed, not %q", closing)
+	}
+}
+
+func TestWatchdogEnd(t *testing.T) {
+	start := time.Now()
+	w := newWatchdog(start, time.Hour, 2*time.Hour, start.Add(30*time.Minute))
+	now := start.Add(29 * time.Minute)
+	warn, closing := w.check(now, now)
+	if !strings.Contains(warn, "permit") || closing != "" {
+		t.Errorf("the user should be warned about the permit, not %q, %q", warn, closing)
+	}
+	now = start.Add(30 * time.Minute)
+	if _, closing := w.check(now, now); closing != "end of your permit" {
+		t.Errorf("the connection should be closed at the end of the permit, not %q", closing)
+	}
+
+	w = newWatchdog(start, time.Hour, 2*time.Hour, time.Time{})
+	now = start.Add(2 * time.Hour)
+	if _, closing := w.check(now, now); closing != "maximum session time" {
+		t.Errorf("the connection should be closed after the maximum session time, not %q", closing)
+	}
+}
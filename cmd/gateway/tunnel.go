@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/clusterit/orca/audit"
+	"golang.org/x/crypto/ssh"
+)
+
+// Check if the destination of a direct-tcpip channel is in the tunnel
+// destinations of the zone or of the granted policies of the user.
+func (cs *clientSession) checkTunnel(host string, port uint32) error {
+	patterns := append([]string{}, configuration.Tunnels...)
+	_, g := cs.selection()
+	patterns = append(patterns, g.tunnels()...)
+	if matchesDestination(patterns, host, port) {
+		return nil
+	}
+	return fmt.Errorf("tunnels to %s are not allowed", net.JoinHostPort(host, strconv.Itoa(int(port))))
+}
+
+// Check if one of the patterns (host:port) matches the destination. The
+// host of a pattern can be a glob or a CIDR, the port a number, a range
+// (8000-8999) or *. The destination is resolved by the backend, so a CIDR
+// only matches IP addresses.
+func matchesDestination(patterns []string, host string, port uint32) bool {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	}
+	for _, p := range patterns {
+		h, ps, err := net.SplitHostPort(p)
+		if err != nil {
+			logger.Warnf("the tunnel destination %s cannot be parsed, ignoring", p)
+			continue
+		}
+		if !matchesPort(ps, port) {
+			continue
+		}
+		if matchesHost([]string{h}, host, ips) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesPort(pattern string, port uint32) bool {
+	if pattern == "*" {
+		return true
+	}
+	lo, hi := pattern, pattern
+	if i := strings.Index(pattern, "-"); i > 0 {
+		lo, hi = pattern[:i], pattern[i+1:]
+	}
+	l, err := strconv.Atoi(lo)
+	if err != nil {
+		return false
+	}
+	h, err := strconv.Atoi(hi)
+	if err != nil {
+		return false
+	}
+	return uint32(l) <= port && port <= uint32(h)
+}
+
+// Open the channel at the backend and copy the data until one side
+// closes. The transferred bytes are audited.
+func (cs *clientSession) tunnelChannel(nc ssh.NewChannel, target string) {
+	ch1, rqs, err := cs.backend.client.OpenChannel(nc.ChannelType(), nc.ExtraData())
+	if err != nil {
+		cs.audit(audit.Event{Type: audit.Tunnel, Target: target, Allowed: true, Message: err.Error()})
+		if oe, ok := err.(*ssh.OpenChannelError); ok {
+			nc.Reject(oe.Reason, oe.Message)
+		} else {
+			nc.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	go ssh.DiscardRequests(rqs)
+	ch, requests, err := nc.Accept()
+	if err != nil {
+		cs.errorf("accepting new %s channel: %s", nc.ChannelType(), err)
+		ch1.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	cs.infof("tunnel to %s opened", target)
+	cs.countChannel(nc.ChannelType(), 1)
+	go func() {
+		defer cs.countChannel(nc.ChannelType(), -1)
+		var sent int64
+		done := make(chan bool)
+		wch := cs.wrap(ch)
+		go func() {
+			sent, _ = io.Copy(ch1, wch)
+			close(done)
+		}()
+		received, _ := io.Copy(wch, ch1)
+		ch.Close()
+		ch1.Close()
+		<-done
+		cs.audit(audit.Event{Type: audit.Tunnel, Target: target, Allowed: true, BytesOut: sent, BytesIn: received})
+	}()
+}
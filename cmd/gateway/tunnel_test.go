@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/clusterit/orca/config"
+)
+
+func TestMatchesDestination(t *testing.T) {
+	patterns := []string{"localhost:5432", "db*.intranet:*", "10.1.0.0/16:8000-8999", "[fd00::/8]:22"}
+	allowed := map[string]uint32{
+		"localhost":         5432,
+		"db1.intranet":      3306,
+		"10.1.2.3":          8080,
+		"fd00::1":           22,
+		"dbmaster.intranet": 1,
+	}
+	for h, p := range allowed {
+		if !matchesDestination(patterns, h, p) {
+			t.Errorf("%s:%d should be allowed", h, p)
+		}
+	}
+	denied := map[string]uint32{
+		"localhost":    22,
+		"web.intranet": 80,
+		"10.1.2.3":     9000,
+		"10.2.0.1":     8080,
+		"fd00::1":      2222,
+	}
+	for h, p := range denied {
+		if matchesDestination(patterns, h, p) {
+			t.Errorf("%s:%d should be denied", h, p)
+		}
+	}
+	if matchesDestination(nil, "localhost", 22) {
+		t.Errorf("without destinations no tunnel should be allowed")
+	}
+}
+
+func TestGrantedTunnels(t *testing.T) {
+	g := &grants{restricted: true, policies: []config.Policy{
+		{Id: "all", Tunnels: []string{"localhost:*"}},
+		{Id: "shell", Channels: []string{config.ChannelShell}, Tunnels: []string{"*:*"}},
+		{Id: "tunnel", Channels: []string{config.ChannelDirectTcpip}, Tunnels: []string{"db1:5432"}},
+	}}
+	tunnels := g.tunnels()
+	if len(tunnels) != 2 || tunnels[0] != "localhost:*" || tunnels[1] != "db1:5432" {
+		t.Errorf("only the tunnels of policies with direct-tcpip should be granted: %v", tunnels)
+	}
+	var none *grants
+	if len(none.tunnels()) != 0 {
+		t.Errorf("no grants should not have tunnels")
+	}
+}
@@ -9,11 +9,19 @@ import (
 
 	"github.com/spf13/viper"
 
+	"github.com/clusterit/orca/approvals"
+	approvalservice "github.com/clusterit/orca/approvals/service"
+	"github.com/clusterit/orca/audit"
+	auditservice "github.com/clusterit/orca/audit/service"
+	"github.com/clusterit/orca/bans"
+	banservice "github.com/clusterit/orca/bans/service"
 	"github.com/clusterit/orca/cmd"
 	"github.com/clusterit/orca/config"
 	configservice "github.com/clusterit/orca/config/service"
 	"github.com/clusterit/orca/etcd"
 	"github.com/clusterit/orca/logging"
+	"github.com/clusterit/orca/sessions"
+	sessionservice "github.com/clusterit/orca/sessions/service"
 	"github.com/clusterit/orca/users"
 	"github.com/davecgh/go-spew/spew"
 	"gopkg.in/emicklei/go-restful.v1"
@@ -184,6 +192,9 @@ func connect(etcds string, etcdKey, etcdCert, etcdCaCert string) (*etcd.Cluster,
 	if err != nil {
 		return nil, nil, err
 	}
+	if err := cmd.UseKeyring(cc, viper.GetString("master_key_file"), viper.GetString("master_key")); err != nil {
+		return nil, nil, err
+	}
 	cfger, err := config.New(cc)
 	if err != nil {
 		return nil, nil, err
@@ -235,18 +246,26 @@ func fetchZoneData(cfger config.Configer, zone string, rms []*restmanager) error
 }
 
 type restmanager struct {
-	publishUrl     string
-	rootUrl        string
-	cluster        *etcd.Cluster
-	userimpl       users.Users
-	authimpl       auth.Auther
-	configer       config.Configer
-	oauthreg       oauth.AuthRegistry
-	autherService  *auth.AutherService
-	configService  *configservice.ConfigService
-	usersService   *users.UsersService
-	wsContainer    *restful.Container
-	authregService *oauth.AuthRegService
+	publishUrl      string
+	rootUrl         string
+	cluster         *etcd.Cluster
+	userimpl        users.Users
+	authimpl        auth.Auther
+	configer        config.Configer
+	oauthreg        oauth.AuthRegistry
+	auditor         audit.Auditor
+	registry        sessions.Registry
+	bans            bans.Bans
+	approvals       approvals.Approvals
+	autherService   *auth.AutherService
+	configService   *configservice.ConfigService
+	usersService    *users.UsersService
+	wsContainer     *restful.Container
+	authregService  *oauth.AuthRegService
+	auditService    *auditservice.AuditService
+	sessionService  *sessionservice.SessionService
+	banService      *banservice.BanService
+	approvalService *approvalservice.ApprovalService
 
 	initAuther         func(string, config.ClusterConfig, oauth.AuthRegistry) (auth.Auther, error)
 	switchSettings     func(config.ClusterConfig, oauth.AuthRegistry) (auth.Auther, error)
@@ -263,9 +282,29 @@ func newRest(cc *etcd.Cluster, cfg config.Configer, publishurl string, rooturl s
 	if err != nil {
 		return nil, err
 	}
+	auditor, err := audit.New(cc, audit.DefaultRetention)
+	if err != nil {
+		return nil, err
+	}
+	registry, err := sessions.New(cc, sessions.DefaultTTL)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := bans.New(cc)
+	if err != nil {
+		return nil, err
+	}
+	aps, err := approvals.New(cc)
+	if err != nil {
+		return nil, err
+	}
 	rm := &restmanager{cluster: cc,
 		userimpl:   userimpl,
 		oauthreg:   oauther,
+		auditor:    auditor,
+		registry:   registry,
+		bans:       bs,
+		approvals:  aps,
 		publishUrl: publishurl,
 		configer:   cfg,
 		rootUrl:    rooturl,
@@ -293,6 +332,10 @@ func (rm *restmanager) stop() {
 	rm.usersService.Shutdown()
 	rm.configService.Shutdown()
 	rm.authregService.Shutdown()
+	rm.auditService.Shutdown()
+	rm.sessionService.Shutdown()
+	rm.banService.Shutdown()
+	rm.approvalService.Shutdown()
 }
 
 func (rm *restmanager) register(rootpath string) *restful.Container {
@@ -300,7 +343,7 @@ func (rm *restmanager) register(rootpath string) *restful.Container {
 	rm.autherService = &auth.AutherService{Auth: rm.authimpl}
 	rm.autherService.Register(rootpath, c)
 
-	rm.usersService = &users.UsersService{Auth: rm.authimpl, Provider: rm.userimpl, Config: rm.configer}
+	rm.usersService = &users.UsersService{Auth: rm.authimpl, Provider: rm.userimpl, Config: rm.configer, Auditor: rm.auditor}
 	rm.usersService.Register(rootpath, c)
 
 	rm.configService = &configservice.ConfigService{Auth: rm.authimpl, Users: rm.userimpl, Config: rm.configer, Zone: zone}
@@ -309,6 +352,18 @@ func (rm *restmanager) register(rootpath string) *restful.Container {
 	rm.authregService = &oauth.AuthRegService{Auth: rm.authimpl, Users: rm.userimpl, Registry: rm.oauthreg}
 	rm.authregService.Register(rootpath, c)
 
+	rm.auditService = &auditservice.AuditService{Auth: rm.authimpl, Users: rm.userimpl, Auditor: rm.auditor}
+	rm.auditService.Register(rootpath, c)
+
+	rm.sessionService = &sessionservice.SessionService{Auth: rm.authimpl, Users: rm.userimpl, Registry: rm.registry}
+	rm.sessionService.Register(rootpath, c)
+
+	rm.banService = &banservice.BanService{Auth: rm.authimpl, Users: rm.userimpl, Bans: rm.bans}
+	rm.banService.Register(rootpath, c)
+
+	rm.approvalService = &approvalservice.ApprovalService{Auth: rm.authimpl, Users: rm.userimpl, Approvals: rm.approvals}
+	rm.approvalService.Register(rootpath, c)
+
 	rm.wsContainer = c
 	return c
 	//rm.ServeAndPublish(rootpath)
@@ -368,7 +423,7 @@ func main() {
 	root.PersistentFlags().BoolVar(&usecli, "usecli", true, "start a CLI with token auth")
 	provider.Flags().StringVar(&providerType, "providertype", "oauth", "type of the new provider")
 
-	root.AddCommand(cmdAdmins, versionCmd, serve, provider)
+	root.AddCommand(cmdAdmins, versionCmd, serve, provider, cmdRecordings, cmdSecrets)
 	viper.SetEnvPrefix("orca")
 	viper.SetDefault("etcd_machines", "http://localhost:4001")
 	viper.AutomaticEnv()
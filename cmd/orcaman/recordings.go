@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/clusterit/orca/recording"
+	"github.com/spf13/cobra"
+)
+
+var (
+	recordingsDir string
+	replaySpeed   float64
+	replayMaxWait int
+)
+
+var cmdRecordings = &cobra.Command{
+	Use:   "recordings",
+	Short: "list and replay recorded sessions",
+	Long:  "list and replay the sessions recorded by the gateway",
+	Run: func(cm *cobra.Command, args []string) {
+		cm.Help()
+	},
+}
+
+var cmdRecordingsList = &cobra.Command{
+	Use:   "list",
+	Short: "list all recorded sessions",
+	Long:  "list all recorded sessions with their session id",
+	Run: func(cm *cobra.Command, args []string) {
+		st, err := recording.NewDirStore(recordingsDir)
+		exitWhenError(err)
+		infos, err := st.List()
+		exitWhenError(err)
+		for _, inf := range infos {
+			fmt.Printf("%s\t%s\t%d\n", inf.Id, inf.Start.Format(time.RFC3339), inf.Size)
+		}
+	},
+}
+
+var cmdRecordingsReplay = &cobra.Command{
+	Use:   "replay [# session-id]",
+	Short: "replay a recorded session",
+	Long:  "replay all recordings of the given session id to the terminal",
+	Run: func(cm *cobra.Command, args []string) {
+		if len(args) < 1 {
+			cm.Usage()
+			os.Exit(1)
+		}
+		st, err := recording.NewDirStore(recordingsDir)
+		exitWhenError(err)
+		infos, err := recording.BySession(st, args[0])
+		exitWhenError(err)
+		for _, inf := range infos {
+			r, err := st.Open(inf.Id)
+			exitWhenError(err)
+			err = recording.Replay(r, os.Stdout, replaySpeed, time.Duration(replayMaxWait)*time.Second)
+			r.Close()
+			exitWhenError(err)
+		}
+	},
+}
+
+func init() {
+	cmdRecordings.PersistentFlags().StringVar(&recordingsDir, "recordings", "/var/lib/orca/recordings", "the directory where the gateway stores the recordings")
+	cmdRecordingsReplay.Flags().Float64Var(&replaySpeed, "speed", 1, "the replay speed")
+	cmdRecordingsReplay.Flags().IntVar(&replayMaxWait, "maxwait", 2, "the maximum pause in seconds between two frames, 0 means no limit")
+	cmdRecordings.AddCommand(cmdRecordingsList, cmdRecordingsReplay)
+}
+
+func exitWhenError(err error) {
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		os.Exit(1)
+	}
+}
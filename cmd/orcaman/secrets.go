@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/clusterit/orca/auth/oauth"
+	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/etcd"
+	uetcd "github.com/clusterit/orca/users/etcd"
+	"github.com/spf13/cobra"
+)
+
+var cmdSecrets = &cobra.Command{
+	Use:   "secrets",
+	Short: "manage the encryption of the secrets in etcd",
+	Long:  "create master keys, encrypt plaintext secrets and rotate the master key. The keys are read from ORCA_MASTER_KEY_FILE or ORCA_MASTER_KEY, the first key is the current one.",
+	Run: func(cm *cobra.Command, args []string) {
+		cm.Help()
+	},
+}
+
+var cmdSecretsGenkey = &cobra.Command{
+	Use:   "genkey",
+	Short: "create a new master key",
+	Long:  "print a new random master key for ORCA_MASTER_KEY_FILE or ORCA_MASTER_KEY",
+	Run: func(cm *cobra.Command, args []string) {
+		k, err := etcd.GenerateMasterKey()
+		exitWhenError(err)
+		fmt.Println(k)
+	},
+}
+
+var cmdSecretsMigrate = &cobra.Command{
+	Use:   "migrate",
+	Short: "encrypt the plaintext secrets",
+	Long:  "write all values with secrets again, so the secrets which were stored in plaintext are encrypted with the current master key",
+	Run: func(cm *cobra.Command, args []string) {
+		cc := keyedCluster()
+		for _, s := range []struct {
+			name string
+			seal func(*etcd.Cluster) (int, error)
+		}{
+			{"configuration", config.SealSecrets},
+			{"oauth providers", oauth.SealSecrets},
+			{"2FA secrets", uetcd.SealSecrets},
+		} {
+			n, err := s.seal(cc)
+			exitWhenError(err)
+			fmt.Printf("%s: %d values sealed\n", s.name, n)
+		}
+	},
+}
+
+var cmdSecretsRotate = &cobra.Command{
+	Use:   "rotate",
+	Short: "wrap the secrets with the current master key",
+	Long:  "wrap the data keys of all secrets with the current master key. Put the new key in front of the old ones, restart the gateways and managers and rotate; afterwards the old keys can be removed.",
+	Run: func(cm *cobra.Command, args []string) {
+		cc := keyedCluster()
+		n, err := cc.RewrapSecrets()
+		fmt.Printf("%d values rewrapped with master key %s\n", n, cc.Keyring().Current())
+		exitWhenError(err)
+	},
+}
+
+// connect to the cluster, a master key is required
+func keyedCluster() *etcd.Cluster {
+	cc, _, err := connect(etcdConfig, etcdKey, etcdCert, etcdCa)
+	exitWhenError(err)
+	if cc.Keyring() == nil {
+		exitWhenError(fmt.Errorf("no master key, set ORCA_MASTER_KEY_FILE or ORCA_MASTER_KEY"))
+	}
+	return cc
+}
+
+func init() {
+	cmdSecrets.AddCommand(cmdSecretsGenkey, cmdSecretsMigrate, cmdSecretsRotate)
+}
@@ -4,8 +4,8 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
-	"encoding/json"
 	"encoding/pem"
+	"fmt"
 	"time"
 
 	"github.com/clusterit/orca/common"
@@ -16,15 +16,96 @@ import (
 )
 
 type Gateway struct {
-	DefaultHost     string   `json:"defaulthost"`
-	Force2FA        bool     `json:"force2fa"`
-	HostKey         string   `json:"hostkey"`
-	LogLevel        string   `json:"loglevel"`
-	CheckAllow      bool     `json:"checkAllow"`
-	MaxAutologin2FA int      `json:"maxautologin2fa"`
-	AllowedCidrs    []string `json:"allowedcidrs"`
-	DeniedCidrs     []string `json:"deniedcidrs"`
-	AllowDeny       bool     `json:"allowdeny"`
+	DefaultHost      string                 `json:"defaulthost"`
+	Force2FA         bool                   `json:"force2fa"`
+	HostKey          string                 `json:"hostkey" secret:"true"`
+	HostKeys         []string               `json:"hostkeys" secret:"true"`
+	NextHostKeys     []string               `json:"nexthostkeys" secret:"true"`
+	LogLevel         string                 `json:"loglevel"`
+	CheckAllow       bool                   `json:"checkAllow"`
+	MaxAutologin2FA  int                    `json:"maxautologin2fa"`
+	AllowedCidrs     []string               `json:"allowedcidrs"`
+	DeniedCidrs      []string               `json:"deniedcidrs"`
+	AllowDeny        bool                   `json:"allowdeny"`
+	Recording        bool                   `json:"recording"`
+	RecordInput      bool                   `json:"recordinput"`
+	BackendAuth      string                 `json:"backendauth"`
+	CAKey            string                 `json:"cakey" secret:"true"`
+	CertValidity     int                    `json:"certvalidity"`
+	HostKeyCheck     string                 `json:"hostkeycheck"`
+	HostCAKeys       []string               `json:"hostcakeys"`
+	Address          string                 `json:"address"`
+	RemoteForward    string                 `json:"remoteforward"`
+	ForwardPorts     []int                  `json:"forwardports"`
+	Tunnels          []string               `json:"tunnels"`
+	SftpAudit        bool                   `json:"sftpaudit"`
+	SftpReadOnly     bool                   `json:"sftpreadonly"`
+	SftpPrefixes     []string               `json:"sftpprefixes"`
+	IdleTimeout      int                    `json:"idletimeout"`
+	MaxSessionTime   int                    `json:"maxsessiontime"`
+	HandshakeTimeout int                    `json:"handshaketimeout"`
+	RoleTimeouts     map[string]Timeouts    `json:"roletimeouts"`
+	DrainTime        int                    `json:"draintime"`
+	ConnRate         int                    `json:"connrate"`
+	MaxAuthFailures  int                    `json:"maxauthfailures"`
+	BanTime          int                    `json:"bantime"`
+	MaxBanTime       int                    `json:"maxbantime"`
+	ClientRules      ClientRules            `json:"clientrules"`
+	RoleClientRules  map[string]ClientRules `json:"roleclientrules"`
+	TrustedProxies   []string               `json:"trustedproxies"`
+	RoleSecurityKeys map[string]string      `json:"rolesecuritykeys"`
+	PushApproval     bool                   `json:"pushapproval"`
+	ApprovalTimeout  int                    `json:"approvaltimeout"`
+}
+
+// Rules for the source addresses of the clients with the semantics of
+// AllowDeny: with AllowDeny an address must be allowed and not denied,
+// otherwise an address is allowed unless it is denied and not allowed.
+type ClientRules struct {
+	AllowedCidrs []string `json:"allowedcidrs"`
+	DeniedCidrs  []string `json:"deniedcidrs"`
+	AllowDeny    bool     `json:"allowdeny"`
+}
+
+// The methods a gateway uses to authenticate at the backends. With
+// BackendAuthCA the gateway signs a short lived certificate with the
+// CAKey of the zone, BackendAuthAgent uses the forwarded agent of the
+// client.
+const (
+	BackendAuthAgent = "agent"
+	BackendAuthCA    = "ca"
+	BackendAuthBoth  = "both"
+
+	DefaultCertValidity = 300
+)
+
+// Returns true if the gateway should sign certificates for the backends.
+func (gw *Gateway) UseCA() bool {
+	return gw.BackendAuth == BackendAuthCA || gw.BackendAuth == BackendAuthBoth
+}
+
+// Returns true if the gateway should use the forwarded agent of the client.
+func (gw *Gateway) UseAgent() bool {
+	return gw.BackendAuth != BackendAuthCA
+}
+
+// Returns true if the gateway must decode the SFTP and SCP transfers,
+// either to audit them or to enforce the read-only or prefix policy.
+func (gw *Gateway) SftpProxy() bool {
+	return gw.SftpAudit || gw.SftpReadOnly || len(gw.SftpPrefixes) > 0
+}
+
+// The public key of the CA in authorized_keys format, usable for the
+// TrustedUserCAKeys of the backends.
+func (gw *Gateway) CAPublicKey() (string, error) {
+	if gw.CAKey == "" {
+		return "", common.ErrNotFound
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(gw.CAKey))
+	if err != nil {
+		return "", err
+	}
+	return string(ssh.MarshalAuthorizedKey(signer.PublicKey())), nil
 }
 
 type NewGateway <-chan Gateway
@@ -32,7 +113,7 @@ type NewGateway <-chan Gateway
 type Stop chan bool
 
 type ClusterConfig struct {
-	Key          string `json:"key"`
+	Key          string `json:"key" secret:"true"`
 	Name         string `json:"name"`
 	SelfRegister bool   `json:"selfregister"`
 }
@@ -49,6 +130,18 @@ type Configer interface {
 	PutGateway(zone string, gw Gateway) error
 	GetGateway(zone string) (*Gateway, error)
 	Gateway(zone string) (NewGateway, Stop, error)
+	Policies(zone string) ([]Policy, error)
+	PutPolicy(zone string, p Policy) (*Policy, error)
+	DropPolicy(zone, id string) error
+	KnownHosts(zone string) ([]KnownHost, error)
+	KnownHost(zone, host string) (*KnownHost, error)
+	PinHostKey(zone, host, key string) (*KnownHost, error)
+	RevokeHostKey(zone, host, key string) (*KnownHost, error)
+	ImportKnownHosts(zone string, data []byte) ([]KnownHost, error)
+	Hosts(zone string) ([]Host, error)
+	Host(zone, name string) (*Host, error)
+	PutHost(zone string, h Host) (*Host, error)
+	DropHost(zone, name string) error
 }
 
 type etcdConfig struct {
@@ -93,10 +186,17 @@ func (e *etcdConfig) DropZone(zone string) error {
 }
 
 func (e *etcdConfig) PutGateway(zone string, gw Gateway) error {
-	_, err := ssh.ParsePrivateKey([]byte(gw.HostKey))
-	if err != nil {
+	if err := gw.CheckHostKeys(); err != nil {
+		return err
+	}
+	if err := gw.CheckSecurityKeys(); err != nil {
 		return err
 	}
+	if gw.CAKey != "" {
+		if _, err := ssh.ParsePrivateKey([]byte(gw.CAKey)); err != nil {
+			return fmt.Errorf("illegal CA key: %s", err)
+		}
+	}
 	return e.persister.Put(e.pt(zone, "gateway"), gw)
 }
 
@@ -118,9 +218,8 @@ func (e *etcdConfig) ClusterConfig() (NewClusterConfig, Stop, error) {
 			select {
 			case r := <-etcrsp:
 				if r != nil && r.Node != nil {
-					val := []byte(r.Node.Value)
 					var cc ClusterConfig
-					if err := json.Unmarshal(val, &cc); err == nil {
+					if err := e.persister.Decode(r.Node.Value, &cc); err == nil {
 						cchan <- cc
 					}
 				}
@@ -145,9 +244,8 @@ func (e *etcdConfig) Gateway(zone string) (NewGateway, Stop, error) {
 			select {
 			case r := <-etcrsp:
 				if r != nil && r.Node != nil {
-					val := []byte(r.Node.Value)
 					var gw Gateway
-					if err := json.Unmarshal(val, &gw); err == nil {
+					if err := e.persister.Decode(r.Node.Value, &gw); err == nil {
 						gwchan <- gw
 					}
 				}
@@ -160,21 +258,47 @@ func (e *etcdConfig) Gateway(zone string) (NewGateway, Stop, error) {
 }
 
 func GenerateGateway() (*Gateway, error) {
-	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	hostkey, err := generateKey()
 	if err != nil {
 		return nil, err
 	}
-	data := pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(pk)}
+	cakey, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+	var hostkeys []string
+	for _, alg := range []string{HostKeyEd25519, HostKeyECDSA} {
+		k, err := GenerateHostKey(alg)
+		if err != nil {
+			return nil, err
+		}
+		hostkeys = append(hostkeys, k)
+	}
 	return &Gateway{
-		HostKey:      string(pem.EncodeToMemory(&data)),
-		LogLevel:     logging.Debug,
-		CheckAllow:   true,
-		AllowDeny:    true,
-		AllowedCidrs: []string{"0.0.0.0/0"},
-		DeniedCidrs:  []string{"127.0.0.1/8"},
+		HostKey:       hostkey,
+		HostKeys:      hostkeys,
+		CAKey:         cakey,
+		BackendAuth:   BackendAuthAgent,
+		CertValidity:  DefaultCertValidity,
+		HostKeyCheck:  HostKeyCheckTofu,
+		RemoteForward: RemoteForwardDisabled,
+		LogLevel:      logging.Debug,
+		CheckAllow:    true,
+		AllowDeny:     true,
+		AllowedCidrs:  []string{"0.0.0.0/0"},
+		DeniedCidrs:   []string{"127.0.0.1/8"},
 	}, nil
 }
 
+func generateKey() (string, error) {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", err
+	}
+	data := pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(pk)}
+	return string(pem.EncodeToMemory(&data)), nil
+}
+
 func GenerateCluster(name string, selfreg bool) (*ClusterConfig, error) {
 	pk, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
@@ -210,3 +334,42 @@ func InitZone(cfger Configer, zone string, createGateway bool) (*Gateway, error)
 
 	return myGateway, nil
 }
+
+// Write the cluster config and the gateways of all zones again, so their
+// plaintext secrets are sealed with the keyring of the cluster. Returns
+// the number of written values.
+func SealSecrets(cl *etcd.Cluster) (int, error) {
+	cfger, err := New(cl)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	cc, err := cfger.Cluster()
+	if err == nil {
+		if _, err = cfger.UpdateCluster(*cc); err != nil {
+			return count, err
+		}
+		count++
+	} else if !common.IsNotFound(err) {
+		return count, err
+	}
+	zones, err := cfger.Zones()
+	if common.IsNotFound(err) {
+		return count, nil
+	} else if err != nil {
+		return count, err
+	}
+	for _, z := range zones {
+		gw, err := cfger.GetGateway(z)
+		if common.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return count, err
+		}
+		if err := cfger.PutGateway(z, *gw); err != nil {
+			return count, fmt.Errorf("zone %s: %s", z, err)
+		}
+		count++
+	}
+	return count, nil
+}
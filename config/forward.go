@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// The modes for remote port forwarding (ssh -R) of a zone. With
+// RemoteForwardLoopback the backends may only listen on a loopback
+// address, with RemoteForwardPorts only the ForwardPorts are allowed. An
+// empty mode disables remote forwarding.
+const (
+	RemoteForwardDisabled = "disabled"
+	RemoteForwardLoopback = "loopback"
+	RemoteForwardPorts    = "ports"
+)
+
+// Check if the zone allows a backend to listen on the given address and
+// port for a remote forwarding.
+func (gw *Gateway) CheckRemoteForward(addr string, port uint32) error {
+	switch gw.RemoteForward {
+	case RemoteForwardLoopback:
+		if addr == "localhost" {
+			return nil
+		}
+		if ip := net.ParseIP(addr); ip != nil && ip.IsLoopback() {
+			return nil
+		}
+		return fmt.Errorf("remote forwarding is only allowed on loopback addresses, not on %q", addr)
+	case RemoteForwardPorts:
+		for _, p := range gw.ForwardPorts {
+			if uint32(p) == port {
+				return nil
+			}
+		}
+		return fmt.Errorf("remote forwarding of port %d is not allowed", port)
+	}
+	return fmt.Errorf("remote forwarding is disabled")
+}
@@ -0,0 +1,46 @@
+package config
+
+import "testing"
+
+func TestCheckRemoteForward(t *testing.T) {
+	type fwd struct {
+		addr string
+		port uint32
+	}
+	tests := []struct {
+		gw      Gateway
+		allowed []fwd
+		denied  []fwd
+	}{
+		{
+			gw:     Gateway{},
+			denied: []fwd{{"localhost", 8080}, {"", 22}},
+		},
+		{
+			gw:     Gateway{RemoteForward: RemoteForwardDisabled},
+			denied: []fwd{{"localhost", 8080}},
+		},
+		{
+			gw:      Gateway{RemoteForward: RemoteForwardLoopback},
+			allowed: []fwd{{"localhost", 8080}, {"127.0.0.1", 0}, {"::1", 22}},
+			denied:  []fwd{{"", 8080}, {"0.0.0.0", 8080}, {"10.0.0.1", 8080}, {"*", 8080}},
+		},
+		{
+			gw:      Gateway{RemoteForward: RemoteForwardPorts, ForwardPorts: []int{8080, 9090}},
+			allowed: []fwd{{"localhost", 8080}, {"", 9090}},
+			denied:  []fwd{{"localhost", 22}, {"localhost", 0}},
+		},
+	}
+	for _, tst := range tests {
+		for _, f := range tst.allowed {
+			if err := tst.gw.CheckRemoteForward(f.addr, f.port); err != nil {
+				t.Errorf("%s:%d should be allowed in mode %q: %s", f.addr, f.port, tst.gw.RemoteForward, err)
+			}
+		}
+		for _, f := range tst.denied {
+			if err := tst.gw.CheckRemoteForward(f.addr, f.port); err == nil {
+				t.Errorf("%s:%d should be denied in mode %q", f.addr, f.port, tst.gw.RemoteForward)
+			}
+		}
+	}
+}
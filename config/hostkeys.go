@@ -0,0 +1,121 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The algorithms of the host keys a gateway generates.
+const (
+	HostKeyRSA     = "rsa"
+	HostKeyECDSA   = "ecdsa"
+	HostKeyEd25519 = "ed25519"
+
+	hostKeyRSABits = 3072
+)
+
+var DefaultHostKeyAlgorithms = []string{HostKeyEd25519, HostKeyECDSA, HostKeyRSA}
+
+// Generate a new host key in PEM format.
+func GenerateHostKey(alg string) (string, error) {
+	var blk *pem.Block
+	switch alg {
+	case HostKeyRSA:
+		pk, err := rsa.GenerateKey(rand.Reader, hostKeyRSABits)
+		if err != nil {
+			return "", err
+		}
+		blk = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(pk)}
+	case HostKeyECDSA:
+		pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		b, err := x509.MarshalECPrivateKey(pk)
+		if err != nil {
+			return "", err
+		}
+		blk = &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}
+	case HostKeyEd25519:
+		_, pk, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		b, err := x509.MarshalPKCS8PrivateKey(pk)
+		if err != nil {
+			return "", err
+		}
+		blk = &pem.Block{Type: "PRIVATE KEY", Bytes: b}
+	default:
+		return "", fmt.Errorf("unknown host key algorithm %q", alg)
+	}
+	return string(pem.EncodeToMemory(blk)), nil
+}
+
+// The host keys the gateway uses, the HostKey and the other HostKeys.
+func (gw *Gateway) ActiveHostKeys() []string {
+	var res []string
+	if gw.HostKey != "" {
+		res = append(res, gw.HostKey)
+	}
+	return append(res, gw.HostKeys...)
+}
+
+// Check that there is an active host key and that all keys can be parsed.
+func (gw *Gateway) CheckHostKeys() error {
+	if len(gw.ActiveHostKeys()) == 0 {
+		return fmt.Errorf("the gateway has no host key")
+	}
+	for _, k := range append(gw.ActiveHostKeys(), gw.NextHostKeys...) {
+		if _, err := ssh.ParsePrivateKey([]byte(k)); err != nil {
+			return fmt.Errorf("illegal host key: %s", err)
+		}
+	}
+	return nil
+}
+
+// Generate the next host keys. The gateway announces them to the clients
+// until they are activated.
+func (gw *Gateway) PrepareHostKeys(algs []string) error {
+	var next []string
+	for _, a := range algs {
+		k, err := GenerateHostKey(a)
+		if err != nil {
+			return err
+		}
+		next = append(next, k)
+	}
+	gw.NextHostKeys = next
+	return nil
+}
+
+// Replace the active host keys with the next host keys. An RSA key
+// becomes the HostKey.
+func (gw *Gateway) ActivateHostKeys() error {
+	if len(gw.NextHostKeys) == 0 {
+		return fmt.Errorf("there are no next host keys, prepare them first")
+	}
+	gw.HostKey = ""
+	gw.HostKeys = nil
+	for _, k := range gw.NextHostKeys {
+		s, err := ssh.ParsePrivateKey([]byte(k))
+		if err != nil {
+			return fmt.Errorf("illegal host key: %s", err)
+		}
+		if gw.HostKey == "" && s.PublicKey().Type() == ssh.KeyAlgoRSA {
+			gw.HostKey = k
+		} else {
+			gw.HostKeys = append(gw.HostKeys, k)
+		}
+	}
+	gw.NextHostKeys = nil
+	return nil
+}
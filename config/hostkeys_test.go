@@ -0,0 +1,60 @@
+package config
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerateHostKey(t *testing.T) {
+	types := map[string]string{
+		HostKeyRSA:     ssh.KeyAlgoRSA,
+		HostKeyECDSA:   ssh.KeyAlgoECDSA256,
+		HostKeyEd25519: ssh.KeyAlgoED25519,
+	}
+	for alg, tp := range types {
+		k, err := GenerateHostKey(alg)
+		if err != nil {
+			t.Fatalf("%s: %s", alg, err)
+		}
+		s, err := ssh.ParsePrivateKey([]byte(k))
+		if err != nil {
+			t.Fatalf("%s: the key cannot be parsed: %s", alg, err)
+		}
+		if s.PublicKey().Type() != tp {
+			t.Errorf("%s: the key should be %s, not %s", alg, tp, s.PublicKey().Type())
+		}
+	}
+	if _, err := GenerateHostKey("dsa"); err == nil {
+		t.Errorf("dsa should not be supported")
+	}
+}
+
+func TestRotateHostKeys(t *testing.T) {
+	old, _ := GenerateHostKey(HostKeyEd25519)
+	gw := Gateway{HostKeys: []string{old}}
+	if err := gw.ActivateHostKeys(); err == nil {
+		t.Errorf("without next keys nothing can be activated")
+	}
+	if err := gw.PrepareHostKeys([]string{HostKeyEd25519, HostKeyRSA}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.CheckHostKeys(); err != nil {
+		t.Errorf("the keys should be valid: %s", err)
+	}
+	if len(gw.ActiveHostKeys()) != 1 || len(gw.NextHostKeys) != 2 {
+		t.Errorf("the next keys should not be active yet")
+	}
+	next := gw.NextHostKeys
+	if err := gw.ActivateHostKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if gw.HostKey != next[1] || len(gw.HostKeys) != 1 || gw.HostKeys[0] != next[0] || gw.NextHostKeys != nil {
+		t.Errorf("the next keys should be active, the rsa key as HostKey")
+	}
+	gw.HostKeys = nil
+	gw.HostKey = ""
+	if err := gw.CheckHostKeys(); err == nil {
+		t.Errorf("a gateway without host keys is not valid")
+	}
+}
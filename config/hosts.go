@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/clusterit/orca/common"
+)
+
+const (
+	DefaultSSHPort = 22
+	// the prefix of a target which selects the hosts with a tag
+	TagPrefix = "tag:"
+)
+
+// A backend in the host inventory of a zone. The Name is an alias which
+// can be used as the target of a login, the User is the remote user if
+// the login does not contain one.
+type Host struct {
+	Name    string   `json:"name"`
+	Address string   `json:"address"`
+	Port    int      `json:"port,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Team    string   `json:"team,omitempty"`
+	User    string   `json:"user,omitempty"`
+}
+
+// The address of the host with the port to dial.
+func (h *Host) Addr() string {
+	p := h.Port
+	if p == 0 {
+		p = DefaultSSHPort
+	}
+	return net.JoinHostPort(h.Address, strconv.Itoa(p))
+}
+
+func (h *Host) HasTag(tag string) bool {
+	for _, t := range h.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *etcdConfig) Hosts(zone string) ([]Host, error) {
+	var res []Host
+	err := e.persister.Chdir(e.pt(zone, "hosts")).GetAll(true, false, &res)
+	if common.IsNotFound(err) {
+		return nil, nil
+	}
+	return res, err
+}
+
+func (e *etcdConfig) Host(zone, name string) (*Host, error) {
+	var res Host
+	return &res, e.persister.Get(e.pt(zone, "hosts/"+name), &res)
+}
+
+func (e *etcdConfig) PutHost(zone string, h Host) (*Host, error) {
+	if h.Name == "" {
+		return nil, fmt.Errorf("a host needs a name")
+	}
+	if strings.ContainsAny(h.Name, "/@:%") {
+		return nil, fmt.Errorf("the name of a host must not contain '/', '@', ':' or '%%'")
+	}
+	if h.Address == "" {
+		h.Address = h.Name
+	}
+	if h.Port < 0 || h.Port > 65535 {
+		return nil, fmt.Errorf("illegal port: %d", h.Port)
+	}
+	return &h, e.persister.Put(e.pt(zone, "hosts/"+h.Name), h)
+}
+
+func (e *etcdConfig) DropHost(zone, name string) error {
+	return e.persister.Remove(e.pt(zone, "hosts/"+name))
+}
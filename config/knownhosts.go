@@ -0,0 +1,187 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/clusterit/orca/common"
+	"golang.org/x/crypto/ssh"
+)
+
+// The modes to verify the host keys of the backends. With
+// HostKeyCheckTofu the first key of a backend is stored and every other
+// key is rejected. HostKeyCheckStrict only accepts pinned keys and
+// HostKeyCheckCA accepts certificates which are signed by one of the
+// HostCAKeys of the gateway and pinned keys as a fallback.
+const (
+	HostKeyCheckTofu   = "tofu"
+	HostKeyCheckStrict = "strict"
+	HostKeyCheckCA     = "ca"
+)
+
+// The known keys of a backend. The host is the normalized address in the
+// format of OpenSSH's known_hosts: a plain hostname for port 22,
+// otherwise [host]:port.
+type KnownHost struct {
+	Host    string   `json:"host"`
+	Keys    []string `json:"keys"`
+	Revoked []string `json:"revoked"`
+}
+
+// Returns true if the key is one of the pinned keys.
+func (kh *KnownHost) Knows(key ssh.PublicKey) bool {
+	return contains(kh.Keys, authorizedKey(key))
+}
+
+// Returns true if the key is revoked.
+func (kh *KnownHost) IsRevoked(key ssh.PublicKey) bool {
+	return contains(kh.Revoked, authorizedKey(key))
+}
+
+// Normalize an address in the format host:port to a known hosts name.
+func NormalizeHost(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	if port == "22" {
+		return host
+	}
+	return "[" + host + "]:" + port
+}
+
+func (e *etcdConfig) knownHostPath(zone, host string) string {
+	k := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, host)
+	return e.pt(zone, "knownhosts/"+k)
+}
+
+func (e *etcdConfig) KnownHosts(zone string) ([]KnownHost, error) {
+	var res []KnownHost
+	err := e.persister.Chdir(e.pt(zone, "knownhosts")).GetAll(true, false, &res)
+	if common.IsNotFound(err) {
+		return nil, nil
+	}
+	return res, err
+}
+
+func (e *etcdConfig) KnownHost(zone, host string) (*KnownHost, error) {
+	var res KnownHost
+	return &res, e.persister.Get(e.knownHostPath(zone, host), &res)
+}
+
+func (e *etcdConfig) knownHost(zone, host string) (*KnownHost, error) {
+	kh, err := e.KnownHost(zone, host)
+	if common.IsNotFound(err) {
+		return &KnownHost{Host: host}, nil
+	}
+	return kh, err
+}
+
+func (e *etcdConfig) PinHostKey(zone, host, key string) (*KnownHost, error) {
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	kh, err := e.knownHost(zone, host)
+	if err != nil {
+		return nil, err
+	}
+	k := authorizedKey(pk)
+	kh.Keys = insert(kh.Keys, k)
+	kh.Revoked = remove(kh.Revoked, k)
+	return kh, e.persister.Put(e.knownHostPath(zone, host), kh)
+}
+
+func (e *etcdConfig) RevokeHostKey(zone, host, key string) (*KnownHost, error) {
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	kh, err := e.knownHost(zone, host)
+	if err != nil {
+		return nil, err
+	}
+	k := authorizedKey(pk)
+	kh.Keys = remove(kh.Keys, k)
+	kh.Revoked = insert(kh.Revoked, k)
+	return kh, e.persister.Put(e.knownHostPath(zone, host), kh)
+}
+
+// Import the content of an OpenSSH known_hosts file. Hashed hostnames and
+// @cert-authority lines cannot be imported and are skipped, @revoked
+// lines revoke the key.
+func (e *etcdConfig) ImportKnownHosts(zone string, data []byte) ([]KnownHost, error) {
+	hosts := make(map[string]bool)
+	for len(bytes.TrimSpace(data)) > 0 {
+		marker, names, pk, _, rest, err := ssh.ParseKnownHosts(data)
+		if err != nil {
+			return nil, err
+		}
+		data = rest
+		if marker == "cert-authority" {
+			continue
+		}
+		k := string(ssh.MarshalAuthorizedKey(pk))
+		for _, n := range names {
+			if strings.HasPrefix(n, "|") || strings.ContainsAny(n, "*?!") {
+				continue
+			}
+			if marker == "revoked" {
+				_, err = e.RevokeHostKey(zone, n, k)
+			} else {
+				_, err = e.PinHostKey(zone, n, k)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("cannot import key for %s: %s", n, err)
+			}
+			hosts[n] = true
+		}
+	}
+	var res []KnownHost
+	for h := range hosts {
+		kh, err := e.KnownHost(zone, h)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *kh)
+	}
+	return res, nil
+}
+
+func authorizedKey(k ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k)))
+}
+
+func contains(ar []string, s string) bool {
+	for _, a := range ar {
+		if a == s {
+			return true
+		}
+	}
+	return false
+}
+
+func insert(ar []string, s string) []string {
+	if contains(ar, s) {
+		return ar
+	}
+	return append(ar, s)
+}
+
+func remove(ar []string, s string) []string {
+	var res []string
+	for _, a := range ar {
+		if a != s {
+			res = append(res, a)
+		}
+	}
+	return res
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	testHostKey = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQDyLg8zuWzJOgcTru78NkhhDsa+tjasjrJGoJbhBRMHrxgwdgUF5ZKGsV2LWTZgp8rIDUjHRGWSlvTrpXCG33wmRJrXwxYG3J0QeOAYRlMD3ESBVtPWm2iqA02PzpL7+mnmV79Ml3Q8yUz8Ef5Bs+lytVAw42IhfTEfJyWM9zsjFEW/NvZ6cttrOUhwEQ1r9HvY0UDyHRA3sW0B3I2KfYg1Z1e5wlKDd7dGI9u/S9E9JwFpeh/AXjPiN/Vd2xInIh99G9HsWBdpTaNlYXZj6Qnx/wLcCm2v7U9WdIvM5M+xqiYZ6pxGUtsBDgBjraxh8tRWV3eab3stZsKnwQthyp4P"
+)
+
+func TestNormalizeHost(t *testing.T) {
+	tests := map[string]string{
+		"db1:22":         "db1",
+		"db1:2222":       "[db1]:2222",
+		"10.0.0.1:22":    "10.0.0.1",
+		"[fe80::1]:2222": "[fe80::1]:2222",
+		"db1":            "db1",
+	}
+	for in, out := range tests {
+		if n := NormalizeHost(in); n != out {
+			t.Errorf("%s should be normalized to %s, not %s", in, out, n)
+		}
+	}
+}
+
+func TestKnownHost(t *testing.T) {
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(testHostKey + " root@db1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kh := KnownHost{Host: "db1"}
+	if kh.Knows(pk) || kh.IsRevoked(pk) {
+		t.Errorf("empty known host should not know any key")
+	}
+	kh.Keys = insert(kh.Keys, authorizedKey(pk))
+	if !kh.Knows(pk) {
+		t.Errorf("pinned key should be known")
+	}
+	kh.Keys = remove(kh.Keys, authorizedKey(pk))
+	kh.Revoked = insert(kh.Revoked, authorizedKey(pk))
+	if kh.Knows(pk) || !kh.IsRevoked(pk) {
+		t.Errorf("key should be revoked")
+	}
+}
@@ -0,0 +1,57 @@
+package config
+
+import "time"
+
+// The default limits of a zone.
+const (
+	DefaultConnRate        = 30
+	DefaultMaxAuthFailures = 5
+	DefaultBanTime         = 300
+	DefaultMaxBanTime      = 24 * 60 * 60
+)
+
+// Limits protect a gateway against floods and brute force attacks.
+// ConnRate is the number of connections a source address may open per
+// minute, after MaxAuthFailures failed logins in a row the address or the
+// user is banned for BanTime seconds. Every further ban doubles the time
+// up to MaxBanTime seconds.
+type Limits struct {
+	ConnRate        int
+	MaxAuthFailures int
+	BanTime         int
+	MaxBanTime      int
+}
+
+func orDefault(v, def int) int {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+// The limits of the zone, unset values have their defaults.
+func (gw *Gateway) Limits() Limits {
+	l := Limits{
+		ConnRate:        orDefault(gw.ConnRate, DefaultConnRate),
+		MaxAuthFailures: orDefault(gw.MaxAuthFailures, DefaultMaxAuthFailures),
+		BanTime:         orDefault(gw.BanTime, DefaultBanTime),
+		MaxBanTime:      orDefault(gw.MaxBanTime, DefaultMaxBanTime),
+	}
+	if l.MaxBanTime < l.BanTime {
+		l.MaxBanTime = l.BanTime
+	}
+	return l
+}
+
+// The duration of the n-th ban in a row.
+func (l Limits) BanDuration(n int) time.Duration {
+	d := time.Duration(l.BanTime) * time.Second
+	max := time.Duration(l.MaxBanTime) * time.Second
+	for i := 1; i < n && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
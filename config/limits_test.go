@@ -0,0 +1,32 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	var gw Gateway
+	l := gw.Limits()
+	if l.ConnRate != DefaultConnRate || l.MaxAuthFailures != DefaultMaxAuthFailures || l.BanTime != DefaultBanTime || l.MaxBanTime != DefaultMaxBanTime {
+		t.Errorf("unset limits should have their defaults: %+v", l)
+	}
+	gw.BanTime = 60
+	gw.MaxBanTime = 300
+	l = gw.Limits()
+	tests := []struct {
+		n        int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{4, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+	for _, tst := range tests {
+		if d := l.BanDuration(tst.n); d != tst.expected {
+			t.Errorf("ban %d should last %s, not %s", tst.n, tst.expected, d)
+		}
+	}
+}
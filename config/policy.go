@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/clusterit/orca/common"
+)
+
+// The channel types which can be granted by a policy.
+const (
+	ChannelShell          = "shell"
+	ChannelExec           = "exec"
+	ChannelSubsystem      = "subsystem"
+	ChannelDirectTcpip    = "direct-tcpip"
+	ChannelForwardedTcpip = "forwarded-tcpip"
+	ChannelX11            = "x11"
+)
+
+// A Policy grants the listed users and the users with one of the listed
+// roles access to backends. If there is no policy in a zone, every
+// user may reach every backend which is allowed by the CIDR rules of the
+// gateway. As soon as there is one policy, a user needs a matching policy
+// to connect.
+//
+// The Hosts can be glob patterns (db*.intranet) or CIDRs (10.1.0.0/16),
+// the RemoteUsers are glob patterns. Empty lists of RemoteUsers or
+// Channels allow every remote user or channel type. A policy without
+// Users and Roles applies to everyone.
+//
+// The Tunnels are the destinations of direct-tcpip channels (ssh -L/-W)
+// in the form host:port, the host can be a glob pattern or a CIDR, the
+// port a number, a range (8000-8999) or *.
+type Policy struct {
+	Id          string   `json:"id"`
+	Users       []string `json:"users"`
+	Roles       []string `json:"roles"`
+	Hosts       []string `json:"hosts"`
+	RemoteUsers []string `json:"remoteusers"`
+	Channels    []string `json:"channels"`
+	Tunnels     []string `json:"tunnels"`
+}
+
+func (e *etcdConfig) Policies(zone string) ([]Policy, error) {
+	var res []Policy
+	err := e.persister.Chdir(e.pt(zone, "policies")).GetAll(true, false, &res)
+	if common.IsNotFound(err) {
+		return nil, nil
+	}
+	return res, err
+}
+
+// the id is a part of the key in etcd
+func checkPolicyId(id string) error {
+	if strings.Contains(id, "/") || strings.Contains(id, "..") {
+		return fmt.Errorf("the id of a policy must not contain '/' or '..'")
+	}
+	return nil
+}
+
+func (e *etcdConfig) PutPolicy(zone string, p Policy) (*Policy, error) {
+	if p.Id == "" {
+		p.Id = common.GenerateUUID()
+	}
+	if err := checkPolicyId(p.Id); err != nil {
+		return nil, err
+	}
+	return &p, e.persister.Put(e.pt(zone, "policies/"+p.Id), p)
+}
+
+func (e *etcdConfig) DropPolicy(zone, id string) error {
+	if err := checkPolicyId(id); err != nil {
+		return err
+	}
+	return e.persister.Remove(e.pt(zone, "policies/"+id))
+}
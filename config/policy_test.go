@@ -0,0 +1,20 @@
+package config
+
+import "testing"
+
+func TestPolicyId(t *testing.T) {
+	e := &etcdConfig{}
+	for _, id := range []string{"../users", "a/b", "..", "/dba"} {
+		if _, err := e.PutPolicy("intranet", Policy{Id: id}); err == nil {
+			t.Errorf("the policy id %q should be refused", id)
+		}
+		if err := e.DropPolicy("intranet", id); err == nil {
+			t.Errorf("the policy id %q should be refused when dropping", id)
+		}
+	}
+	for _, id := range []string{"dba", "ops-team", "v1.2"} {
+		if err := checkPolicyId(id); err != nil {
+			t.Errorf("the policy id %q should be allowed: %s", id, err)
+		}
+	}
+}
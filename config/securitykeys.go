@@ -0,0 +1,39 @@
+package config
+
+import "fmt"
+
+// The flags a role can require from the security key of a user. A
+// security key is a FIDO2 authenticator with an sk-ssh-ed25519 or
+// sk-ecdsa-sha2-nistp256 key, presence means the user has touched it,
+// verification that the authenticator has also checked a PIN or a
+// fingerprint.
+const (
+	KeyPresence     = "presence"
+	KeyVerification = "verification"
+)
+
+// Returns an error if a role requires unknown flags.
+func (gw *Gateway) CheckSecurityKeys() error {
+	for r, f := range gw.RoleSecurityKeys {
+		if f != KeyPresence && f != KeyVerification {
+			return fmt.Errorf("illegal security key flags %q for role %s, use %s or %s", f, r, KeyPresence, KeyVerification)
+		}
+	}
+	return nil
+}
+
+// The flags a user with the given roles needs, the strongest requirement
+// of the roles wins. Returns an empty string if the user can login with
+// any key.
+func (gw *Gateway) SecurityKeyFor(roles []string) string {
+	res := ""
+	for _, r := range roles {
+		switch gw.RoleSecurityKeys[r] {
+		case KeyVerification:
+			return KeyVerification
+		case KeyPresence:
+			res = KeyPresence
+		}
+	}
+	return res
+}
@@ -0,0 +1,29 @@
+package config
+
+import "testing"
+
+func TestSecurityKeyFor(t *testing.T) {
+	gw := Gateway{RoleSecurityKeys: map[string]string{"ops": KeyPresence, "dba": KeyVerification}}
+	tests := []struct {
+		roles    []string
+		expected string
+	}{
+		{nil, ""},
+		{[]string{"USER"}, ""},
+		{[]string{"USER", "ops"}, KeyPresence},
+		{[]string{"ops", "dba"}, KeyVerification},
+		{[]string{"dba", "ops"}, KeyVerification},
+	}
+	for _, tst := range tests {
+		if f := gw.SecurityKeyFor(tst.roles); f != tst.expected {
+			t.Errorf("roles %v should need %q, not %q", tst.roles, tst.expected, f)
+		}
+	}
+	if err := gw.CheckSecurityKeys(); err != nil {
+		t.Errorf("the flags should be valid: %s", err)
+	}
+	gw.RoleSecurityKeys["dev"] = "touch"
+	if err := gw.CheckSecurityKeys(); err == nil {
+		t.Errorf("unknown flags should be rejected")
+	}
+}
@@ -48,6 +48,82 @@ func (t *ConfigService) Register(root string, c *restful.Container) {
 		Param(ws.PathParameter("zone", "the zone to read the JWT from").DataType("string")).
 		Operation("getGateway").
 		Writes(config.Gateway{}))
+	ws.Route(ws.GET("/{zone}/ca").To(t.getCAPublicKey).
+		Doc("Get the public key of the CA which signs the user certificates for the backends").
+		Param(ws.PathParameter("zone", "the zone of the gateway").DataType("string")).
+		Operation("getCAPublicKey").
+		Writes(""))
+	ws.Route(ws.GET("/{zone}/policies").To(mgr(t.getPolicies)).
+		Doc("Get the access policies for a given zone").
+		Param(ws.PathParameter("zone", "the zone of the policies").DataType("string")).
+		Operation("getPolicies").
+		Writes([]config.Policy{}))
+	ws.Route(ws.PUT("/{zone}/policies").To(mgr(t.putPolicy)).
+		Doc("Create or update an access policy for a given zone").
+		Param(ws.PathParameter("zone", "the zone of the policy").DataType("string")).
+		Operation("putPolicy").
+		Reads(config.Policy{}).
+		Writes(config.Policy{}))
+	ws.Route(ws.DELETE("/{zone}/policies/{id}").To(mgr(t.deletePolicy)).
+		Doc("Delete an access policy").
+		Param(ws.PathParameter("zone", "the zone of the policy").DataType("string")).
+		Param(ws.PathParameter("id", "the id of the policy").DataType("string")).
+		Operation("deletePolicy").
+		Writes(""))
+	ws.Route(ws.GET("/{zone}/knownhosts").To(mgr(t.getKnownHosts)).
+		Doc("Get the known host keys of the backends in a given zone").
+		Param(ws.PathParameter("zone", "the zone of the backends").DataType("string")).
+		Operation("getKnownHosts").
+		Writes([]config.KnownHost{}))
+	ws.Route(ws.POST("/{zone}/knownhosts").To(mgr(t.importKnownHosts)).
+		Doc("Import the host keys of an OpenSSH known_hosts file").
+		Param(ws.PathParameter("zone", "the zone of the backends").DataType("string")).
+		Operation("importKnownHosts").
+		Reads("").
+		Writes([]config.KnownHost{}))
+	ws.Route(ws.GET("/{zone}/knownhosts/{host}").To(mgr(t.getKnownHost)).
+		Doc("Get the known host keys of a backend").
+		Param(ws.PathParameter("zone", "the zone of the backend").DataType("string")).
+		Param(ws.PathParameter("host", "the name of the backend").DataType("string")).
+		Operation("getKnownHost").
+		Writes(config.KnownHost{}))
+	ws.Route(ws.PUT("/{zone}/knownhosts/{host}").To(mgr(t.pinHostKey)).
+		Doc("Pin a host key for a backend").
+		Param(ws.PathParameter("zone", "the zone of the backend").DataType("string")).
+		Param(ws.PathParameter("host", "the name of the backend").DataType("string")).
+		Operation("pinHostKey").
+		Reads("").
+		Writes(config.KnownHost{}))
+	ws.Route(ws.PUT("/{zone}/knownhosts/{host}/revoke").To(mgr(t.revokeHostKey)).
+		Doc("Revoke a host key of a backend").
+		Param(ws.PathParameter("zone", "the zone of the backend").DataType("string")).
+		Param(ws.PathParameter("host", "the name of the backend").DataType("string")).
+		Operation("revokeHostKey").
+		Reads("").
+		Writes(config.KnownHost{}))
+	ws.Route(ws.GET("/{zone}/hosts").To(mgr(t.getHosts)).
+		Doc("Get the host inventory of a given zone").
+		Param(ws.PathParameter("zone", "the zone of the hosts").DataType("string")).
+		Operation("getHosts").
+		Writes([]config.Host{}))
+	ws.Route(ws.GET("/{zone}/hosts/{name}").To(mgr(t.getHost)).
+		Doc("Get a host of the inventory").
+		Param(ws.PathParameter("zone", "the zone of the host").DataType("string")).
+		Param(ws.PathParameter("name", "the name of the host").DataType("string")).
+		Operation("getHost").
+		Writes(config.Host{}))
+	ws.Route(ws.PUT("/{zone}/hosts").To(mgr(t.putHost)).
+		Doc("Create or update a host in the inventory of a given zone").
+		Param(ws.PathParameter("zone", "the zone of the host").DataType("string")).
+		Operation("putHost").
+		Reads(config.Host{}).
+		Writes(config.Host{}))
+	ws.Route(ws.DELETE("/{zone}/hosts/{name}").To(mgr(t.deleteHost)).
+		Doc("Remove a host from the inventory").
+		Param(ws.PathParameter("zone", "the zone of the host").DataType("string")).
+		Param(ws.PathParameter("name", "the name of the host").DataType("string")).
+		Operation("deleteHost").
+		Writes(""))
 	ws.Route(ws.GET("/zones").To(mgr(t.getZones)).
 		Doc("Get all current configured zones").
 		Operation("getZones").
@@ -135,3 +211,112 @@ func (t *ConfigService) setClusterConfig(u *users.User, rq *restful.Request, rsp
 	}
 	rest.HandleEntity(t.Config.UpdateCluster(cf))(rq, rsp)
 }
+
+func (t *ConfigService) getCAPublicKey(rq *restful.Request, rsp *restful.Response) {
+	z := rq.PathParameter("zone")
+	gw, err := t.Config.GetGateway(z)
+	if err != nil {
+		rest.HandleError(err, rsp)
+		return
+	}
+	rest.HandleEntity(gw.CAPublicKey())(rq, rsp)
+}
+
+func (t *ConfigService) getPolicies(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	z := rq.PathParameter("zone")
+	rest.HandleEntity(t.Config.Policies(z))(rq, rsp)
+}
+
+func (t *ConfigService) putPolicy(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	var p config.Policy
+	z := rq.PathParameter("zone")
+	if err := rq.ReadEntity(&p); err != nil {
+		rest.HandleError(err, rsp)
+		return
+	}
+	rest.HandleEntity(t.Config.PutPolicy(z, p))(rq, rsp)
+}
+
+func (t *ConfigService) deletePolicy(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	z := rq.PathParameter("zone")
+	id := rq.PathParameter("id")
+	if err := t.Config.DropPolicy(z, id); err != nil {
+		rest.HandleError(err, rsp)
+		return
+	}
+	rsp.WriteEntity(id)
+}
+
+func (t *ConfigService) getKnownHosts(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	z := rq.PathParameter("zone")
+	rest.HandleEntity(t.Config.KnownHosts(z))(rq, rsp)
+}
+
+func (t *ConfigService) getKnownHost(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	z := rq.PathParameter("zone")
+	h := rq.PathParameter("host")
+	rest.HandleEntity(t.Config.KnownHost(z, h))(rq, rsp)
+}
+
+func (t *ConfigService) importKnownHosts(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	var data string
+	z := rq.PathParameter("zone")
+	if err := rq.ReadEntity(&data); err != nil {
+		rest.HandleError(err, rsp)
+		return
+	}
+	rest.HandleEntity(t.Config.ImportKnownHosts(z, []byte(data)))(rq, rsp)
+}
+
+func (t *ConfigService) pinHostKey(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	var key string
+	z := rq.PathParameter("zone")
+	h := rq.PathParameter("host")
+	if err := rq.ReadEntity(&key); err != nil {
+		rest.HandleError(err, rsp)
+		return
+	}
+	rest.HandleEntity(t.Config.PinHostKey(z, h, key))(rq, rsp)
+}
+
+func (t *ConfigService) revokeHostKey(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	var key string
+	z := rq.PathParameter("zone")
+	h := rq.PathParameter("host")
+	if err := rq.ReadEntity(&key); err != nil {
+		rest.HandleError(err, rsp)
+		return
+	}
+	rest.HandleEntity(t.Config.RevokeHostKey(z, h, key))(rq, rsp)
+}
+
+func (t *ConfigService) getHosts(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	z := rq.PathParameter("zone")
+	rest.HandleEntity(t.Config.Hosts(z))(rq, rsp)
+}
+
+func (t *ConfigService) getHost(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	z := rq.PathParameter("zone")
+	n := rq.PathParameter("name")
+	rest.HandleEntity(t.Config.Host(z, n))(rq, rsp)
+}
+
+func (t *ConfigService) putHost(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	var h config.Host
+	z := rq.PathParameter("zone")
+	if err := rq.ReadEntity(&h); err != nil {
+		rest.HandleError(err, rsp)
+		return
+	}
+	rest.HandleEntity(t.Config.PutHost(z, h))(rq, rsp)
+}
+
+func (t *ConfigService) deleteHost(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	z := rq.PathParameter("zone")
+	n := rq.PathParameter("name")
+	if err := t.Config.DropHost(z, n); err != nil {
+		rest.HandleError(err, rsp)
+		return
+	}
+	rsp.WriteEntity(n)
+}
@@ -0,0 +1,73 @@
+package config
+
+import "time"
+
+// The default timeouts of a zone in seconds.
+const (
+	DefaultIdleTimeout      = 600
+	DefaultHandshakeTimeout = 60
+	DefaultDrainTime        = 300
+	DefaultApprovalTimeout  = 60
+)
+
+// Timeouts of a session in seconds. Idle is the time without any traffic
+// after which the connection is closed, MaxSession the maximum duration
+// of a connection. Zero values are not set.
+type Timeouts struct {
+	Idle       int `json:"idle,omitempty"`
+	MaxSession int `json:"maxsession,omitempty"`
+}
+
+// The time a new connection has for the SSH handshake.
+func (gw *Gateway) Handshake() time.Duration {
+	if gw.HandshakeTimeout > 0 {
+		return time.Duration(gw.HandshakeTimeout) * time.Second
+	}
+	return DefaultHandshakeTimeout * time.Second
+}
+
+// The time the connections have to end when the gateway stops.
+func (gw *Gateway) Drain() time.Duration {
+	if gw.DrainTime > 0 {
+		return time.Duration(gw.DrainTime) * time.Second
+	}
+	return DefaultDrainTime * time.Second
+}
+
+// The time a login waits until the user approves it.
+func (gw *Gateway) ApprovalWait() time.Duration {
+	if gw.ApprovalTimeout > 0 {
+		return time.Duration(gw.ApprovalTimeout) * time.Second
+	}
+	return DefaultApprovalTimeout * time.Second
+}
+
+// The timeouts for a user with the given roles. The timeouts of the roles
+// override the timeouts of the zone, if the user has more than one of
+// these roles the longest timeout wins.
+func (gw *Gateway) TimeoutsFor(roles []string) Timeouts {
+	t := Timeouts{Idle: gw.IdleTimeout, MaxSession: gw.MaxSessionTime}
+	var rt Timeouts
+	for _, r := range roles {
+		o, ok := gw.RoleTimeouts[r]
+		if !ok {
+			continue
+		}
+		if o.Idle > rt.Idle {
+			rt.Idle = o.Idle
+		}
+		if o.MaxSession > rt.MaxSession {
+			rt.MaxSession = o.MaxSession
+		}
+	}
+	if rt.Idle > 0 {
+		t.Idle = rt.Idle
+	}
+	if rt.MaxSession > 0 {
+		t.MaxSession = rt.MaxSession
+	}
+	if t.Idle <= 0 {
+		t.Idle = DefaultIdleTimeout
+	}
+	return t
+}
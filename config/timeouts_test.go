@@ -0,0 +1,32 @@
+package config
+
+import "testing"
+
+func TestTimeoutsFor(t *testing.T) {
+	gw := Gateway{
+		MaxSessionTime: 3600,
+		RoleTimeouts: map[string]Timeouts{
+			"dba":   {Idle: 1800},
+			"admin": {Idle: 900, MaxSession: 28800},
+		},
+	}
+	tests := []struct {
+		roles    []string
+		expected Timeouts
+	}{
+		{nil, Timeouts{Idle: DefaultIdleTimeout, MaxSession: 3600}},
+		{[]string{"user"}, Timeouts{Idle: DefaultIdleTimeout, MaxSession: 3600}},
+		{[]string{"dba"}, Timeouts{Idle: 1800, MaxSession: 3600}},
+		{[]string{"admin"}, Timeouts{Idle: 900, MaxSession: 28800}},
+		{[]string{"admin", "dba"}, Timeouts{Idle: 1800, MaxSession: 28800}},
+	}
+	for _, tst := range tests {
+		if to := gw.TimeoutsFor(tst.roles); to != tst.expected {
+			t.Errorf("the timeouts for %v should be %+v, not %+v", tst.roles, tst.expected, to)
+		}
+	}
+	gw.IdleTimeout = 60
+	if to := gw.TimeoutsFor(nil); to.Idle != 60 {
+		t.Errorf("the idle timeout of the zone should be used, not %d", to.Idle)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"path"
 	"reflect"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 
@@ -24,7 +26,8 @@ var logger = logging.Simple()
 
 // a cluster implementation backed by etcd
 type Cluster struct {
-	client *etcd.Client
+	client  *etcd.Client
+	keyring *Keyring
 }
 
 // A configurator supports operations on a subtree inside of etcd
@@ -37,7 +40,7 @@ type Configurator interface {
 // Create the cluster by using the etcd-members
 func Init(machines []string) (*Cluster, error) {
 	client := etcd.NewClient(machines)
-	return &Cluster{client}, nil
+	return &Cluster{client: client}, nil
 }
 
 // Create the cluster with TLS and client cert
@@ -51,7 +54,17 @@ func InitTLS(machines []string, key, cert, cacert string) (*Cluster, error) {
 	if err != nil {
 		return nil, fmt.Errorf("cannot connect to ETCD via TLS: %s", err)
 	}
-	return &Cluster{client}, nil
+	return &Cluster{client: client}, nil
+}
+
+// Use the keyring to seal the secrets of the persisters of this cluster.
+// Without a keyring the secrets are stored in plaintext.
+func (cc *Cluster) UseKeyring(kr *Keyring) {
+	cc.keyring = kr
+}
+
+func (cc *Cluster) Keyring() *Keyring {
+	return cc.keyring
 }
 
 type pathConfigurator struct {
@@ -152,16 +165,29 @@ type Persister interface {
 	Chdir(p string) Persister
 	Ls(p string) ([]string, error)
 	RawClient() *etcd.Client
+	Decode(value string, v interface{}) error
 }
 
-// a jsonpersistor puts the values as json in etcd
+// a jsonpersistor puts the values as json in etcd. The fields with the
+// tag secret:"true" are sealed, a sealed persister seals whole values.
 type jsonPersister struct {
 	basepath string
 	cc       *Cluster
+	sealed   bool
 }
 
 // Create a new JsonPersister at the given basepath.
 func (cc *Cluster) NewJsonPersister(pt string) (Persister, error) {
+	return cc.newPersister(pt, false)
+}
+
+// Create a new JsonPersister at the given basepath which seals every
+// value, for stores which contain nothing but secrets.
+func (cc *Cluster) NewSecretPersister(pt string) (Persister, error) {
+	return cc.newPersister(pt, true)
+}
+
+func (cc *Cluster) newPersister(pt string, sealed bool) (Persister, error) {
 	bp := orcaPersistPath + pt
 	_, err := cc.client.Get(bp, false, false)
 	if err != nil {
@@ -170,7 +196,7 @@ func (cc *Cluster) NewJsonPersister(pt string) (Persister, error) {
 			return nil, err
 		}
 	}
-	return &jsonPersister{basepath: bp, cc: cc}, nil
+	return &jsonPersister{basepath: bp, cc: cc, sealed: sealed}, nil
 }
 
 func (jp *jsonPersister) path(k string) string {
@@ -179,7 +205,7 @@ func (jp *jsonPersister) path(k string) string {
 
 // Return a new Persister with a new basepath
 func (jp *jsonPersister) Chdir(p string) Persister {
-	return &jsonPersister{basepath: path.Join(jp.basepath, p), cc: jp.cc}
+	return &jsonPersister{basepath: path.Join(jp.basepath, p), cc: jp.cc, sealed: jp.sealed}
 }
 
 // Returns the full path inside the orac universum :-)
@@ -220,7 +246,7 @@ func (jp *jsonPersister) Put(k string, v interface{}) error {
 
 // Put the value v at the position k with a given ttl.
 func (jp *jsonPersister) PutTtl(k string, ttl uint64, v interface{}) error {
-	b, e := json.Marshal(v)
+	b, e := jp.encode(v)
 	if e != nil {
 		return e
 	}
@@ -240,7 +266,45 @@ func (jp *jsonPersister) Get(k string, v interface{}) error {
 
 		return e
 	}
-	return json.Unmarshal([]byte(n.Node.Value), v)
+	return jp.Decode(n.Node.Value, v)
+}
+
+func (jp *jsonPersister) encode(v interface{}) ([]byte, error) {
+	kr := jp.cc.keyring
+	v, err := kr.sealFields(v)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(v)
+	if err != nil || !jp.sealed || kr == nil {
+		return b, err
+	}
+	env, err := kr.Seal(b)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(env)
+}
+
+// Decode a value of this persister, e.g. from a watch. Sealed values and
+// fields are opened, plaintext values are read as they are.
+func (jp *jsonPersister) Decode(value string, v interface{}) error {
+	kr := jp.cc.keyring
+	if strings.HasPrefix(value, `"`+envelopePrefix) {
+		var env string
+		if err := json.Unmarshal([]byte(value), &env); err != nil {
+			return err
+		}
+		b, err := kr.Open(env)
+		if err != nil {
+			return err
+		}
+		value = string(b)
+	}
+	if err := json.Unmarshal([]byte(value), v); err != nil {
+		return err
+	}
+	return kr.openFields(v)
 }
 
 // Get all values inside the current context. The res must be a pointer
@@ -253,12 +317,17 @@ func (jp *jsonPersister) GetAll(sorted, recursive bool, res interface{}) error {
 
 	vals, e := jp.cc.client.Get(jp.path(""), sorted, recursive)
 	if e != nil {
+		if cerr, ok := e.(*etcd.EtcdError); ok {
+			if cerr.ErrorCode == etcderr.EcodeKeyNotFound {
+				return common.ErrNotFound
+			}
+		}
 		return e
 	}
 
 	for _, n := range vals.Node.Nodes {
 		nval := reflect.New(arType)
-		err := json.Unmarshal([]byte(n.Value), nval.Interface())
+		err := jp.Decode(n.Value, nval.Interface())
 		if err != nil {
 			return err
 		}
@@ -277,3 +346,54 @@ func (jp *jsonPersister) RemoveDir(k string) error {
 	_, e := jp.cc.client.RawDelete(jp.path(k), true, true)
 	return e
 }
+
+var sealedValue = regexp.MustCompile(regexp.QuoteMeta(envelopePrefix) + `[0-9a-f]+:[A-Za-z0-9_-]+:[A-Za-z0-9_-]+`)
+
+// Wrap the data keys of all sealed values with the current master key of
+// the keyring. The old master keys must still be in the keyring. A value
+// which is changed meanwhile is skipped and reported as an error, the
+// rewrap can be repeated. Returns the number of rewrapped values.
+func (cc *Cluster) RewrapSecrets() (int, error) {
+	if cc.keyring == nil {
+		return 0, ErrNoMasterKey
+	}
+	rsp, err := cc.client.Get(orcaPersistPath, false, true)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	var failed []string
+	var walk func(nodes etcd.Nodes)
+	walk = func(nodes etcd.Nodes) {
+		for _, n := range nodes {
+			if n.Dir {
+				walk(n.Nodes)
+				continue
+			}
+			changed := false
+			var rerr error
+			val := sealedValue.ReplaceAllStringFunc(n.Value, func(s string) string {
+				w, ok, err := cc.keyring.Rewrap(s)
+				if err != nil {
+					rerr = err
+					return s
+				}
+				changed = changed || ok
+				return w
+			})
+			if rerr == nil && changed {
+				_, rerr = cc.client.CompareAndSwap(n.Key, val, uint64(n.TTL), "", n.ModifiedIndex)
+			}
+			if rerr != nil {
+				failed = append(failed, fmt.Sprintf("%s: %s", n.Key, rerr))
+			} else if changed {
+				count++
+			}
+		}
+	}
+	walk(rsp.Node.Nodes)
+	if len(failed) > 0 {
+		return count, fmt.Errorf("cannot rewrap %s", strings.Join(failed, ", "))
+	}
+	return count, nil
+}
@@ -0,0 +1,365 @@
+package etcd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+)
+
+const (
+	// the prefix of a sealed value: orca:enc:v1:<key-id>:<wrapped data key>:<data>
+	envelopePrefix = "orca:enc:v1:"
+	masterKeySize  = 32
+	dataKeySize    = 32
+	// the struct tag which marks the sensitive string fields
+	secretTag = "secret"
+)
+
+var (
+	ErrNoMasterKey = errors.New("the value is encrypted but no master key is configured")
+	b64            = base64.RawURLEncoding
+)
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// A Keyring holds the master keys which protect the secrets in etcd. The
+// first key seals the new values, all keys can open them, so a new key can
+// be put in front of the old ones before the secrets are rewrapped.
+type Keyring struct {
+	keys []masterKey
+}
+
+// Generate a new random master key in base64.
+func GenerateMasterKey() (string, error) {
+	k := make([]byte, masterKeySize)
+	if _, err := rand.Read(k); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(k), nil
+}
+
+// Create a keyring of base64 encoded master keys, the first one is the
+// current key.
+func ParseKeyring(keys ...string) (*Keyring, error) {
+	var kr Keyring
+	for _, k := range keys {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(k))
+		if err != nil {
+			return nil, fmt.Errorf("illegal master key: %s", err)
+		}
+		if len(raw) != masterKeySize {
+			return nil, fmt.Errorf("a master key must have %d bytes, not %d", masterKeySize, len(raw))
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(raw)
+		kr.keys = append(kr.keys, masterKey{id: hex.EncodeToString(sum[:4]), aead: aead})
+	}
+	if len(kr.keys) == 0 {
+		return nil, fmt.Errorf("no master key")
+	}
+	return &kr, nil
+}
+
+// Load the keyring from a file with one key per line or from a comma
+// separated list of keys. Returns nil if both are empty, the secrets are
+// stored in plaintext then.
+func LoadKeyring(file, keys string) (*Keyring, error) {
+	var lst []string
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(bytes.NewReader(b))
+		for sc.Scan() {
+			if l := strings.TrimSpace(sc.Text()); l != "" && !strings.HasPrefix(l, "#") {
+				lst = append(lst, l)
+			}
+		}
+	}
+	for _, k := range strings.Split(keys, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			lst = append(lst, k)
+		}
+	}
+	if len(lst) == 0 {
+		return nil, nil
+	}
+	return ParseKeyring(lst...)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	blk, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(blk)
+}
+
+func seal(aead cipher.AEAD, plain, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, data), nil
+}
+
+func open(aead cipher.AEAD, sealed, data []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("the sealed value is too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], data)
+}
+
+// The id of the current master key.
+func (kr *Keyring) Current() string {
+	return kr.keys[0].id
+}
+
+func (kr *Keyring) key(id string) (*masterKey, error) {
+	for i := range kr.keys {
+		if kr.keys[i].id == id {
+			return &kr.keys[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown master key %s", id)
+}
+
+// Returns true if the value was sealed by a keyring.
+func IsSealed(s string) bool {
+	return strings.HasPrefix(s, envelopePrefix)
+}
+
+// Encrypt the value with a new data key and wrap the data key with the
+// current master key.
+func (kr *Keyring) Seal(plain []byte) (string, error) {
+	mk := kr.keys[0]
+	dk := make([]byte, dataKeySize)
+	if _, err := rand.Read(dk); err != nil {
+		return "", err
+	}
+	wrapped, err := seal(mk.aead, dk, []byte(mk.id))
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dk)
+	if err != nil {
+		return "", err
+	}
+	data, err := seal(aead, plain, nil)
+	if err != nil {
+		return "", err
+	}
+	return envelopePrefix + mk.id + ":" + b64.EncodeToString(wrapped) + ":" + b64.EncodeToString(data), nil
+}
+
+type envelope struct {
+	keyid   string
+	wrapped []byte
+	data    string
+}
+
+func parseEnvelope(s string) (*envelope, error) {
+	parts := strings.Split(strings.TrimPrefix(s, envelopePrefix), ":")
+	if !IsSealed(s) || len(parts) != 3 {
+		return nil, fmt.Errorf("illegal sealed value")
+	}
+	wrapped, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("illegal sealed value: %s", err)
+	}
+	return &envelope{keyid: parts[0], wrapped: wrapped, data: parts[2]}, nil
+}
+
+func (kr *Keyring) dataKey(env *envelope) ([]byte, error) {
+	mk, err := kr.key(env.keyid)
+	if err != nil {
+		return nil, err
+	}
+	dk, err := open(mk.aead, env.wrapped, []byte(mk.id))
+	if err != nil {
+		return nil, fmt.Errorf("cannot unwrap the data key: %s", err)
+	}
+	return dk, nil
+}
+
+// Decrypt a sealed value with the master key it was sealed with.
+func (kr *Keyring) Open(s string) ([]byte, error) {
+	if kr == nil {
+		return nil, ErrNoMasterKey
+	}
+	env, err := parseEnvelope(s)
+	if err != nil {
+		return nil, err
+	}
+	dk, err := kr.dataKey(env)
+	if err != nil {
+		return nil, err
+	}
+	data, err := b64.DecodeString(env.data)
+	if err != nil {
+		return nil, fmt.Errorf("illegal sealed value: %s", err)
+	}
+	aead, err := newAEAD(dk)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, data, nil)
+}
+
+// Wrap the data key of a sealed value with the current master key, the
+// data itself is not touched. Returns false if the value already uses the
+// current key.
+func (kr *Keyring) Rewrap(s string) (string, bool, error) {
+	env, err := parseEnvelope(s)
+	if err != nil {
+		return "", false, err
+	}
+	if env.keyid == kr.Current() {
+		return s, false, nil
+	}
+	dk, err := kr.dataKey(env)
+	if err != nil {
+		return "", false, err
+	}
+	mk := kr.keys[0]
+	wrapped, err := seal(mk.aead, dk, []byte(mk.id))
+	if err != nil {
+		return "", false, err
+	}
+	return envelopePrefix + mk.id + ":" + b64.EncodeToString(wrapped) + ":" + env.data, true, nil
+}
+
+func isSecret(f reflect.StructField) bool {
+	return f.PkgPath == "" && f.Tag.Get(secretTag) == "true"
+}
+
+// Return a copy of v where the string fields and string slices with the
+// tag secret:"true" are sealed. Nested structs are sealed too, v itself
+// is not changed. Without a keyring v is returned.
+func (kr *Keyring) sealFields(v interface{}) (interface{}, error) {
+	if kr == nil || v == nil {
+		return v, nil
+	}
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return v, nil
+	}
+	cp := reflect.New(rv.Type()).Elem()
+	cp.Set(rv)
+	if err := kr.sealStruct(cp); err != nil {
+		return nil, err
+	}
+	return cp.Interface(), nil
+}
+
+func (kr *Keyring) sealString(s string) (string, error) {
+	if s == "" || IsSealed(s) {
+		return s, nil
+	}
+	return kr.Seal([]byte(s))
+}
+
+func (kr *Keyring) sealStruct(rv reflect.Value) error {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), rv.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		switch {
+		case fv.Kind() == reflect.Struct:
+			if err := kr.sealStruct(fv); err != nil {
+				return err
+			}
+		case !isSecret(f):
+		case fv.Kind() == reflect.String:
+			s, err := kr.sealString(fv.String())
+			if err != nil {
+				return err
+			}
+			fv.SetString(s)
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.String && !fv.IsNil():
+			sealed := reflect.MakeSlice(fv.Type(), fv.Len(), fv.Len())
+			for j := 0; j < fv.Len(); j++ {
+				s, err := kr.sealString(fv.Index(j).String())
+				if err != nil {
+					return err
+				}
+				sealed.Index(j).SetString(s)
+			}
+			fv.Set(sealed)
+		}
+	}
+	return nil
+}
+
+// Open the sealed secret fields of the struct v points to. Plaintext
+// values are kept, so values written before the encryption can be read.
+func (kr *Keyring) openFields(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil
+	}
+	rv = rv.Elem()
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	return kr.openStruct(rv)
+}
+
+func (kr *Keyring) openString(s string) (string, error) {
+	if !IsSealed(s) {
+		return s, nil
+	}
+	b, err := kr.Open(s)
+	return string(b), err
+}
+
+func (kr *Keyring) openStruct(rv reflect.Value) error {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f, fv := t.Field(i), rv.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		switch {
+		case fv.Kind() == reflect.Struct:
+			if err := kr.openStruct(fv); err != nil {
+				return err
+			}
+		case !isSecret(f):
+		case fv.Kind() == reflect.String:
+			s, err := kr.openString(fv.String())
+			if err != nil {
+				return err
+			}
+			fv.SetString(s)
+		case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.String:
+			for j := 0; j < fv.Len(); j++ {
+				s, err := kr.openString(fv.Index(j).String())
+				if err != nil {
+					return err
+				}
+				fv.Index(j).SetString(s)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package etcd
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+type testSecrets struct {
+	Name   string   `json:"name"`
+	Key    string   `json:"key" secret:"true"`
+	Keys   []string `json:"keys" secret:"true"`
+	Nested struct {
+		Token string `json:"token" secret:"true"`
+	} `json:"nested"`
+}
+
+func newTestKeyring(t *testing.T, n int) (*Keyring, []string) {
+	var keys []string
+	for i := 0; i < n; i++ {
+		k, err := GenerateMasterKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	kr, err := ParseKeyring(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr, keys
+}
+
+func TestSealOpen(t *testing.T) {
+	kr, _ := newTestKeyring(t, 1)
+	s, err := kr.Seal([]byte("my secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(s) || strings.Contains(s, "my secret") {
+		t.Errorf("the value should be sealed: %s", s)
+	}
+	if s2, _ := kr.Seal([]byte("my secret")); s2 == s {
+		t.Errorf("every seal should use a new data key")
+	}
+	b, err := kr.Open(s)
+	if err != nil || string(b) != "my secret" {
+		t.Errorf("the value should be opened: %q, %v", b, err)
+	}
+	i := len(s) - 10
+	c := "A"
+	if s[i] == 'A' {
+		c = "B"
+	}
+	tampered := s[:i] + c + s[i+1:]
+	if _, err := kr.Open(tampered); err == nil {
+		t.Errorf("a changed value must not be opened")
+	}
+	other, _ := newTestKeyring(t, 1)
+	if _, err := other.Open(s); err == nil {
+		t.Errorf("a value must not be opened with another master key")
+	}
+	var none *Keyring
+	if _, err := none.Open(s); err != ErrNoMasterKey {
+		t.Errorf("opening without a keyring should fail with ErrNoMasterKey, not %v", err)
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	old, keys := newTestKeyring(t, 1)
+	s, err := old.Seal([]byte("rotate me"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nk, err := GenerateMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr, err := ParseKeyring(nk, keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, changed, err := kr.Rewrap(s)
+	if err != nil || !changed {
+		t.Fatalf("the value should be rewrapped: %v", err)
+	}
+	if !strings.HasPrefix(w, envelopePrefix+kr.Current()+":") {
+		t.Errorf("the value should use the new key: %s", w)
+	}
+	current, _ := ParseKeyring(nk)
+	if b, err := current.Open(w); err != nil || string(b) != "rotate me" {
+		t.Errorf("the new key alone should open the value: %q, %v", b, err)
+	}
+	if _, changed, _ := kr.Rewrap(w); changed {
+		t.Errorf("a value with the current key should not be rewrapped")
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	if kr, err := LoadKeyring("", ""); kr != nil || err != nil {
+		t.Errorf("without keys there should be no keyring: %v, %v", kr, err)
+	}
+	_, keys := newTestKeyring(t, 2)
+	f, err := ioutil.TempFile("", "masterkeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# the current key first\n" + keys[0] + "\n\n" + keys[1] + "\n")
+	f.Close()
+	kr, err := LoadKeyring(f.Name(), "")
+	if err != nil || len(kr.keys) != 2 {
+		t.Fatalf("both keys should be loaded: %v", err)
+	}
+	fromEnv, err := LoadKeyring("", keys[0]+", "+keys[1])
+	if err != nil || fromEnv.Current() != kr.Current() {
+		t.Errorf("the first key of the list should be the current key: %v", err)
+	}
+	if _, err := LoadKeyring("", "c2hvcnQ="); err == nil {
+		t.Errorf("a short key should be refused")
+	}
+}
+
+func TestSealedFields(t *testing.T) {
+	kr, _ := newTestKeyring(t, 1)
+	jp := &jsonPersister{cc: &Cluster{keyring: kr}}
+	v := testSecrets{Name: "gw", Key: "private", Keys: []string{"hostkey-one", "hostkey-two"}}
+	v.Nested.Token = "s3cr3t"
+	b, err := jp.encode(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"private", "hostkey-one", "hostkey-two", "s3cr3t"} {
+		if strings.Contains(string(b), s) {
+			t.Errorf("%s should be sealed: %s", s, b)
+		}
+	}
+	if !strings.Contains(string(b), `"name":"gw"`) {
+		t.Errorf("the other fields should be plaintext: %s", b)
+	}
+	if v.Key != "private" || v.Keys[0] != "hostkey-one" {
+		t.Errorf("the value must not be changed: %+v", v)
+	}
+	var res testSecrets
+	if err := jp.Decode(string(b), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Key != "private" || len(res.Keys) != 2 || res.Keys[1] != "hostkey-two" || res.Nested.Token != "s3cr3t" {
+		t.Errorf("the fields should be opened: %+v", res)
+	}
+
+	plain := &jsonPersister{cc: &Cluster{}}
+	if err := plain.Decode(string(b), &res); err != ErrNoMasterKey {
+		t.Errorf("sealed fields cannot be read without a keyring: %v", err)
+	}
+	old := `{"name":"gw","key":"private","keys":["hostkey-one"]}`
+	if err := jp.Decode(old, &res); err != nil || res.Key != "private" || res.Keys[0] != "hostkey-one" {
+		t.Errorf("plaintext values should be readable: %+v, %v", res, err)
+	}
+}
+
+func TestSecretPersister(t *testing.T) {
+	kr, _ := newTestKeyring(t, 1)
+	jp := &jsonPersister{cc: &Cluster{keyring: kr}, sealed: true}
+	b, err := jp.encode("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), `"`+envelopePrefix) {
+		t.Errorf("the whole value should be sealed: %s", b)
+	}
+	var secret string
+	if err := jp.Decode(string(b), &secret); err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("the value should be opened: %q, %v", secret, err)
+	}
+	if err := jp.Decode(`"PLAINSECRET"`, &secret); err != nil || secret != "PLAINSECRET" {
+		t.Errorf("a plaintext value should be readable: %q, %v", secret, err)
+	}
+	if m := sealedValue.FindAllString(string(b), -1); len(m) != 1 {
+		t.Errorf("the rewrap should find the sealed value: %v", m)
+	}
+}
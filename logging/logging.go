@@ -32,6 +32,7 @@ type Logger struct {
 	simple  bool
 	client  string
 	session string
+	chain   string
 	format  string
 }
 
@@ -44,6 +45,11 @@ func New(sessid, client string) *Logger {
 	}
 }
 
+// Log the chain of gateways a session runs through.
+func (l *Logger) SetChain(chain string) {
+	l.chain = chain
+}
+
 func Simple() *Logger {
 	return &Logger{
 		simple: true,
@@ -54,6 +60,8 @@ func Simple() *Logger {
 func (l *Logger) logimpl(level LogLevel, format string, data ...interface{}) {
 	if l.simple {
 		log.Printf(l.format, level, fmt.Sprintf(format, data...))
+	} else if l.chain != "" {
+		log.Printf("[%s] [client=%s] [chain=%s] [sid=%s] %s", level, l.client, l.chain, l.session, fmt.Sprintf(format, data...))
 	} else {
 		log.Printf(l.format, level, l.client, l.session, fmt.Sprintf(format, data...))
 	}
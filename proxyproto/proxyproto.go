@@ -0,0 +1,175 @@
+// Package proxyproto reads the header of the PROXY protocol (version 1
+// and 2) which load balancers like HAProxy send in front of a proxied
+// connection. The header carries the address of the real client.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	sigV1 = []byte("PROXY ")
+	sigV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	// ErrNoHeader is returned if a trusted proxy did not send a header.
+	ErrNoHeader = errors.New("no PROXY protocol header")
+)
+
+// the maximum length of a version 1 header including CRLF
+const maxV1Len = 107
+
+// ReadHeader reads a version 1 or 2 header. The source is nil if the
+// proxy does not know the client or sends a health check.
+func ReadHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(sigV1))
+	if err != nil {
+		return nil, ErrNoHeader
+	}
+	if bytes.Equal(sig, sigV1) {
+		return readV1(r)
+	}
+	sig, err = r.Peek(len(sigV2))
+	if err == nil && bytes.Equal(sig, sigV2) {
+		return readV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxV1Len {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			return parseV1(string(line[:len(line)-2]))
+		}
+	}
+	return nil, fmt.Errorf("the PROXY header is too long")
+}
+
+// PROXY TCP4 <src> <dst> <srcport> <dstport>
+func parseV1(line string) (net.Addr, error) {
+	f := strings.Split(line, " ")
+	if len(f) >= 2 && f[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(f) != 6 || (f[1] != "TCP4" && f[1] != "TCP6") {
+		return nil, fmt.Errorf("illegal PROXY header %q", line)
+	}
+	ip := net.ParseIP(f[2])
+	port, err := strconv.ParseUint(f[4], 10, 16)
+	if ip == nil || err != nil || (f[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("illegal source in PROXY header %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unknown PROXY protocol version %d", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	switch hdr[12] & 0xf {
+	case 0:
+		// LOCAL: a health check of the proxy itself
+		return nil, nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("unknown PROXY command %d", hdr[12]&0xf)
+	}
+	// the addresses of TCP over IPv4 and IPv6, the TLVs after them are
+	// ignored
+	switch hdr[13] {
+	case 0x11:
+		if len(body) < 12 {
+			return nil, fmt.Errorf("the PROXY header is too short")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21:
+		if len(body) < 36 {
+			return nil, fmt.Errorf("the PROXY header is too short")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	}
+	return nil, nil
+}
+
+// A Conn is a connection of a trusted proxy. The header is read once
+// before the first data, RemoteAddr returns the address of the client.
+type Conn struct {
+	net.Conn
+	r      *bufio.Reader
+	once   sync.Once
+	err    error
+	source net.Addr
+}
+
+func NewConn(c net.Conn) *Conn {
+	return &Conn{Conn: c, r: bufio.NewReader(c)}
+}
+
+// ReadHeader reads the header of the proxy if it was not read before.
+func (c *Conn) ReadHeader() error {
+	c.once.Do(func() {
+		c.source, c.err = ReadHeader(c.r)
+	})
+	return c.err
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	if err := c.ReadHeader(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+// The address of the client or of the proxy if the header contains no
+// source.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.ReadHeader() != nil || c.source == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.source
+}
+
+// The address of the proxy.
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+// A Listener wraps the connections of trusted proxies, the other
+// connections are passed unchanged.
+type Listener struct {
+	net.Listener
+	Trusted func(net.Addr) bool
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if l.Trusted != nil && l.Trusted(c.RemoteAddr()) {
+		return NewConn(c), nil
+	}
+	return c, nil
+}
@@ -0,0 +1,104 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func v2Header(cmd, fam byte, addrs []byte) []byte {
+	b := append([]byte(nil), sigV2...)
+	b = append(b, 0x20|cmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(b[14:], uint16(len(addrs)))
+	return append(b, addrs...)
+}
+
+func TestReadHeader(t *testing.T) {
+	v4 := []byte{192, 168, 1, 10, 10, 0, 0, 1, 0xd4, 0x31, 0, 22}
+	v4 = append(v4, 0x04, 0, 1, 'x') // a TLV
+	v6 := make([]byte, 36)
+	copy(v6, net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(v6[32:], 40000)
+	tests := []struct {
+		header string
+		source string
+	}{
+		{"PROXY TCP4 192.168.1.10 10.0.0.1 54321 22\r\n", "192.168.1.10:54321"},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 40000 22\r\n", "[2001:db8::1]:40000"},
+		{"PROXY UNKNOWN\r\n", ""},
+		{string(v2Header(1, 0x11, v4)), "192.168.1.10:54321"},
+		{string(v2Header(1, 0x21, v6)), "[2001:db8::1]:40000"},
+		{string(v2Header(0, 0x00, nil)), ""},
+	}
+	for _, tst := range tests {
+		r := bufio.NewReader(strings.NewReader(tst.header + "SSH-2.0-client"))
+		src, err := ReadHeader(r)
+		if err != nil {
+			t.Errorf("%q: %s", tst.header, err)
+			continue
+		}
+		if (src == nil && tst.source != "") || (src != nil && src.String() != tst.source) {
+			t.Errorf("%q: the source should be %q, not %v", tst.header, tst.source, src)
+		}
+		if rest, _ := ioutil.ReadAll(r); string(rest) != "SSH-2.0-client" {
+			t.Errorf("%q: the data after the header should be kept, not %q", tst.header, rest)
+		}
+	}
+	for _, h := range []string{
+		"SSH-2.0-client\r\n",
+		"PROXY TCP4 192.168.1.10 10.0.0.1 54321\r\n",
+		"PROXY TCP4 2001:db8::1 10.0.0.1 54321 22\r\n",
+		"PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+		string(v2Header(1, 0x11, v4[:8])),
+	} {
+		if _, err := ReadHeader(bufio.NewReader(strings.NewReader(h))); err == nil {
+			t.Errorf("%q should not be accepted", h)
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	trusted := true
+	pl := &Listener{Listener: l, Trusted: func(net.Addr) bool { return trusted }}
+	accept := func(data string) net.Conn {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte(data))
+		c.Close()
+		sc, err := pl.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sc
+	}
+
+	c := accept("PROXY TCP4 192.168.1.10 10.0.0.1 54321 22\r\nhello")
+	if c.RemoteAddr().String() != "192.168.1.10:54321" {
+		t.Errorf("the address of the client should be used, not %s", c.RemoteAddr())
+	}
+	if b, _ := ioutil.ReadAll(c); !bytes.Equal(b, []byte("hello")) {
+		t.Errorf("the data should follow the header, got %q", b)
+	}
+
+	c = accept("hello")
+	if err := c.(*Conn).ReadHeader(); err != ErrNoHeader {
+		t.Errorf("a trusted proxy must send a header: %v", err)
+	}
+
+	trusted = false
+	c = accept("PROXY TCP4 192.168.1.10 10.0.0.1 54321 22\r\n")
+	if _, ok := c.(*Conn); ok || strings.HasPrefix(c.RemoteAddr().String(), "192.168.1.10") {
+		t.Errorf("the header of an untrusted client must not be used")
+	}
+}
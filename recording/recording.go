@@ -0,0 +1,227 @@
+// Session recordings in the asciicast v2 format. A recording starts
+// with a JSON header line followed by one JSON array per event in the
+// form [time, type, data] where time is the number of seconds since the
+// start of the recording. Recordings can be replayed with asciinema or
+// with the Replay function of this package.
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	Version = 2
+
+	// event types
+	Output = "o"
+	Input  = "i"
+	Resize = "r"
+)
+
+// The header of an asciicast recording.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// A single frame of a recording.
+type Event struct {
+	Time float64
+	Type string
+	Data string
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time, e.Type, e.Data})
+}
+
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var raw []interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 3 {
+		return fmt.Errorf("illegal event: %s", string(b))
+	}
+	t, ok := raw[0].(float64)
+	if !ok {
+		return fmt.Errorf("illegal event time: %v", raw[0])
+	}
+	tp, ok := raw[1].(string)
+	if !ok {
+		return fmt.Errorf("illegal event type: %v", raw[1])
+	}
+	data, ok := raw[2].(string)
+	if !ok {
+		return fmt.Errorf("illegal event data: %v", raw[2])
+	}
+	e.Time, e.Type, e.Data = t, tp, data
+	return nil
+}
+
+// A Recorder writes the events of one session to an underlying writer.
+// It is safe to use a Recorder from multiple goroutines.
+type Recorder struct {
+	mux   sync.Mutex
+	w     io.WriteCloser
+	enc   *json.Encoder
+	start time.Time
+	// the start of a character which is split over two chunks of data,
+	// per event type
+	pending map[string][]byte
+}
+
+// Create a new recorder which writes the given header immediately.
+func New(w io.WriteCloser, h Header) (*Recorder, error) {
+	h.Version = Version
+	start := time.Now()
+	if h.Timestamp == 0 {
+		h.Timestamp = start.Unix()
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(h); err != nil {
+		return nil, err
+	}
+	return &Recorder{w: w, enc: enc, start: start, pending: make(map[string][]byte)}, nil
+}
+
+// Record the data with the given event type. An incomplete UTF-8
+// character at the end of the data is kept until the next data of the
+// type, the event data would contain a replacement character otherwise.
+func (r *Recorder) Record(tp string, data []byte) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.w == nil {
+		return io.ErrClosedPipe
+	}
+	if p := r.pending[tp]; len(p) > 0 {
+		data = append(p, data...)
+	}
+	n := len(data) - incompleteRune(data)
+	if n < len(data) {
+		r.pending[tp] = append([]byte(nil), data[n:]...)
+	} else {
+		delete(r.pending, tp)
+	}
+	if n == 0 {
+		return nil
+	}
+	return r.encode(tp, data[:n])
+}
+
+func (r *Recorder) encode(tp string, data []byte) error {
+	e := Event{Time: time.Since(r.start).Seconds(), Type: tp, Data: string(data)}
+	return r.enc.Encode(e)
+}
+
+// the number of bytes at the end of b which start a character but do not
+// complete it.
+func incompleteRune(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if utf8.FullRune(b[i:]) {
+				return 0
+			}
+			return len(b) - i
+		}
+	}
+	return 0
+}
+
+// Record a change of the terminal size.
+func (r *Recorder) Resize(width, height int) error {
+	return r.Record(Resize, []byte(fmt.Sprintf("%dx%d", width, height)))
+}
+
+// Returns a writer which records everything written as an event of
+// the given type. Errors of the recorder are not propagated to the
+// caller, so a broken recording does not break the session.
+func (r *Recorder) Writer(tp string) io.Writer {
+	return &eventWriter{r, tp}
+}
+
+// Close the recording and the underlying writer.
+func (r *Recorder) Close() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.w == nil {
+		return nil
+	}
+	for tp, p := range r.pending {
+		r.encode(tp, p)
+	}
+	err := r.w.Close()
+	r.w = nil
+	return err
+}
+
+type eventWriter struct {
+	rec *Recorder
+	tp  string
+}
+
+func (ew *eventWriter) Write(b []byte) (int, error) {
+	ew.rec.Record(ew.tp, b)
+	return len(b), nil
+}
+
+// Read a complete recording.
+func Read(r io.Reader) (*Header, []Event, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var h Header
+	if err := dec.Decode(&h); err != nil {
+		return nil, nil, fmt.Errorf("cannot read header: %s", err)
+	}
+	var events []Event
+	for {
+		var e Event
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return &h, events, err
+		}
+		events = append(events, e)
+	}
+	return &h, events, nil
+}
+
+// Replay the output events of a recording to out. The pauses between two
+// events are divided by speed and limited to maxWait if it is greater
+// than zero.
+func Replay(r io.Reader, out io.Writer, speed float64, maxWait time.Duration) error {
+	_, events, err := Read(r)
+	if err != nil {
+		return err
+	}
+	if speed <= 0 {
+		speed = 1
+	}
+	last := 0.0
+	for _, e := range events {
+		if e.Type != Output {
+			continue
+		}
+		wait := time.Duration((e.Time - last) / speed * float64(time.Second))
+		if maxWait > 0 && wait > maxWait {
+			wait = maxWait
+		}
+		time.Sleep(wait)
+		last = e.Time
+		if _, err := io.WriteString(out, e.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package recording
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestRecordAndRead(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := New(nopCloser{&buf}, Header{Width: 80, Height: 24, Command: "ls"})
+	if err != nil {
+		t.Fatalf("cannot create recorder: %s", err)
+	}
+	rec.Writer(Output).Write([]byte("hello\r\n"))
+	rec.Writer(Input).Write([]byte("x"))
+	rec.Resize(100, 40)
+	rec.Close()
+	if err := rec.Record(Output, []byte("late")); err == nil {
+		t.Errorf("recording after close should fail")
+	}
+
+	h, events, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("cannot read recording: %s", err)
+	}
+	if h.Version != Version || h.Width != 80 || h.Height != 24 || h.Command != "ls" {
+		t.Errorf("wrong header: %+v", h)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	if events[0].Type != Output || events[0].Data != "hello\r\n" {
+		t.Errorf("wrong output event: %+v", events[0])
+	}
+	if events[1].Type != Input || events[1].Data != "x" {
+		t.Errorf("wrong input event: %+v", events[1])
+	}
+	if events[2].Type != Resize || events[2].Data != "100x40" {
+		t.Errorf("wrong resize event: %+v", events[2])
+	}
+}
+
+func TestSplitCharacters(t *testing.T) {
+	var buf bytes.Buffer
+	rec, err := New(nopCloser{&buf}, Header{Width: 80, Height: 24})
+	if err != nil {
+		t.Fatalf("cannot create recorder: %s", err)
+	}
+	euro := []byte("€")
+	w := rec.Writer(Output)
+	w.Write([]byte{'a', euro[0]})
+	rec.Writer(Input).Write([]byte("x"))
+	w.Write(euro[1:2])
+	w.Write(append(euro[2:], 'b'))
+	w.Write(euro[:1])
+	rec.Close()
+
+	_, events, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("cannot read recording: %s", err)
+	}
+	var out string
+	for _, e := range events {
+		if e.Type == Output {
+			out += e.Data
+		}
+	}
+	if len(events) != 4 || out != "a€b\ufffd" {
+		t.Errorf("the characters should be joined over the chunks: %q %+v", out, events)
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orcarec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	st, err := NewDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		w, err := st.Create("abcd")
+		if err != nil {
+			t.Fatalf("cannot create recording: %s", err)
+		}
+		rec, _ := New(w, Header{})
+		rec.Record(Output, []byte("out"))
+		rec.Close()
+	}
+	if _, err := st.Create("../abcd"); err == nil {
+		t.Errorf("illegal ids should not be accepted")
+	}
+	infos, err := BySession(st, "abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 recordings, got %d", len(infos))
+	}
+	var out bytes.Buffer
+	r, err := st.Open(infos[1].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err := Replay(r, &out, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "out" {
+		t.Errorf("wrong replay output: %q", out.String())
+	}
+}
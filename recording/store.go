@@ -0,0 +1,144 @@
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/clusterit/orca/common"
+)
+
+const (
+	extension = ".cast"
+)
+
+// Information about a stored recording.
+type Info struct {
+	Id    string    `json:"id"`
+	Start time.Time `json:"start"`
+	Size  int64     `json:"size"`
+}
+
+// A Store persists recordings. The id of a recording is the session id
+// of the gateway, optionally followed by a suffix if a session has
+// more than one recording.
+type Store interface {
+	Create(id string) (io.WriteCloser, error)
+	Open(id string) (io.ReadCloser, error)
+	List() ([]Info, error)
+}
+
+type dirStore struct {
+	dir string
+}
+
+// Create a store which puts every recording in a file inside of the
+// given directory. The directory is created if it does not exist.
+func NewDirStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &dirStore{dir: dir}, nil
+}
+
+func (ds *dirStore) path(id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, "/\\") || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("illegal recording id: %q", id)
+	}
+	return filepath.Join(ds.dir, id+extension), nil
+}
+
+// Create a new recording with the given id. If there is already a
+// recording with this id, a numbered suffix is appended.
+func (ds *dirStore) Create(id string) (io.WriteCloser, error) {
+	pt, err := ds.path(id)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(pt, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		pt, _ = ds.path(fmt.Sprintf("%s-%d", id, i))
+	}
+}
+
+func (ds *dirStore) Open(id string) (io.ReadCloser, error) {
+	pt, err := ds.path(id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(pt)
+	if os.IsNotExist(err) {
+		return nil, common.ErrNotFound
+	}
+	return f, err
+}
+
+func (ds *dirStore) List() ([]Info, error) {
+	fis, err := ioutil.ReadDir(ds.dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []Info
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), extension) {
+			continue
+		}
+		inf := Info{
+			Id:    strings.TrimSuffix(fi.Name(), extension),
+			Start: fi.ModTime(),
+			Size:  fi.Size(),
+		}
+		if h, err := readHeader(filepath.Join(ds.dir, fi.Name())); err == nil {
+			inf.Start = time.Unix(h.Timestamp, 0)
+		}
+		res = append(res, inf)
+	}
+	sort.Sort(byStart(res))
+	return res, nil
+}
+
+// Find all recordings which belong to the given session id.
+func BySession(st Store, sid string) ([]Info, error) {
+	all, err := st.List()
+	if err != nil {
+		return nil, err
+	}
+	var res []Info
+	for _, inf := range all {
+		if inf.Id == sid || strings.HasPrefix(inf.Id, sid+"-") {
+			res = append(res, inf)
+		}
+	}
+	if len(res) == 0 {
+		return nil, common.ErrNotFound
+	}
+	return res, nil
+}
+
+func readHeader(pt string) (*Header, error) {
+	f, err := os.Open(pt)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var h Header
+	return &h, json.NewDecoder(f).Decode(&h)
+}
+
+type byStart []Info
+
+func (b byStart) Len() int           { return len(b) }
+func (b byStart) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byStart) Less(i, j int) bool { return b[i].Start.Before(b[j].Start) }
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"github.com/clusterit/orca/auth"
+	"github.com/clusterit/orca/rest"
+	"github.com/clusterit/orca/sessions"
+	"github.com/clusterit/orca/users"
+	"gopkg.in/emicklei/go-restful.v1"
+)
+
+type SessionService struct {
+	Auth     auth.Auther
+	Users    users.Users
+	Registry sessions.Registry
+}
+
+func (t *SessionService) Shutdown() error {
+	return nil
+}
+
+func (t *SessionService) Register(root string, c *restful.Container) {
+	ws := new(restful.WebService)
+
+	mgr := users.CheckUser(t.Auth, t.Users, users.ManagerRoles, nil)
+
+	ws.
+		Path(root + "sessions").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON)
+
+	ws.Route(ws.GET("/").To(mgr(t.listSessions)).
+		Doc("List the active sessions of all gateways").
+		Operation("listSessions").
+		Writes([]sessions.Session{}))
+
+	ws.Route(ws.GET("/{id}").To(mgr(t.getSession)).
+		Doc("Get an active session").
+		Param(ws.PathParameter("id", "the id of the session").DataType("string")).
+		Operation("getSession").
+		Writes(sessions.Session{}))
+
+	ws.Route(ws.DELETE("/{id}").To(mgr(t.killSession)).
+		Doc("Kill an active session on its gateway").
+		Param(ws.PathParameter("id", "the id of the session").DataType("string")).
+		Operation("killSession"))
+
+	c.Add(ws)
+}
+
+func (t *SessionService) listSessions(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	rest.HandleEntity(t.Registry.List())(rq, rsp)
+}
+
+func (t *SessionService) getSession(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	rest.HandleEntity(t.Registry.Get(rq.PathParameter("id")))(rq, rsp)
+}
+
+func (t *SessionService) killSession(u *users.User, rq *restful.Request, rsp *restful.Response) {
+	id := rq.PathParameter("id")
+	if err := t.Registry.Kill(id); err != nil {
+		rest.HandleError(err, rsp)
+		return
+	}
+	rsp.WriteEntity(id)
+}
@@ -0,0 +1,131 @@
+// Package sessions publishes the active sessions of the gateways, so a
+// manager can list them and kill a session on whichever gateway serves
+// it.
+package sessions
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/clusterit/orca/common"
+	"github.com/clusterit/orca/etcd"
+	cetcd "github.com/coreos/go-etcd/etcd"
+)
+
+const (
+	sessionsPath = "/sessions"
+	killsPath    = "/killsessions"
+
+	// a gateway refreshes its sessions before this time is over, so the
+	// sessions of a crashed gateway disappear
+	DefaultTTL = 60
+	// the time a gateway has to kill a session
+	killTTL = 60
+)
+
+// A Session is a connection of a user to a gateway.
+type Session struct {
+	Id       string         `json:"id"`
+	User     string         `json:"user"`
+	Zone     string         `json:"zone"`
+	Gateway  string         `json:"gateway"`
+	Source   string         `json:"source"`
+	Target   string         `json:"target,omitempty"`
+	Start    time.Time      `json:"start"`
+	BytesIn  int64          `json:"bytesin"`
+	BytesOut int64          `json:"bytesout"`
+	Channels map[string]int `json:"channels,omitempty"`
+}
+
+// A Kills channel receives the ids of the sessions which should be
+// killed.
+type Kills <-chan string
+
+// A Registry keeps the active sessions of all gateways.
+type Registry interface {
+	Put(s Session) error
+	Remove(id string) error
+	Get(id string) (*Session, error)
+	List() ([]Session, error)
+	Kill(id string) error
+	Kills() (Kills, chan bool, error)
+}
+
+type etcdRegistry struct {
+	sessions etcd.Persister
+	kills    etcd.Persister
+	ttl      uint64
+}
+
+// Create a new registry in etcd. The sessions expire after ttl seconds if
+// they are not put again.
+func New(cl *etcd.Cluster, ttl uint64) (Registry, error) {
+	s, e := cl.NewJsonPersister(sessionsPath)
+	if e != nil {
+		return nil, e
+	}
+	k, e := cl.NewJsonPersister(killsPath)
+	if e != nil {
+		return nil, e
+	}
+	return &etcdRegistry{sessions: s, kills: k, ttl: ttl}, nil
+}
+
+func (r *etcdRegistry) Put(s Session) error {
+	return r.sessions.PutTtl(s.Id, r.ttl, s)
+}
+
+func (r *etcdRegistry) Remove(id string) error {
+	return r.sessions.Remove(id)
+}
+
+func (r *etcdRegistry) Get(id string) (*Session, error) {
+	var s Session
+	if err := r.sessions.Get(id, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *etcdRegistry) List() ([]Session, error) {
+	var res []Session
+	err := r.sessions.GetAll(true, false, &res)
+	if common.IsNotFound(err) {
+		return nil, nil
+	}
+	return res, err
+}
+
+// Ask the gateway of the session to kill it.
+func (r *etcdRegistry) Kill(id string) error {
+	s, err := r.Get(id)
+	if err != nil {
+		return err
+	}
+	return r.kills.PutTtl(s.Id, killTTL, s)
+}
+
+// Watch the kill requests. Closing the returned stop channel ends the
+// watch.
+func (r *etcdRegistry) Kills() (Kills, chan bool, error) {
+	kills := make(chan string)
+	stop := make(chan bool)
+	rsp := make(chan *cetcd.Response)
+	go func() {
+		r.kills.RawClient().Watch(strings.TrimSuffix(r.kills.Path(""), "/"), 0, true, rsp, stop)
+	}()
+	go func() {
+		for {
+			select {
+			case n := <-rsp:
+				if n != nil && n.Node != nil && (n.Action == "set" || n.Action == "create") {
+					kills <- path.Base(n.Node.Key)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return kills, stop, nil
+}
@@ -0,0 +1,59 @@
+package sessions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/clusterit/orca/common"
+	"github.com/clusterit/orca/testsupport"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRegistry(t *testing.T) {
+	ts, e := testsupport.New()
+	if e != nil {
+		t.Fatalf("cannot init etcd container: %s", e)
+	}
+	cluster, e := ts.StartEtcd()
+	if e != nil {
+		t.Fatalf("cannot start etcd container: %s", e)
+	}
+	defer ts.StopEtcd()
+	reg, e := New(cluster, DefaultTTL)
+	if e != nil {
+		t.Fatalf("cannot create registry: %s", e)
+	}
+
+	Convey("Publish some sessions", t, func() {
+		So(reg.Put(Session{Id: "s1", User: "id@network", Zone: "intranet", Start: time.Now()}), ShouldBeNil)
+		So(reg.Put(Session{Id: "s2", User: "other@network", Zone: "intranet", Start: time.Now()}), ShouldBeNil)
+		Convey("and list them", func() {
+			all, err := reg.List()
+			So(err, ShouldBeNil)
+			So(len(all), ShouldEqual, 2)
+			s, err := reg.Get("s1")
+			So(err, ShouldBeNil)
+			So(s.User, ShouldEqual, "id@network")
+		})
+		Convey("a killed session is signalled", func() {
+			kills, stop, err := reg.Kills()
+			So(err, ShouldBeNil)
+			defer close(stop)
+			So(reg.Kill("s2"), ShouldBeNil)
+			select {
+			case id := <-kills:
+				So(id, ShouldEqual, "s2")
+			case <-time.After(5 * time.Second):
+				t.Errorf("the kill was not signalled")
+			}
+		})
+		Convey("unknown sessions cannot be killed", func() {
+			So(common.IsNotFound(reg.Kill("unknown")), ShouldBeTrue)
+		})
+		Convey("removed sessions are gone", func() {
+			So(reg.Remove("s1"), ShouldBeNil)
+			_, err := reg.Get("s1")
+			So(common.IsNotFound(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,133 @@
+package sftp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// the packet types of the SFTP protocol, version 3
+// (draft-ietf-secsh-filexfer-02)
+const (
+	fxpInit          = 1
+	fxpVersion       = 2
+	fxpOpen          = 3
+	fxpClose         = 4
+	fxpRead          = 5
+	fxpWrite         = 6
+	fxpLstat         = 7
+	fxpFstat         = 8
+	fxpSetstat       = 9
+	fxpFsetstat      = 10
+	fxpOpendir       = 11
+	fxpReaddir       = 12
+	fxpRemove        = 13
+	fxpMkdir         = 14
+	fxpRmdir         = 15
+	fxpRealpath      = 16
+	fxpStat          = 17
+	fxpRename        = 18
+	fxpReadlink      = 19
+	fxpSymlink       = 20
+	fxpStatus        = 101
+	fxpHandle        = 102
+	fxpData          = 103
+	fxpName          = 104
+	fxpAttrs         = 105
+	fxpExtended      = 200
+	fxpExtendedReply = 201
+)
+
+// the flags of an open request
+const (
+	fxfRead   = 0x01
+	fxfWrite  = 0x02
+	fxfAppend = 0x04
+	fxfCreat  = 0x08
+	fxfTrunc  = 0x10
+	fxfExcl   = 0x20
+)
+
+// the status codes
+const (
+	fxOk               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+)
+
+// the largest packet we accept. OpenSSH uses at most 256KB.
+const maxPacket = 1 << 20
+
+// Read a whole packet including the length.
+func readPacket(r io.Reader) ([]byte, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(l[:])
+	if n == 0 || n > maxPacket {
+		return nil, fmt.Errorf("illegal sftp packet length %d", n)
+	}
+	pkt := make([]byte, 4+n)
+	copy(pkt, l[:])
+	if _, err := io.ReadFull(r, pkt[4:]); err != nil {
+		return nil, err
+	}
+	return pkt, nil
+}
+
+// A status packet for the request with the given id.
+func statusPacket(id, code uint32, msg string) []byte {
+	pl := 1 + 4 + 4 + 4 + len(msg) + 4
+	pkt := make([]byte, 4+pl)
+	binary.BigEndian.PutUint32(pkt, uint32(pl))
+	pkt[4] = fxpStatus
+	binary.BigEndian.PutUint32(pkt[5:], id)
+	binary.BigEndian.PutUint32(pkt[9:], code)
+	binary.BigEndian.PutUint32(pkt[13:], uint32(len(msg)))
+	copy(pkt[17:], msg)
+	// the language tag is empty
+	return pkt
+}
+
+// a reader for the fields of a packet
+type buffer struct {
+	b   []byte
+	err error
+}
+
+func (b *buffer) uint32() uint32 {
+	if len(b.b) < 4 {
+		b.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.BigEndian.Uint32(b.b)
+	b.b = b.b[4:]
+	return v
+}
+
+func (b *buffer) uint64() uint64 {
+	if len(b.b) < 8 {
+		b.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	v := binary.BigEndian.Uint64(b.b)
+	b.b = b.b[8:]
+	return v
+}
+
+func (b *buffer) string() string {
+	l := b.uint32()
+	if b.err != nil {
+		return ""
+	}
+	if uint32(len(b.b)) < l {
+		b.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	s := string(b.b[:l])
+	b.b = b.b[l:]
+	return s
+}
@@ -0,0 +1,348 @@
+// Package sftp decodes the SFTP protocol between a client and a server,
+// reports the file operations and enforces read-only or path policies.
+package sftp
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+)
+
+// The operations which are reported.
+const (
+	OpOpen    = "open"
+	OpOpendir = "opendir"
+	OpRead    = "read"
+	OpWrite   = "write"
+	OpRemove  = "remove"
+	OpRename  = "rename"
+	OpMkdir   = "mkdir"
+	OpRmdir   = "rmdir"
+	OpSetstat = "setstat"
+	OpSymlink = "symlink"
+	OpLink    = "link"
+)
+
+// An Event is a file operation of the client. A read or write event is
+// reported when the file is closed, the size is the number of transferred
+// bytes.
+type Event struct {
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	Target string `json:"target,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Denied bool   `json:"denied,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// A Policy restricts the operations of the client. With ReadOnly the
+// client cannot change anything, if there are Prefixes the client can
+// only access files below one of them.
+type Policy struct {
+	ReadOnly bool
+	Prefixes []string
+}
+
+// Check if the client may access the path, write is true if the access
+// changes something.
+func (p *Policy) Check(pth string, write bool) error {
+	if write && p.ReadOnly {
+		return fmt.Errorf("the server is read-only")
+	}
+	if len(p.Prefixes) == 0 {
+		return nil
+	}
+	if !path.IsAbs(pth) {
+		return fmt.Errorf("relative paths are not allowed: %s", pth)
+	}
+	c := path.Clean(pth)
+	for _, pre := range p.Prefixes {
+		pre = path.Clean(pre)
+		if c == pre || strings.HasPrefix(c, strings.TrimSuffix(pre, "/")+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not allowed", pth)
+}
+
+// Returns true if the policy restricts the client.
+func (p *Policy) Restricted() bool {
+	return p.ReadOnly || len(p.Prefixes) > 0
+}
+
+// a request which waits for its response
+type request struct {
+	ev    *Event
+	open  bool
+	dir   bool
+	write bool
+	read  string
+}
+
+// an open file or directory of the client
+type file struct {
+	path    string
+	dir     bool
+	read    int64
+	written int64
+}
+
+// A Proxy sits between the client and the server and decodes the packets
+// in both directions.
+type Proxy struct {
+	policy Policy
+	notify func(Event)
+	client io.Writer
+	server io.Writer
+
+	cmux    sync.Mutex
+	mux     sync.Mutex
+	pending map[uint32]*request
+	handles map[string]*file
+}
+
+// Create a new proxy which writes to the client and to the server. Every
+// event is passed to notify.
+func NewProxy(client, server io.Writer, policy Policy, notify func(Event)) *Proxy {
+	return &Proxy{
+		policy:  policy,
+		notify:  notify,
+		client:  client,
+		server:  server,
+		pending: make(map[uint32]*request),
+		handles: make(map[string]*file),
+	}
+}
+
+func (p *Proxy) writeClient(pkt []byte) error {
+	p.cmux.Lock()
+	defer p.cmux.Unlock()
+	_, err := p.client.Write(pkt)
+	return err
+}
+
+// Copy the requests of the client to the server. Requests which are not
+// allowed are answered with a permission denied status.
+func (p *Proxy) ServeRequests(r io.Reader) error {
+	for {
+		pkt, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+		if id, err := p.request(pkt); err != nil {
+			if err := p.writeClient(statusPacket(id, fxPermissionDenied, err.Error())); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := p.server.Write(pkt); err != nil {
+			return err
+		}
+	}
+}
+
+// Copy the responses of the server to the client.
+func (p *Proxy) ServeResponses(r io.Reader) error {
+	for {
+		pkt, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+		p.response(pkt)
+		if err := p.writeClient(pkt); err != nil {
+			return err
+		}
+	}
+}
+
+// Report the transfers of the files which are still open.
+func (p *Proxy) Close() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for h, f := range p.handles {
+		p.transferred(f)
+		delete(p.handles, h)
+	}
+}
+
+func (p *Proxy) transferred(f *file) {
+	if f.dir {
+		return
+	}
+	if f.read > 0 {
+		p.notify(Event{Op: OpRead, Path: f.path, Size: f.read})
+	}
+	if f.written > 0 {
+		p.notify(Event{Op: OpWrite, Path: f.path, Size: f.written})
+	}
+}
+
+// check a request of the client. returns the id of the request and an
+// error if it is not allowed.
+func (p *Proxy) request(pkt []byte) (uint32, error) {
+	tp := pkt[4]
+	if tp == fxpInit {
+		return 0, nil
+	}
+	b := &buffer{b: pkt[5:]}
+	id := b.uint32()
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	var rq *request
+	var err error
+	switch tp {
+	case fxpOpen:
+		pth := b.string()
+		flags := b.uint32()
+		write := flags&(fxfWrite|fxfAppend|fxfCreat|fxfTrunc) != 0
+		rq = &request{ev: &Event{Op: OpOpen, Path: pth}, open: true, write: write}
+		err = p.policy.Check(pth, write)
+	case fxpOpendir:
+		pth := b.string()
+		rq = &request{ev: &Event{Op: OpOpendir, Path: pth}, open: true, dir: true}
+		err = p.policy.Check(pth, false)
+	case fxpRead:
+		rq = &request{read: b.string()}
+	case fxpWrite:
+		h := b.string()
+		b.uint64()
+		data := b.string()
+		if f := p.handles[h]; f != nil && b.err == nil {
+			f.written += int64(len(data))
+		}
+	case fxpClose:
+		h := b.string()
+		if f := p.handles[h]; f != nil {
+			p.transferred(f)
+			delete(p.handles, h)
+		}
+	case fxpRemove, fxpMkdir, fxpRmdir, fxpSetstat:
+		pth := b.string()
+		ops := map[byte]string{fxpRemove: OpRemove, fxpMkdir: OpMkdir, fxpRmdir: OpRmdir, fxpSetstat: OpSetstat}
+		rq = &request{ev: &Event{Op: ops[tp], Path: pth}}
+		err = p.policy.Check(pth, true)
+	case fxpFsetstat:
+		h := b.string()
+		pth := h
+		if f := p.handles[h]; f != nil {
+			pth = f.path
+		}
+		rq = &request{ev: &Event{Op: OpSetstat, Path: pth}}
+		err = p.policy.Check(pth, true)
+	case fxpRename, fxpSymlink:
+		from := b.string()
+		to := b.string()
+		op := OpRename
+		if tp == fxpSymlink {
+			op = OpSymlink
+		}
+		rq = &request{ev: &Event{Op: op, Path: from, Target: to}}
+		err = p.checkBoth(from, to)
+	case fxpReadlink, fxpStat, fxpLstat:
+		err = p.policy.Check(b.string(), false)
+	case fxpRealpath:
+		// the clients ask for the working directory when they start
+		if pth := b.string(); pth != "." {
+			err = p.policy.Check(pth, false)
+		}
+	case fxpExtended:
+		name := b.string()
+		switch name {
+		case "posix-rename@openssh.com", "hardlink@openssh.com":
+			from := b.string()
+			to := b.string()
+			op := OpRename
+			if name == "hardlink@openssh.com" {
+				op = OpLink
+			}
+			rq = &request{ev: &Event{Op: op, Path: from, Target: to}}
+			err = p.checkBoth(from, to)
+		case "lsetstat@openssh.com":
+			pth := b.string()
+			rq = &request{ev: &Event{Op: OpSetstat, Path: pth}}
+			err = p.policy.Check(pth, true)
+		case "statvfs@openssh.com", "expand-path@openssh.com":
+			err = p.policy.Check(b.string(), false)
+		case "fstatvfs@openssh.com", "fsync@openssh.com", "limits@openssh.com":
+			// these use an open handle or no file at all
+		default:
+			// an unknown extension may access any file
+			if p.policy.Restricted() {
+				err = fmt.Errorf("the extension %s is not allowed", name)
+			}
+		}
+	}
+	if b.err != nil {
+		return id, fmt.Errorf("illegal request")
+	}
+	if err != nil {
+		if rq != nil && rq.ev != nil {
+			rq.ev.Denied = true
+			rq.ev.Error = err.Error()
+			p.notify(*rq.ev)
+		}
+		return id, err
+	}
+	if rq != nil {
+		p.pending[id] = rq
+	}
+	return id, nil
+}
+
+func (p *Proxy) checkBoth(from, to string) error {
+	if err := p.policy.Check(from, true); err != nil {
+		return err
+	}
+	return p.policy.Check(to, true)
+}
+
+// track the response of the server for a pending request.
+func (p *Proxy) response(pkt []byte) {
+	tp := pkt[4]
+	if tp == fxpVersion {
+		return
+	}
+	b := &buffer{b: pkt[5:]}
+	id := b.uint32()
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	rq := p.pending[id]
+	if rq == nil {
+		return
+	}
+	delete(p.pending, id)
+	switch tp {
+	case fxpHandle:
+		h := b.string()
+		if rq.open && b.err == nil {
+			p.handles[h] = &file{path: rq.ev.Path, dir: rq.dir}
+		}
+		if rq.ev != nil {
+			p.notify(*rq.ev)
+		}
+	case fxpData:
+		data := b.string()
+		if f := p.handles[rq.read]; f != nil && b.err == nil {
+			f.read += int64(len(data))
+		}
+	case fxpStatus:
+		code := b.uint32()
+		msg := b.string()
+		if rq.ev == nil {
+			return
+		}
+		if code != fxOk {
+			if msg == "" {
+				msg = fmt.Sprintf("status %d", code)
+			}
+			rq.ev.Error = msg
+		}
+		p.notify(*rq.ev)
+	}
+}
@@ -0,0 +1,195 @@
+package sftp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func packet(tp byte, fields ...interface{}) []byte {
+	var b bytes.Buffer
+	b.WriteByte(tp)
+	for _, f := range fields {
+		switch v := f.(type) {
+		case uint32:
+			binary.Write(&b, binary.BigEndian, v)
+		case uint64:
+			binary.Write(&b, binary.BigEndian, v)
+		case string:
+			binary.Write(&b, binary.BigEndian, uint32(len(v)))
+			b.WriteString(v)
+		}
+	}
+	pkt := make([]byte, 4, 4+b.Len())
+	binary.BigEndian.PutUint32(pkt, uint32(b.Len()))
+	return append(pkt, b.Bytes()...)
+}
+
+type testProxy struct {
+	*Proxy
+	client bytes.Buffer
+	server bytes.Buffer
+	events []Event
+}
+
+func newTestProxy(policy Policy) *testProxy {
+	tp := &testProxy{}
+	tp.Proxy = NewProxy(&tp.client, &tp.server, policy, func(e Event) {
+		tp.events = append(tp.events, e)
+	})
+	return tp
+}
+
+func (tp *testProxy) requests(t *testing.T, pkts ...[]byte) {
+	if err := tp.ServeRequests(bytes.NewReader(bytes.Join(pkts, nil))); err != io.EOF {
+		t.Fatalf("serve requests: %v", err)
+	}
+}
+
+func (tp *testProxy) responses(t *testing.T, pkts ...[]byte) {
+	if err := tp.ServeResponses(bytes.NewReader(bytes.Join(pkts, nil))); err != io.EOF {
+		t.Fatalf("serve responses: %v", err)
+	}
+}
+
+func TestTransferEvents(t *testing.T) {
+	tp := newTestProxy(Policy{})
+	tp.requests(t, packet(fxpInit, uint32(3)), packet(fxpOpen, uint32(1), "/data/a.txt", uint32(fxfRead), uint32(0)))
+	tp.responses(t, packet(fxpVersion, uint32(3)), packet(fxpHandle, uint32(1), "h1"))
+	tp.requests(t, packet(fxpRead, uint32(2), "h1", uint64(0), uint32(1024)))
+	tp.responses(t, packet(fxpData, uint32(2), "hello"))
+	tp.requests(t, packet(fxpClose, uint32(3), "h1"))
+
+	tp.requests(t, packet(fxpOpen, uint32(4), "/data/b.txt", uint32(fxfWrite|fxfCreat), uint32(0)))
+	tp.responses(t, packet(fxpHandle, uint32(4), "h2"))
+	tp.requests(t, packet(fxpWrite, uint32(5), "h2", uint64(0), "some data"))
+	tp.requests(t, packet(fxpRename, uint32(6), "/data/c.txt", "/data/d.txt"))
+	tp.responses(t, packet(fxpStatus, uint32(6), uint32(fxNoSuchFile), "no such file", ""))
+	tp.Close()
+
+	expected := []Event{
+		{Op: OpOpen, Path: "/data/a.txt"},
+		{Op: OpRead, Path: "/data/a.txt", Size: 5},
+		{Op: OpOpen, Path: "/data/b.txt"},
+		{Op: OpRename, Path: "/data/c.txt", Target: "/data/d.txt", Error: "no such file"},
+		{Op: OpWrite, Path: "/data/b.txt", Size: 9},
+	}
+	if len(tp.events) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, tp.events)
+	}
+	for i, e := range expected {
+		if tp.events[i] != e {
+			t.Errorf("event %d should be %+v, not %+v", i, e, tp.events[i])
+		}
+	}
+	for _, e := range tp.events {
+		if e.Denied {
+			t.Errorf("%+v should not be denied", e)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	tp := newTestProxy(Policy{ReadOnly: true, Prefixes: []string{"/data"}})
+	allowed := packet(fxpOpen, uint32(1), "/data/a.txt", uint32(fxfRead), uint32(0))
+	tp.requests(t,
+		allowed,
+		packet(fxpOpen, uint32(2), "/data/../etc/passwd", uint32(fxfRead), uint32(0)),
+		packet(fxpOpen, uint32(3), "/data/b.txt", uint32(fxfWrite|fxfTrunc), uint32(0)),
+		packet(fxpRemove, uint32(4), "/data/a.txt"),
+		packet(fxpOpendir, uint32(5), "data"),
+		packet(fxpExtended, uint32(6), "posix-rename@openssh.com", "/data/a", "/data/b"),
+		packet(fxpExtended, uint32(7), "statvfs@openssh.com", "/data"),
+	)
+	if !bytes.Equal(tp.server.Bytes(), append(allowed, packet(fxpExtended, uint32(7), "statvfs@openssh.com", "/data")...)) {
+		t.Errorf("only the allowed requests should be sent to the server")
+	}
+	for id := uint32(2); id <= 6; id++ {
+		pkt, err := readPacket(&tp.client)
+		if err != nil {
+			t.Fatalf("missing status for request %d: %s", id, err)
+		}
+		b := &buffer{b: pkt[5:]}
+		if pkt[4] != fxpStatus || b.uint32() != id || b.uint32() != fxPermissionDenied {
+			t.Errorf("request %d should be denied", id)
+		}
+	}
+	for _, e := range tp.events {
+		if !e.Denied {
+			t.Errorf("%+v should be denied", e)
+		}
+	}
+	if len(tp.events) != 5 {
+		t.Errorf("all denied requests should be reported: %v", tp.events)
+	}
+}
+
+// the ids of the requests which were denied
+func (tp *testProxy) denied(t *testing.T) []uint32 {
+	var ids []uint32
+	for tp.client.Len() > 0 {
+		pkt, err := readPacket(&tp.client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := &buffer{b: pkt[5:]}
+		if id := b.uint32(); pkt[4] == fxpStatus && b.uint32() == fxPermissionDenied {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestPathsOfRequests(t *testing.T) {
+	tp := newTestProxy(Policy{Prefixes: []string{"/data"}})
+	tp.requests(t,
+		packet(fxpStat, uint32(1), "/data/a.txt"),
+		packet(fxpStat, uint32(2), "/etc/passwd"),
+		packet(fxpLstat, uint32(3), "/data/../etc/shadow"),
+		packet(fxpRealpath, uint32(4), "."),
+		packet(fxpRealpath, uint32(5), "/root"),
+		packet(fxpRealpath, uint32(6), "/data/sub"),
+		packet(fxpExtended, uint32(7), "lsetstat@openssh.com", "/etc/passwd", uint32(0)),
+		packet(fxpExtended, uint32(8), "lsetstat@openssh.com", "/data/a.txt", uint32(0)),
+		packet(fxpExtended, uint32(9), "statvfs@openssh.com", "/"),
+		packet(fxpExtended, uint32(10), "expand-path@openssh.com", "~/secret"),
+	)
+	ids := tp.denied(t)
+	expected := []uint32{2, 3, 5, 7, 9, 10}
+	if len(ids) != len(expected) {
+		t.Fatalf("the requests %v should be denied, not %v", expected, ids)
+	}
+	for i, id := range expected {
+		if ids[i] != id {
+			t.Errorf("the requests %v should be denied, not %v", expected, ids)
+		}
+	}
+	if len(tp.events) != 1 || tp.events[0].Op != OpSetstat || tp.events[0].Path != "/etc/passwd" || !tp.events[0].Denied {
+		t.Errorf("the denied lsetstat should be reported: %v", tp.events)
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	unknown := packet(fxpExtended, uint32(1), "copy-data", "h1", uint64(0), uint64(0), "h2", uint64(0))
+	home := packet(fxpExtended, uint32(2), "home-directory", "root")
+	limits := packet(fxpExtended, uint32(3), "limits@openssh.com")
+	fsync := packet(fxpExtended, uint32(4), "fsync@openssh.com", "h1")
+
+	for _, policy := range []Policy{{Prefixes: []string{"/data"}}, {ReadOnly: true}} {
+		tp := newTestProxy(policy)
+		tp.requests(t, unknown, home, limits, fsync)
+		if ids := tp.denied(t); len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+			t.Errorf("the unknown extensions should be denied with %+v: %v", policy, ids)
+		}
+		if !bytes.Equal(tp.server.Bytes(), append(limits, fsync...)) {
+			t.Errorf("the known extensions should be sent to the server with %+v", policy)
+		}
+	}
+
+	tp := newTestProxy(Policy{})
+	tp.requests(t, unknown, home)
+	if tp.client.Len() != 0 || !bytes.Equal(tp.server.Bytes(), append(unknown, home...)) {
+		t.Errorf("without a policy all extensions should be allowed")
+	}
+}
@@ -1,9 +1,8 @@
 package etcd
 
 import (
-	"crypto/rand"
-	"encoding/base32"
 	"fmt"
+	"net/url"
 	"strings"
 	"time"
 
@@ -14,16 +13,17 @@ import (
 	. "github.com/clusterit/orca/users"
 	etcderr "github.com/coreos/etcd/error"
 	goetcd "github.com/coreos/go-etcd/etcd"
-	"github.com/dgryski/dgoogauth"
 )
 
 const (
-	usersPath  = "/users"
-	aliasPath  = "/alias"
-	keysPath   = "/keys"
-	permitPath = "/permit"
-	twofaPath  = "/2fa"
-	idtoksPath = "/idtoks"
+	usersPath   = "/users"
+	aliasPath   = "/alias"
+	keysPath    = "/keys"
+	permitPath  = "/permit"
+	twofaPath   = "/2fa"
+	usedPath    = "/2faused"
+	scratchPath = "/2fascratch"
+	idtoksPath  = "/idtoks"
 )
 
 var (
@@ -37,9 +37,10 @@ type etcdUsers struct {
 	al     etcd.Persister
 	twofa  etcd.Persister
 	idtoks etcd.Persister
-
-	// used for testing of 2FA
-	scratchCodes []int
+	// the time steps of the used TOTP codes
+	used etcd.Persister
+	// the hashes of the scratch codes
+	scratch etcd.Persister
 }
 
 func New(cl *etcd.Cluster) (Users, error) {
@@ -59,7 +60,7 @@ func New(cl *etcd.Cluster) (Users, error) {
 	if e != nil {
 		return nil, e
 	}
-	twofa, e := cl.NewJsonPersister("/data" + twofaPath)
+	twofa, e := cl.NewSecretPersister("/data" + twofaPath)
 	if e != nil {
 		return nil, e
 	}
@@ -67,7 +68,39 @@ func New(cl *etcd.Cluster) (Users, error) {
 	if e != nil {
 		return nil, e
 	}
-	return &etcdUsers{up: up, kp: kp, pm: pm, al: al, twofa: twofa, idtoks: idtoks}, nil
+	used, e := cl.NewJsonPersister("/data" + usedPath)
+	if e != nil {
+		return nil, e
+	}
+	scratch, e := cl.NewJsonPersister("/data" + scratchPath)
+	if e != nil {
+		return nil, e
+	}
+	return &etcdUsers{up: up, kp: kp, pm: pm, al: al, twofa: twofa, idtoks: idtoks, used: used, scratch: scratch}, nil
+}
+
+// Write the 2FA secrets of all users again, so the plaintext secrets are
+// sealed with the keyring of the cluster. Returns the number of written
+// secrets.
+func SealSecrets(cl *etcd.Cluster) (int, error) {
+	twofa, err := cl.NewSecretPersister("/data" + twofaPath)
+	if err != nil {
+		return 0, err
+	}
+	uids, err := twofa.Ls("")
+	if err != nil {
+		return 0, err
+	}
+	for i, uid := range uids {
+		var secret string
+		if err := twofa.Get(uid, &secret); err != nil {
+			return i, err
+		}
+		if err := twofa.Put(uid, secret); err != nil {
+			return i, err
+		}
+	}
+	return len(uids), nil
 }
 
 func (eu *etcdUsers) key(k *Key) string {
@@ -222,6 +255,10 @@ func (eu *etcdUsers) GetByKey(pubkey string) (*User, *Key, error) {
 
 func (eu *etcdUsers) AddKey(uid, kid string, pubkey string, fp string) (*Key, error) {
 	k := Key{Id: kid, Fingerprint: fp, Value: pubkey}
+	if pk, err := ParseKey(pubkey); err == nil {
+		k.Value = pk.Value
+		k.FingerprintSHA256 = pk.FingerprintSHA256
+	}
 	u, err := eu.Get(uid)
 	if err != nil {
 		return nil, err
@@ -272,6 +309,26 @@ func (eu *etcdUsers) RemoveKey(uid, kid string) (*Key, error) {
 	return &found, eu.up.Put(uid, &u)
 }
 
+// Replace the options of a key of the user.
+func (eu *etcdUsers) SetKeyOptions(uid, kid string, opts []string) (*Key, error) {
+	u, err := eu.Get(uid)
+	if err != nil {
+		return nil, err
+	}
+	for i := range u.Keys {
+		k := &u.Keys[i]
+		if k.Id != kid {
+			continue
+		}
+		if err := CheckKeyOptions(k.Value, opts); err != nil {
+			return nil, err
+		}
+		k.Options = opts
+		return k, eu.up.Put(u.Id, u)
+	}
+	return nil, common.ErrNotFound
+}
+
 func (eu *etcdUsers) Update(uid, username string, rolz Roles) (*User, error) {
 	u, err := eu.Get(uid)
 	if err != nil {
@@ -319,12 +376,10 @@ func (eu *etcdUsers) Create2FAToken(domain, uid string) (string, error) {
 	if e != nil {
 		return "", e
 	}
-	sec := make([]byte, 6)
-	_, err := rand.Read(sec)
+	encodedSecret, err := NewTotpSecret()
 	if err != nil {
 		return "", err
 	}
-	encodedSecret := base32.StdEncoding.EncodeToString(sec)
 	if err := eu.twofa.Put(uid, encodedSecret); err != nil {
 		return "", err
 	}
@@ -356,28 +411,108 @@ func (eu *etcdUsers) CheckAndAllowToken(uid, token string, maxAllowance int) err
 	return nil
 }
 
+// Check a TOTP code or a scratch code of the user. Both can only be used
+// once: the time step of a TOTP code is remembered until the code is
+// outdated, a scratch code is removed.
 func (eu *etcdUsers) CheckToken(uid, token string) error {
 	var secret string
 	if err := eu.twofa.Get(uid, &secret); err != nil {
 		return err
 	}
-
-	otpc := &dgoogauth.OTPConfig{
-		Secret:       secret,
-		WindowSize:   3,
-		HotpCounter:  0,
-		ScratchCodes: eu.scratchCodes,
+	if IsScratchCode(token) {
+		return eu.useScratchCode(uid, token)
+	}
+	step, err := TotpCodeStep(secret, token, time.Now())
+	if err != nil {
+		return err
+	}
+	// the create fails if the step is already there
+	k := eu.used.Path(fmt.Sprintf("%s/%d", url.QueryEscape(uid), step))
+	if _, err := eu.used.RawClient().Create(k, "", (2*TotpWindow+2)*TotpStep); err != nil {
+		if cerr, ok := err.(*goetcd.EtcdError); ok && cerr.ErrorCode == etcderr.EcodeNodeExist {
+			return fmt.Errorf("the token was already used")
+		}
+		return err
 	}
-	ok, err := otpc.Authenticate(token)
+	return nil
+}
+
+// The scratch codes are stored with their hashes as keys, the hash of the
+// code is the key of the code if it is valid.
+func (eu *etcdUsers) useScratchCode(uid, code string) error {
+	dir := url.QueryEscape(uid)
+	hashes, err := eu.scratch.Ls(dir)
 	if err != nil {
+		if common.IsNotFound(err) {
+			return fmt.Errorf("invalid token")
+		}
 		return err
 	}
-	if !ok {
+	if len(hashes) == 0 {
 		return fmt.Errorf("invalid token")
 	}
+	// all codes share the salt
+	var sc ScratchCode
+	if err := eu.scratch.Get(dir+"/"+strings.TrimPrefix(hashes[0], "/"), &sc); err != nil {
+		return err
+	}
+	h, err := sc.HashOf(code)
+	if err != nil {
+		return err
+	}
+	// only one of concurrent logins can remove the code
+	if err := eu.scratch.Remove(dir + "/" + h); err != nil {
+		if cerr, ok := err.(*goetcd.EtcdError); ok && cerr.ErrorCode == etcderr.EcodeKeyNotFound {
+			return fmt.Errorf("invalid token")
+		}
+		return err
+	}
+	logger.Infof("scratch code used by %s", uid)
 	return nil
 }
 
+// Replace the scratch codes of the user with new ones. The codes are
+// returned only this time.
+func (eu *etcdUsers) CreateScratchCodes(uid string) ([]string, error) {
+	u, err := eu.Get(uid)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := NewScratchCodes()
+	if err != nil {
+		return nil, err
+	}
+	hashed, err := HashScratchCodes(codes)
+	if err != nil {
+		return nil, err
+	}
+	dir := url.QueryEscape(u.Id)
+	eu.scratch.RemoveDir(dir)
+	for _, sc := range hashed {
+		if err := eu.scratch.Put(dir+"/"+sc.Hash, sc); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// Remove the secret and the scratch codes of the user and switch 2FA
+// off, e.g. when the user has lost the phone.
+func (eu *etcdUsers) Reset2FA(uid string) (*User, error) {
+	u, err := eu.Get(uid)
+	if err != nil {
+		return nil, err
+	}
+	eu.scratch.RemoveDir(url.QueryEscape(u.Id))
+	eu.pm.Remove(u.Id)
+	if err := eu.Use2FAToken(u.Id, false); err != nil {
+		return nil, err
+	}
+	u.Use2FA = false
+	u.Allowance = nil
+	return u, nil
+}
+
 func (eu *etcdUsers) Use2FAToken(uid string, use bool) error {
 	u, e := eu.Get(uid)
 	if e != nil {
@@ -386,6 +521,7 @@ func (eu *etcdUsers) Use2FAToken(uid string, use bool) error {
 	u.Use2FA = use
 	if !use {
 		eu.twofa.Remove(u.Id)
+		eu.scratch.RemoveDir(url.QueryEscape(u.Id))
 	}
 	return eu.up.Put(u.Id, u)
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/clusterit/orca/testsupport"
 	"github.com/clusterit/orca/users"
+	"github.com/dgryski/dgoogauth"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -91,16 +92,19 @@ func TestUser(t *testing.T) {
 			})
 		})
 		Convey("enable 2FA and tokencheck", func() {
-			uimpl := userimpl.(*etcdUsers)
-			scratchToken := 12121212
-			uimpl.scratchCodes = []int{scratchToken}
 			url, err := userimpl.Create2FAToken("mydomain", "myid@mynetwork")
 			So(err, ShouldBeNil)
 			So(url, ShouldContainSubstring, "myname")
 			So(url, ShouldContainSubstring, "mydomain")
+			codes, err := userimpl.CreateScratchCodes("myid@mynetwork")
+			So(err, ShouldBeNil)
+			So(len(codes), ShouldEqual, users.ScratchCodeCount)
 			// the next check only tests if there is a secreot for the user
-			err = userimpl.CheckToken("myid@mynetwork", fmt.Sprintf("%d", scratchToken))
+			err = userimpl.CheckToken("myid@mynetwork", codes[0])
 			So(err, ShouldBeNil)
+			// a scratch code can only be used once
+			err = userimpl.CheckToken("myid@mynetwork", codes[0])
+			So(err, ShouldNotBeNil)
 			err = userimpl.Use2FAToken("myid@mynetwork", true)
 			So(err, ShouldBeNil)
 			u, err := userimpl.SetAutologinAfter2FA("myid@mynetwork", 10)
@@ -108,12 +112,29 @@ func TestUser(t *testing.T) {
 			So(u.Use2FA, ShouldBeTrue)
 			So(u.AutologinAfter2FA, ShouldEqual, 10)
 			n := time.Now()
-			err = userimpl.CheckAndAllowToken("myid@mynetwork", fmt.Sprintf("%d", scratchToken), 100)
+			err = userimpl.CheckAndAllowToken("myid@mynetwork", codes[1], 100)
 			So(err, ShouldBeNil)
 			u, err = userimpl.Get("myid@mynetwork")
 			So(err, ShouldBeNil)
 			So(u.Allowance, ShouldNotBeNil)
 			So(u.Allowance.Until, ShouldHappenBetween, n, n.Add(12*time.Second))
+			Convey("a TOTP code can only be used once", func() {
+				var secret string
+				So(userimpl.(*etcdUsers).twofa.Get("myid@mynetwork", &secret), ShouldBeNil)
+				code := fmt.Sprintf("%06d", dgoogauth.ComputeCode(secret, time.Now().Unix()/users.TotpStep))
+				So(userimpl.CheckToken("myid@mynetwork", code), ShouldBeNil)
+				So(userimpl.CheckToken("myid@mynetwork", code), ShouldNotBeNil)
+			})
+			Convey("a reset removes the secret and the scratch codes", func() {
+				u, err := userimpl.Reset2FA("myid@mynetwork")
+				So(err, ShouldBeNil)
+				So(u.Use2FA, ShouldBeFalse)
+				So(userimpl.CheckToken("myid@mynetwork", codes[2]), ShouldNotBeNil)
+				u, err = userimpl.Get("myid@mynetwork")
+				So(err, ShouldBeNil)
+				So(u.Use2FA, ShouldBeFalse)
+				So(u.Allowance, ShouldBeNil)
+			})
 		})
 		Convey("add two keys", func() {
 			pk, err := users.ParseKey(pubkey)
@@ -1,23 +1,29 @@
 package users
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"code.google.com/p/rsc/qr"
 
+	"github.com/clusterit/orca/audit"
 	"github.com/clusterit/orca/auth"
 	"github.com/clusterit/orca/common"
 	"github.com/clusterit/orca/config"
+	"github.com/clusterit/orca/logging"
 	"github.com/clusterit/orca/rest"
 	"gopkg.in/emicklei/go-restful.v1"
 )
 
+var logger = logging.Simple()
+
 type UsersService struct {
 	Auth     auth.Auther
 	Provider Users
 	Config   config.Configer
+	Auditor  audit.Auditor
 }
 
 type CheckedUser func(f UserFunction) restful.RouteFunction
@@ -120,6 +126,13 @@ func (t *UsersService) Register(root string, c *restful.Container) {
 		Param(ws.PathParameter("key-id", "the key-id of the new key").DataType("string")).
 		Operation("deleteUserKey").
 		Returns(200, "OK", Key{}))
+	ws.Route(ws.PUT("/{user-id}/keys/{key-id}/options").To(manager(t.setKeyOptions)).
+		Doc("replace the options of a security key of the given user, e.g. verify-required for a key which was generated with it").
+		Param(ws.PathParameter("user-id", "identifier of the user").DataType("string")).
+		Param(ws.PathParameter("key-id", "the key-id of the key").DataType("string")).
+		Operation("setKeyOptions").
+		Reads([]string{}).
+		Returns(200, "OK", Key{}))
 	ws.Route(ws.GET("/2fatoken").To(userRoles(t.gen2FAtoken)).
 		Doc("generates a 2FA token for the current user and returns an PNG encoded image with the secret").
 		Operation("gen2FAtoken").
@@ -140,6 +153,15 @@ func (t *UsersService) Register(root string, c *restful.Container) {
 		Operation("use2fa").
 		Reads("").
 		Returns(200, "OK", User{}))
+	ws.Route(ws.POST("/2fa/scratchcodes").To(userRoles(t.scratchCodes)).
+		Doc("replaces the scratch codes of the current user and returns the new codes; they cannot be read again").
+		Operation("scratchCodes").
+		Returns(200, "OK", []string{}))
+	ws.Route(ws.DELETE("/{user-id}/2fa").To(manager(t.reset2fa)).
+		Doc("removes the 2FA secret and the scratch codes of the given user and disables 2fa, e.g. when the user lost the phone").
+		Param(ws.PathParameter("user-id", "identifier of the user").DataType("string")).
+		Operation("reset2fa").
+		Returns(200, "OK", User{}))
 	ws.Route(ws.PATCH("/autologin2fa/{duration}").To(userRoles(t.autologin2fa)).
 		Doc("updates the duration for which a 2FA is not necessary").
 		Param(ws.PathParameter("duration", "the duration in seconds within a new OTP is not requred").DataType("int")).
@@ -273,6 +295,28 @@ func (t *UsersService) deleteUserKey(me *User, request *restful.Request, respons
 	response.WriteEntity(k)
 }
 
+func (t *UsersService) setKeyOptions(me *User, request *restful.Request, response *restful.Response) {
+	uid := request.PathParameter("user-id")
+	kid := request.PathParameter("key-id")
+	var opts []string
+	if err := request.ReadEntity(&opts); err != nil {
+		response.WriteError(http.StatusBadRequest, err)
+		return
+	}
+	k, err := t.Provider.SetKeyOptions(uid, kid, opts)
+	if err != nil {
+		rest.HandleError(err, response)
+		return
+	}
+	if t.Auditor != nil {
+		ev := audit.Event{User: uid, Type: audit.KeyOptions, Target: kid, Allowed: true, Message: fmt.Sprintf("options %v set by %s", opts, me.Id)}
+		if err := t.Auditor.Record(ev); err != nil {
+			logger.Warnf("cannot audit the key options of %s: %s", uid, err)
+		}
+	}
+	response.WriteEntity(k)
+}
+
 func (t *UsersService) addAlias(me *User, request *restful.Request, response *restful.Response) {
 	alias := request.PathParameter("alias")
 	network := request.PathParameter("network")
@@ -351,6 +395,26 @@ func (t *UsersService) gen2FAtoken(me *User, request *restful.Request, response
 	response.WriteEntity(code.PNG())
 }
 
+func (t *UsersService) scratchCodes(me *User, request *restful.Request, response *restful.Response) {
+	rest.HandleEntity(t.Provider.CreateScratchCodes(me.Id))(request, response)
+}
+
+func (t *UsersService) reset2fa(me *User, request *restful.Request, response *restful.Response) {
+	uid := request.PathParameter("user-id")
+	u, err := t.Provider.Reset2FA(uid)
+	if err != nil {
+		rest.HandleError(err, response)
+		return
+	}
+	if t.Auditor != nil {
+		ev := audit.Event{User: u.Id, Type: audit.Reset2FA, Target: u.Id, Allowed: true, Message: "reset by " + me.Id}
+		if err := t.Auditor.Record(ev); err != nil {
+			logger.Warnf("cannot audit the 2FA reset of %s: %s", u.Id, err)
+		}
+	}
+	response.WriteEntity(u)
+}
+
 func (t *UsersService) autologin2fa(me *User, request *restful.Request, response *restful.Response) {
 	dur := request.PathParameter("duration")
 	duration, err := strconv.ParseInt(dur, 10, 0)
@@ -61,9 +61,12 @@ type mockusers struct {
 	getbykey             func(string) (*User, *Key, error)
 	addkey               func(string, string, string, string) (*Key, error)
 	removekey            func(string, string) (*Key, error)
+	setkeyoptions        func(string, string, []string) (*Key, error)
 	setautologinafter2fa func(string, int) (*User, error)
 	checkandallowtoken   func(string, string, int) error
 	checktoken           func(string, string) error
+	createscratchcodes   func(string) ([]string, error)
+	reset2fa             func(string) (*User, error)
 }
 
 func (m *mockusers) Create(network, id, name string, rolzs Roles) (*User, error) {
@@ -120,6 +123,15 @@ func (m *mockusers) CheckToken(uid, token string) error {
 func (m *mockusers) CheckAndAllowToken(uid, token string, maxAllowance int) error {
 	return m.checkandallowtoken(uid, token, maxAllowance)
 }
+func (m *mockusers) CreateScratchCodes(uid string) ([]string, error) {
+	return m.createscratchcodes(uid)
+}
+func (m *mockusers) SetKeyOptions(uid, kid string, opts []string) (*Key, error) {
+	return m.setkeyoptions(uid, kid, opts)
+}
+func (m *mockusers) Reset2FA(uid string) (*User, error) {
+	return m.reset2fa(uid)
+}
 func (m *mockusers) Close() error {
 	return nil
 }
@@ -209,6 +221,15 @@ func newUsers() Users {
 		}
 		return nil, fmt.Errorf("wrong key id")
 	}
+	userimpl.setkeyoptions = func(uid, kid string, opts []string) (*Key, error) {
+		if err := CheckKeyOptions(testsk_value, opts); err != nil {
+			return nil, err
+		}
+		k, e := ParseKey(testsk_value)
+		k.Id = kid
+		k.Options = opts
+		return k, e
+	}
 	userimpl.setautologinafter2fa = func(uid string, duration int) (*User, error) {
 		u, ok := usermap[uid]
 		if !ok {
@@ -217,6 +238,17 @@ func newUsers() Users {
 		u.AutologinAfter2FA = duration
 		return &u, nil
 	}
+	userimpl.createscratchcodes = func(uid string) ([]string, error) {
+		return []string{"12345678", "87654321"}, nil
+	}
+	userimpl.reset2fa = func(uid string) (*User, error) {
+		u, ok := usermap[uid]
+		if !ok {
+			return nil, fmt.Errorf("unknown userid %s", uid)
+		}
+		u.Use2FA = false
+		return &u, nil
+	}
 	return &userimpl
 }
 
@@ -438,5 +470,39 @@ func TestUserServices(t *testing.T) {
 			res, _ = createRequest(ts, "GET", "/api/users/user2/token/check?maxtime=a00", "user2", nil)
 			So(res.StatusCode, ShouldEqual, http.StatusInternalServerError)
 		})
+		Convey("create scratch codes", func() {
+			res, err := createRequest(ts, "POST", "/api/users/2fa/scratchcodes", "user2", nil)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var codes []string
+			err = json.NewDecoder(res.Body).Decode(&codes)
+			So(err, ShouldBeNil)
+			So(len(codes), ShouldEqual, 2)
+		})
+		Convey("only managers can set the options of a key", func() {
+			opts := []string{KeyOptVerifyRequired}
+			res, _ := createRequest(ts, "PUT", "/api/users/user2/keys/yubikey/options", "user2", opts)
+			So(res.StatusCode, ShouldEqual, http.StatusForbidden)
+			res, _ = createRequest(ts, "PUT", "/api/users/user2/keys/yubikey/options", "adminid", opts)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var k Key
+			err := json.NewDecoder(res.Body).Decode(&k)
+			So(err, ShouldBeNil)
+			So(k.Id, ShouldEqual, "yubikey")
+			So(k.Options, ShouldResemble, opts)
+			res, _ = createRequest(ts, "PUT", "/api/users/user2/keys/yubikey/options", "adminid", []string{"permit-pty"})
+			So(res.StatusCode, ShouldNotEqual, http.StatusOK)
+		})
+		Convey("only managers can reset the 2FA of a user", func() {
+			res, _ := createRequest(ts, "DELETE", "/api/users/user1/2fa", "user2", nil)
+			So(res.StatusCode, ShouldEqual, http.StatusForbidden)
+			res, _ = createRequest(ts, "DELETE", "/api/users/user1/2fa", "adminid", nil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			var u User
+			err := json.NewDecoder(res.Body).Decode(&u)
+			So(err, ShouldBeNil)
+			So(u.Id, ShouldEqual, "user1")
+			So(u.Use2FA, ShouldBeFalse)
+		})
 	})
 }
@@ -0,0 +1,144 @@
+package users
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/dgryski/dgoogauth"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// the size of a TOTP secret in bytes (RFC 4226 recommends 160 bits)
+	TotpSecretSize = 20
+	// the seconds of a time step of the TOTP codes
+	TotpStep = 30
+	// the codes of this many time steps before and after the current
+	// one are accepted too, so the clock of the phone may be a bit off
+	TotpWindow = 1
+
+	// the number of scratch codes of a user
+	ScratchCodeCount = 10
+	scratchCodeLen   = 8
+
+	// a scratch code has only 8 digits, so the hash is salted and slow to
+	// keep the codes from being guessed with the stored hashes
+	scratchSaltSize = 16
+	scratchHashSize = 32
+	scratchCostN    = 1 << 15
+	scratchCostR    = 8
+	scratchCostP    = 1
+)
+
+// A new random TOTP secret, base32 encoded for the authenticator apps.
+func NewTotpSecret() (string, error) {
+	sec := make([]byte, TotpSecretSize)
+	if _, err := rand.Read(sec); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(sec), nil
+}
+
+// Check a TOTP code at the given time and return the time step it
+// belongs to. A code may only be used once, so the caller must remember
+// the step.
+func TotpCodeStep(secret, code string, now time.Time) (int64, error) {
+	if len(code) != 6 {
+		return 0, fmt.Errorf("invalid token")
+	}
+	c, err := strconv.Atoi(code)
+	if err != nil {
+		return 0, fmt.Errorf("invalid token")
+	}
+	step := now.Unix() / TotpStep
+	for s := step - TotpWindow; s <= step+TotpWindow; s++ {
+		if dgoogauth.ComputeCode(secret, s) == c {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid token")
+}
+
+// Returns true if the code looks like a scratch code and not like a TOTP
+// code.
+func IsScratchCode(code string) bool {
+	if len(code) != scratchCodeLen {
+		return false
+	}
+	_, err := strconv.Atoi(code)
+	return err == nil
+}
+
+// New random scratch codes, the user keeps them for the case the phone
+// is lost. Only their hashes are stored.
+func NewScratchCodes() ([]string, error) {
+	max := big.NewInt(100000000)
+	res := make([]string, ScratchCodeCount)
+	for i := range res {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = fmt.Sprintf("%0*d", scratchCodeLen, n)
+	}
+	return res, nil
+}
+
+// The stored form of a scratch code: the scrypt hash of the code. The
+// codes of a user share one salt, so a try costs one hash whatever the
+// number of codes and the hash can be the key of the stored code.
+type ScratchCode struct {
+	Salt    string    `json:"salt"`
+	Hash    string    `json:"hash"`
+	Created time.Time `json:"created"`
+}
+
+func scratchCodeHash(code string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(code), salt, scratchCostN, scratchCostR, scratchCostP, scratchHashSize)
+}
+
+// Hash the scratch codes of a user with a new random salt.
+func HashScratchCodes(codes []string) ([]*ScratchCode, error) {
+	salt := make([]byte, scratchSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	res := make([]*ScratchCode, len(codes))
+	for i, c := range codes {
+		h, err := scratchCodeHash(c, salt)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = &ScratchCode{Salt: hex.EncodeToString(salt), Hash: hex.EncodeToString(h), Created: now}
+	}
+	return res, nil
+}
+
+// The hash of the code with the salt of the stored code.
+func (sc *ScratchCode) HashOf(code string) (string, error) {
+	salt, err := hex.DecodeString(sc.Salt)
+	if err != nil {
+		return "", err
+	}
+	h, err := scratchCodeHash(code, salt)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h), nil
+}
+
+// Returns true if the hash belongs to the code.
+func (sc *ScratchCode) Matches(code string) bool {
+	h, err := sc.HashOf(code)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(h), []byte(sc.Hash)) == 1
+}
@@ -35,11 +35,22 @@ type User struct {
 }
 
 type Key struct {
-	Id          string `json:"id"`
-	Value       string `json:"value"`
-	Fingerprint string `json:"fingerprint"`
+	Id                string   `json:"id"`
+	Value             string   `json:"value"`
+	Fingerprint       string   `json:"fingerprint"`
+	FingerprintSHA256 string   `json:"fingerprintsha256"`
+	Options           []string `json:"options,omitempty"`
 }
 
+// The options of a security key which say how the key signs: with
+// verify-required the authenticator checks a PIN or a fingerprint, with
+// no-touch-required the user does not need to touch it. Only managers set
+// them, the gateway cannot check the flags of the signatures.
+const (
+	KeyOptVerifyRequired  = "verify-required"
+	KeyOptNoTouchRequired = "no-touch-required"
+)
+
 type Allowance struct {
 	GrantedBy string    `json:"grantedBy"`
 	Uid       string    `json:"uid"`
@@ -61,10 +72,13 @@ type Users interface {
 	Delete(uid string) (*User, error)
 	GetByKey(pubkey string) (*User, *Key, error)
 	Create2FAToken(domain, uid string) (string, error)
+	SetKeyOptions(uid, kid string, opts []string) (*Key, error)
 	SetAutologinAfter2FA(uid string, duration int) (*User, error)
 	Use2FAToken(uid string, use bool) error
 	CheckToken(uid, token string) error
 	CheckAndAllowToken(uid, token string, maxAllowance int) error
+	CreateScratchCodes(uid string) ([]string, error)
+	Reset2FA(uid string) (*User, error)
 	Close() error
 }
 
@@ -90,16 +104,76 @@ func Fingerprint(k ssh.PublicKey) string {
 	return strings.Replace(fmt.Sprintf("% x", hash), " ", ":", -1)
 }
 
+// The fingerprint of the key in the format of OpenSSH 6.8 and newer.
+func FingerprintSHA256(k ssh.PublicKey) string {
+	return ssh.FingerprintSHA256(k)
+}
+
+// Returns true if the key (or the key of the certificate) is a FIDO2
+// security key.
+func IsSecurityKey(k ssh.PublicKey) bool {
+	if cert, ok := k.(*ssh.Certificate); ok {
+		k = cert.Key
+	}
+	switch k.Type() {
+	case ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256:
+		return true
+	}
+	return false
+}
+
+// Parse a line of an authorized_keys file, the options are dropped.
 func ParseKey(pubkey string) (*Key, error) {
 	pk, c, _, _, err := ssh.ParseAuthorizedKey([]byte(pubkey))
 	if err != nil {
 		return nil, err
 	}
 	fp := Fingerprint(pk)
-	k := Key{Id: c, Value: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pk))), Fingerprint: fp}
+	k := Key{Id: c, Value: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pk))), Fingerprint: fp, FingerprintSHA256: FingerprintSHA256(pk)}
 	return &k, nil
 }
 
+// Check the options for the key: only security keys have options and
+// only the known ones.
+func CheckKeyOptions(value string, opts []string) error {
+	if len(opts) == 0 {
+		return nil
+	}
+	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(value))
+	if err != nil {
+		return err
+	}
+	if !IsSecurityKey(pk) {
+		return fmt.Errorf("only security keys have options, %s is not one", pk.Type())
+	}
+	for _, o := range opts {
+		if o != KeyOptVerifyRequired && o != KeyOptNoTouchRequired {
+			return fmt.Errorf("unknown key option %q", o)
+		}
+	}
+	return nil
+}
+
+// Returns true if the key was registered with the option.
+func (k *Key) HasOption(opt string) bool {
+	for _, o := range k.Options {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// The registered key of the user with the given value.
+func (u *User) Key(value string) *Key {
+	for i := range u.Keys {
+		if u.Keys[i].Value == value {
+			return &u.Keys[i]
+		}
+	}
+	return nil
+}
+
 func AsKey(usrs Users, uid, kid, pubkey string) (*Key, error) {
 	k, err := ParseKey(pubkey)
 	if err != nil {
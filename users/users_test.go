@@ -1,9 +1,13 @@
 package users
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/dgryski/dgoogauth"
 	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ssh"
 )
 
 const (
@@ -13,6 +17,8 @@ const (
 	testpk2_value  = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQDyLg8zuWzJOgcTru78NkhhDsa+tjasjrJGoJbhBRMHrxgwdgUF5ZKGsV2LWTZgp8rIDUjHRGWSlvTrpXCG33wmRJrXwxYG3J0QeOAYRlMD3ESBVtPWm2iqA02PzpL7+mnmV79Ml3Q8yUz8Ef5Bs+lytVAw42IhfTEfJyWM9zsjFEW/NvZ6cttrOUhwEQ1r9HvY0UDyHRA3sW0B3I2KfYg1Z1e5wlKDd7dGI9u/S9E9JwFpeh/AXjPiN/Vd2xInIh99G9HsWBdpTaNlYXZj6Qnx/wLcCm2v7U9WdIvM5M+xqiYZ6pxGUtsBDgBjraxh8tRWV3eab3stZsKnwQthyp4P"
 	testpk2_pubkey = testpk2_value + " title2"
 	testpk2_fp     = "f2:97:4e:2f:9e:a8:52:cd:c1:6d:62:f3:a7:69:b5:cc"
+	testsk_value   = "sk-ssh-ed25519@openssh.com AAAAGnNrLXNzaC1lZDI1NTE5QG9wZW5zc2guY29tAAAAIL5KDpQUma+3ahn5i06mU+U6bsnEfvf3GAkxQ+ImgjStAAAABHNzaDo="
+	testsk_sha256  = "SHA256:UjRiszSdt9ea1tgRfzCkl3otJ4sh3w5Wy7/UYAAdsGQ"
 )
 
 func TestSSHKeys(t *testing.T) {
@@ -23,6 +29,35 @@ func TestSSHKeys(t *testing.T) {
 		So(k.Id, ShouldEqual, "title")
 		So(k.Value, ShouldEqual, testpk_value)
 	})
+	Convey("Parseing security keys", t, func() {
+		k, e := ParseKey(testsk_value + " yubikey")
+		So(e, ShouldBeNil)
+		So(k.Id, ShouldEqual, "yubikey")
+		So(k.Value, ShouldEqual, testsk_value)
+		So(k.FingerprintSHA256, ShouldEqual, testsk_sha256)
+		pk, _, _, _, e := ssh.ParseAuthorizedKey([]byte(testsk_value))
+		So(e, ShouldBeNil)
+		So(IsSecurityKey(pk), ShouldBeTrue)
+		pk, _, _, _, e = ssh.ParseAuthorizedKey([]byte(testpk_value))
+		So(e, ShouldBeNil)
+		So(IsSecurityKey(pk), ShouldBeFalse)
+	})
+	Convey("The options of security keys", t, func() {
+		k, e := ParseKey("verify-required,command=\"ls\" " + testsk_value + " yubikey")
+		So(e, ShouldBeNil)
+		So(k.Value, ShouldEqual, testsk_value)
+		So(k.Options, ShouldBeEmpty)
+		So(CheckKeyOptions(testsk_value, []string{KeyOptVerifyRequired, KeyOptNoTouchRequired}), ShouldBeNil)
+		So(CheckKeyOptions(testsk_value, []string{"permit-pty"}), ShouldNotBeNil)
+		So(CheckKeyOptions(testpk_value, []string{KeyOptVerifyRequired}), ShouldNotBeNil)
+		So(CheckKeyOptions(testpk_value, nil), ShouldBeNil)
+		k.Options = []string{KeyOptVerifyRequired}
+		So(k.HasOption(KeyOptVerifyRequired), ShouldBeTrue)
+		So(k.HasOption(KeyOptNoTouchRequired), ShouldBeFalse)
+		u := User{Keys: []Key{*k}}
+		So(u.Key(testsk_value), ShouldNotBeNil)
+		So(u.Key(testpk_value), ShouldBeNil)
+	})
 }
 
 func TestRoles(t *testing.T) {
@@ -33,3 +68,45 @@ func TestRoles(t *testing.T) {
 		So(r.Has(Role("d")), ShouldBeFalse)
 	})
 }
+
+func TestTotp(t *testing.T) {
+	Convey("Check TOTP codes", t, func() {
+		sec, err := NewTotpSecret()
+		So(err, ShouldBeNil)
+		So(len(sec), ShouldEqual, 32)
+		now := time.Now()
+		step := now.Unix() / TotpStep
+		code := fmt.Sprintf("%06d", dgoogauth.ComputeCode(sec, step-1))
+		s, err := TotpCodeStep(sec, code, now)
+		So(err, ShouldBeNil)
+		So(s, ShouldEqual, step-1)
+		_, err = TotpCodeStep(sec, code, now.Add(3*TotpStep*time.Second))
+		So(err, ShouldNotBeNil)
+		_, err = TotpCodeStep(sec, "12345", now)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Create scratch codes", t, func() {
+		codes, err := NewScratchCodes()
+		So(err, ShouldBeNil)
+		So(len(codes), ShouldEqual, ScratchCodeCount)
+		for _, c := range codes {
+			So(IsScratchCode(c), ShouldBeTrue)
+		}
+		So(IsScratchCode("123456"), ShouldBeFalse)
+		h1, err := HashScratchCodes(codes[:2])
+		So(err, ShouldBeNil)
+		h2, err := HashScratchCodes(codes[:1])
+		So(err, ShouldBeNil)
+		So(h1[0].Salt, ShouldEqual, h1[1].Salt)
+		So(h1[0].Salt, ShouldNotEqual, h2[0].Salt)
+		So(h1[0].Hash, ShouldNotEqual, h2[0].Hash)
+		So(h1[0].Hash, ShouldNotContainSubstring, codes[0])
+		So(h1[0].Matches(codes[0]), ShouldBeTrue)
+		So(h2[0].Matches(codes[0]), ShouldBeTrue)
+		So(h1[0].Matches(codes[1]), ShouldBeFalse)
+		// any code of the user tells the hash of another one
+		h, err := h1[0].HashOf(codes[1])
+		So(err, ShouldBeNil)
+		So(h, ShouldEqual, h1[1].Hash)
+	})
+}